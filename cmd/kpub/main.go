@@ -16,12 +16,18 @@ import (
 	"github.com/spacesedan/kpub/internal/cli"
 	"github.com/spacesedan/kpub/internal/config"
 	"github.com/spacesedan/kpub/internal/dockerutil"
+	"github.com/spacesedan/kpub/internal/history"
+	"github.com/spacesedan/kpub/internal/importer"
+	"github.com/spacesedan/kpub/internal/monitor"
 	"github.com/spacesedan/kpub/internal/supervisor"
 )
 
 var version = "dev"
 
-const imageName = "ghcr.io/spacesedan/kpub"
+const (
+	imageName    = "ghcr.io/spacesedan/kpub"
+	defaultImage = imageName + ":latest"
+)
 
 // defaultDataDir returns ~/.config/kpub, creating it if needed.
 func defaultDataDir() string {
@@ -39,7 +45,28 @@ func main() {
 		Version: version,
 		RunE:    runServer,
 	}
-	rootCmd.Flags().String("config", "/data/config.yaml", "path to config file")
+	rootCmd.Flags().String("config", "/data/config.yaml", `path to config file, "-" for stdin, or "env" to read $KPUB_CONFIG`)
+	rootCmd.Flags().String("config-dir", "", "directory of YAML files to merge: config.yaml plus chat fragments (overrides --config)")
+
+	// --- login ---
+	loginCmd := &cobra.Command{
+		Use:   "login",
+		Short: "Log in to Telegram interactively, save the session, and exit",
+		RunE:  runLogin,
+	}
+	loginCmd.Flags().String("config", "/data/config.yaml", `path to config file, "-" for stdin, or "env" to read $KPUB_CONFIG`)
+	loginCmd.Flags().String("config-dir", "", "directory of YAML files to merge (overrides --config)")
+
+	// --- import-dir ---
+	importCmd := &cobra.Command{
+		Use:   "import-dir <path>",
+		Short: "Convert and upload a folder of existing ebooks, without Telegram",
+		Args:  cobra.ExactArgs(1),
+		RunE:  runImportDir,
+	}
+	importCmd.Flags().String("config", "/data/config.yaml", `path to config file, "-" for stdin, or "env" to read $KPUB_CONFIG`)
+	importCmd.Flags().String("config-dir", "", "directory of YAML files to merge (overrides --config)")
+	importCmd.Flags().String("chat", "", "use this chat's formats and storage instead of the defaults")
 
 	// --- setup ---
 	setupCmd := &cobra.Command{
@@ -48,6 +75,8 @@ func main() {
 		RunE:  runSetup,
 	}
 	setupCmd.Flags().String("data-dir", defaultDataDir(), "directory for config.yaml and dropbox.json")
+	setupCmd.Flags().Bool("defaults", false, "only ask for credentials and chats, and save without review")
+	setupCmd.Flags().Bool("force", false, "overwrite existing config.yaml and dropbox.json")
 
 	// --- run ---
 	runCmd := &cobra.Command{
@@ -57,6 +86,11 @@ func main() {
 	}
 	runCmd.Flags().String("data-dir", defaultDataDir(), "directory to bind-mount as /data")
 	runCmd.Flags().BoolP("detach", "d", false, "run container in the background")
+	runCmd.Flags().Bool("force", false, "replace the kpub container even if it is already running")
+	runCmd.Flags().String("image", defaultImage, "container image to pull and run")
+	runCmd.Flags().Duration("wait", 0, "with --detach, wait up to this long for the server to connect to Telegram (e.g. 60s)")
+	runCmd.Flags().String("registry-auth", "", "registry credentials as user:password (default: from ~/.docker/config.json)")
+	runCmd.Flags().Bool("foreground-logs", false, "show the server's logs in a scrolling viewer with the processing status pinned, instead of raw output")
 
 	// --- update ---
 	updateCmd := &cobra.Command{
@@ -66,6 +100,8 @@ func main() {
 	}
 	updateCmd.Flags().Bool("restart", false, "restart container after pulling")
 	updateCmd.Flags().String("data-dir", defaultDataDir(), "directory to bind-mount as /data (used with --restart)")
+	updateCmd.Flags().String("image", defaultImage, "container image to pull")
+	updateCmd.Flags().String("registry-auth", "", "registry credentials as user:password (default: from ~/.docker/config.json)")
 
 	// --- stop ---
 	stopCmd := &cobra.Command{
@@ -81,6 +117,58 @@ func main() {
 		RunE:  runReload,
 	}
 	reloadCmd.Flags().String("data-dir", defaultDataDir(), "directory to bind-mount as /data")
+	reloadCmd.Flags().String("image", defaultImage, "container image to run")
+
+	// --- uninstall ---
+	uninstallCmd := &cobra.Command{
+		Use:   "uninstall",
+		Short: "Remove the container, and optionally the image and data",
+		RunE:  runUninstall,
+	}
+	uninstallCmd.Flags().String("data-dir", defaultDataDir(), "kpub's data directory")
+	uninstallCmd.Flags().String("image", defaultImage, "container image to remove (with --purge-image)")
+	uninstallCmd.Flags().Bool("purge-image", false, "also remove the container image")
+	uninstallCmd.Flags().Bool("purge-data", false, "also delete the data directory, including the session and tokens")
+
+	// --- history ---
+	historyCmd := &cobra.Command{
+		Use:   "history",
+		Short: "Show recently delivered, failed, and skipped files",
+		RunE:  runHistory,
+	}
+	historyCmd.Flags().String("data-dir", defaultDataDir(), "directory containing history.jsonl")
+	historyCmd.Flags().Bool("skipped", false, "only show skipped files and why they were skipped")
+	historyCmd.Flags().Int("limit", 50, "number of most recent entries to show (0 for all)")
+
+	// --- prune ---
+	pruneCmd := &cobra.Command{
+		Use:   "prune",
+		Short: "Trim the history log and clear stale upload state",
+		RunE:  runPrune,
+	}
+	pruneCmd.Flags().String("data-dir", defaultDataDir(), "directory containing config.yaml and history.jsonl")
+	pruneCmd.Flags().String("older-than", "", "remove history entries older than this (e.g. 90d, 2160h, 2025-01-01)")
+	pruneCmd.Flags().Bool("vacuum", false, "remove unreadable history lines and expired upload resume tokens")
+	pruneCmd.Flags().Bool("dedup-clear", false, "forget every delivered file, so backfill and import-dir send them again")
+	pruneCmd.Flags().BoolP("yes", "y", false, "don't ask for confirmation")
+	pruneCmd.Flags().Bool("force", false, "prune even though the kpub container is running")
+
+	// --- meta ---
+	metaCmd := &cobra.Command{
+		Use:   "meta <file>",
+		Short: "Show the title, authors, series and cover of an ebook",
+		Args:  cobra.ExactArgs(1),
+		RunE:  runMeta,
+	}
+
+	// --- debug-info ---
+	debugInfoCmd := &cobra.Command{
+		Use:   "debug-info",
+		Short: "Print versions, container state and the config with secrets masked, for bug reports",
+		RunE:  runDebugInfo,
+	}
+	debugInfoCmd.Flags().String("data-dir", defaultDataDir(), "directory containing config.yaml")
+	debugInfoCmd.Flags().String("image", defaultImage, "container image to inspect")
 
 	// --- chat ---
 	chatCmd := &cobra.Command{
@@ -108,9 +196,16 @@ func main() {
 		RunE:  runChatRemove,
 	}
 
-	chatCmd.AddCommand(chatAddCmd, chatListCmd, chatRemoveCmd)
+	chatTestCmd := &cobra.Command{
+		Use:   "test [@handle] [message-link]",
+		Short: "Dry-run a chat's filters against a message (default: its latest file)",
+		Args:  cobra.RangeArgs(1, 2),
+		RunE:  runChatTest,
+	}
+
+	chatCmd.AddCommand(chatAddCmd, chatListCmd, chatRemoveCmd, chatTestCmd)
 
-	rootCmd.AddCommand(setupCmd, runCmd, stopCmd, reloadCmd, updateCmd, chatCmd)
+	rootCmd.AddCommand(loginCmd, importCmd, setupCmd, runCmd, stopCmd, reloadCmd, updateCmd, uninstallCmd, historyCmd, pruneCmd, metaCmd, debugInfoCmd, chatCmd)
 
 	if err := rootCmd.Execute(); err != nil {
 		os.Exit(1)
@@ -125,6 +220,9 @@ func runServer(cmd *cobra.Command, args []string) error {
 	})))
 
 	configPath, _ := cmd.Flags().GetString("config")
+	if dir, _ := cmd.Flags().GetString("config-dir"); dir != "" {
+		configPath = dir
+	}
 
 	cfg, err := config.Load(configPath)
 	if err != nil {
@@ -136,14 +234,109 @@ func runServer(cmd *cobra.Command, args []string) error {
 	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	defer cancel()
 
-	sv := supervisor.New(configPath, cfg, ctx)
+	sv := supervisor.New(configPath, cfg, version, ctx)
 	return sv.Run()
 }
 
+// runLogin authenticates with Telegram and writes the session file without
+// starting the monitor, so the server can later run without a terminal.
+func runLogin(cmd *cobra.Command, args []string) error {
+	slog.SetDefault(slog.New(tint.NewHandler(os.Stderr, nil)))
+
+	configPath, _ := cmd.Flags().GetString("config")
+	if dir, _ := cmd.Flags().GetString("config-dir"); dir != "" {
+		configPath = dir
+	}
+
+	cfg, err := config.Load(configPath)
+	if err != nil {
+		return fmt.Errorf("loading config: %w", err)
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	m := monitor.New(
+		cfg.Telegram.AppID,
+		cfg.Telegram.AppHash,
+		cfg.Telegram.SessionFile,
+		cfg.Paths.DownloadDir,
+		cfg.Paths.ConvertedDir,
+		monitor.Options{
+			SessionPassphrase: os.Getenv(config.SessionPassphraseVar),
+			TestDC:            cfg.Telegram.TestDC,
+			DC:                cfg.Telegram.DC,
+			Device:            cfg.Telegram.Device,
+			Version:           version,
+		},
+	)
+	if err := m.Login(ctx); err != nil {
+		return err
+	}
+
+	fmt.Println("\n  " + cli.Success.Render("Session saved to "+cfg.Telegram.SessionFile))
+	return nil
+}
+
+// runImportDir converts and uploads every accepted file under a directory,
+// printing a line per file as it finishes.
+func runImportDir(cmd *cobra.Command, args []string) error {
+	slog.SetDefault(slog.New(tint.NewHandler(os.Stderr, &tint.Options{Level: slog.LevelWarn})))
+
+	configPath, _ := cmd.Flags().GetString("config")
+	if dir, _ := cmd.Flags().GetString("config-dir"); dir != "" {
+		configPath = dir
+	}
+	handle, _ := cmd.Flags().GetString("chat")
+
+	cfg, err := config.Load(configPath)
+	if err != nil {
+		return fmt.Errorf("loading config: %w", err)
+	}
+
+	im, err := importer.New(cfg, handle)
+	if err != nil {
+		return err
+	}
+	files, err := im.Scan(args[0])
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		fmt.Println("\n  No accepted files found in " + args[0])
+		return nil
+	}
+	fmt.Printf("\n  Importing %d file(s) from %s\n\n", len(files), args[0])
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	failed, err := im.Run(ctx, files, func(done, total int, r importer.Result) {
+		line := fmt.Sprintf("[%d/%d] %s", done, total, r.File)
+		switch r.Status {
+		case history.Delivered:
+			fmt.Println("  " + cli.Success.Render(line))
+		case history.Skipped:
+			fmt.Println("  " + cli.Dim.Render(line+" (skipped: "+r.Reason+")"))
+		default:
+			fmt.Println("  " + cli.Error.Render(line+": "+r.Reason))
+		}
+	})
+	if err != nil {
+		return err
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d file(s) failed", failed, len(files))
+	}
+	return nil
+}
+
 // runSetup launches the interactive setup wizard TUI.
 func runSetup(cmd *cobra.Command, args []string) error {
 	dataDir, _ := cmd.Flags().GetString("data-dir")
-	m := cli.NewSetupModel(dataDir)
+	useDefaults, _ := cmd.Flags().GetBool("defaults")
+	force, _ := cmd.Flags().GetBool("force")
+	m := cli.NewSetupModel(dataDir, useDefaults, force)
 	p := tea.NewProgram(m)
 	if _, err := p.Run(); err != nil {
 		return fmt.Errorf("setup wizard: %w", err)
@@ -159,6 +352,14 @@ func runRun(cmd *cobra.Command, args []string) error {
 
 	dataDir, _ := cmd.Flags().GetString("data-dir")
 	detach, _ := cmd.Flags().GetBool("detach")
+	force, _ := cmd.Flags().GetBool("force")
+	image, _ := cmd.Flags().GetString("image")
+	registryAuth, _ := cmd.Flags().GetString("registry-auth")
+	wait, _ := cmd.Flags().GetDuration("wait")
+	foregroundLogs, _ := cmd.Flags().GetBool("foreground-logs")
+	if foregroundLogs && detach {
+		return fmt.Errorf("--foreground-logs can't be used with --detach")
+	}
 
 	// Resolve to absolute path for the bind mount.
 	absDataDir, err := filepath.Abs(dataDir)
@@ -166,8 +367,12 @@ func runRun(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("resolving data-dir: %w", err)
 	}
 
-	image := imageName + ":latest"
-	m := cli.NewRunModel(absDataDir, detach, image)
+	auth, err := dockerutil.RegistryAuth(image, registryAuth)
+	if err != nil {
+		return fmt.Errorf("loading registry credentials: %w", err)
+	}
+
+	m := cli.NewRunModel(absDataDir, detach, force, image, auth, wait)
 	p := tea.NewProgram(m)
 	result, err := p.Run()
 	if err != nil {
@@ -177,6 +382,9 @@ func runRun(cmd *cobra.Command, args []string) error {
 	// For foreground mode: Bubbletea exits after pull, then we hand off to docker run.
 	rm := result.(cli.RunModel)
 	if rm.NeedsForegroundRun() {
+		if foregroundLogs {
+			return cli.RunWithLogs(image, absDataDir)
+		}
 		return cli.RunForeground(image, absDataDir)
 	}
 	if rm.Err() != nil {
@@ -194,14 +402,20 @@ func runUpdate(cmd *cobra.Command, args []string) error {
 
 	dataDir, _ := cmd.Flags().GetString("data-dir")
 	restart, _ := cmd.Flags().GetBool("restart")
+	image, _ := cmd.Flags().GetString("image")
+	registryAuth, _ := cmd.Flags().GetString("registry-auth")
 
 	absDataDir, err := filepath.Abs(dataDir)
 	if err != nil {
 		return fmt.Errorf("resolving data-dir: %w", err)
 	}
 
-	image := imageName + ":latest"
-	m := cli.NewUpdateModel(absDataDir, restart, image)
+	auth, err := dockerutil.RegistryAuth(image, registryAuth)
+	if err != nil {
+		return fmt.Errorf("loading registry credentials: %w", err)
+	}
+
+	m := cli.NewUpdateModel(absDataDir, restart, image, auth)
 	p := tea.NewProgram(m)
 	result, err := p.Run()
 	if err != nil {
@@ -216,6 +430,38 @@ func runUpdate(cmd *cobra.Command, args []string) error {
 	return nil
 }
 
+// runHistory prints the history log.
+func runHistory(cmd *cobra.Command, args []string) error {
+	dataDir, _ := cmd.Flags().GetString("data-dir")
+	skipped, _ := cmd.Flags().GetBool("skipped")
+	limit, _ := cmd.Flags().GetInt("limit")
+	return cli.ShowHistory(dataDir, skipped, limit)
+}
+
+// runPrune trims the history log.
+func runPrune(cmd *cobra.Command, args []string) error {
+	dataDir, _ := cmd.Flags().GetString("data-dir")
+	var opts cli.PruneOptions
+	opts.OlderThan, _ = cmd.Flags().GetString("older-than")
+	opts.Vacuum, _ = cmd.Flags().GetBool("vacuum")
+	opts.DedupClear, _ = cmd.Flags().GetBool("dedup-clear")
+	opts.Yes, _ = cmd.Flags().GetBool("yes")
+	opts.Force, _ = cmd.Flags().GetBool("force")
+	return cli.Prune(dataDir, opts)
+}
+
+// runMeta prints an ebook's metadata.
+func runMeta(cmd *cobra.Command, args []string) error {
+	return cli.ShowMeta(cmd.Context(), args[0])
+}
+
+// runDebugInfo prints diagnostics for a bug report.
+func runDebugInfo(cmd *cobra.Command, args []string) error {
+	dataDir, _ := cmd.Flags().GetString("data-dir")
+	image, _ := cmd.Flags().GetString("image")
+	return cli.DebugInfo(dataDir, image, version)
+}
+
 // runChatAdd launches the interactive TUI to add a new chat.
 func runChatAdd(cmd *cobra.Command, args []string) error {
 	dataDir, _ := cmd.Flags().GetString("data-dir")
@@ -239,6 +485,16 @@ func runChatRemove(cmd *cobra.Command, args []string) error {
 	return cli.RemoveChat(dataDir, args[0])
 }
 
+// runChatTest reports whether a chat's filters would accept a message.
+func runChatTest(cmd *cobra.Command, args []string) error {
+	dataDir, _ := cmd.Flags().GetString("data-dir")
+	var messageRef string
+	if len(args) > 1 {
+		messageRef = args[1]
+	}
+	return cli.TestChat(dataDir, args[0], messageRef, version)
+}
+
 const containerName = "kpub"
 
 // runStop gracefully stops the running container.
@@ -255,6 +511,15 @@ func runStop(cmd *cobra.Command, args []string) error {
 	return nil
 }
 
+// runUninstall removes the container and, if asked, the image and data.
+func runUninstall(cmd *cobra.Command, args []string) error {
+	dataDir, _ := cmd.Flags().GetString("data-dir")
+	image, _ := cmd.Flags().GetString("image")
+	purgeImage, _ := cmd.Flags().GetBool("purge-image")
+	purgeData, _ := cmd.Flags().GetBool("purge-data")
+	return cli.Uninstall(containerName, image, dataDir, purgeImage, purgeData)
+}
+
 // runReload restarts the container to pick up config changes.
 func runReload(cmd *cobra.Command, args []string) error {
 	if err := dockerutil.CheckDocker(); err != nil {
@@ -271,7 +536,7 @@ func runReload(cmd *cobra.Command, args []string) error {
 		return err
 	}
 
-	image := imageName + ":latest"
+	image, _ := cmd.Flags().GetString("image")
 	if err := dockerutil.RunContainer(containerName, image, absDataDir, true); err != nil {
 		return err
 	}
@@ -103,14 +103,14 @@ func (m AddChatModel) updateInput(msg tea.Msg) (tea.Model, tea.Cmd) {
 			m.inputErr = "Value cannot be empty"
 			return m, nil
 		}
-		if !strings.HasPrefix(val, "@") {
-			m.inputErr = "Handle must start with @"
+		if _, err := config.ParseChatRef(val); err != nil {
+			m.inputErr = "Handle must be an @handle, a chat ID like -100123456, or a t.me link"
 			return m, nil
 		}
 
 		// Validate duplicate handle
 		for _, chat := range m.cfg.Chats {
-			if chat.Handle == val {
+			if config.HandleKey(chat.Handle) == config.HandleKey(val) {
 				m.inputErr = fmt.Sprintf("Chat %q already exists", val)
 				return m, nil
 			}
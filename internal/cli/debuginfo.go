@@ -0,0 +1,187 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/spacesedan/kpub/internal/config"
+	"github.com/spacesedan/kpub/internal/dockerutil"
+	"github.com/spacesedan/kpub/internal/monitor"
+)
+
+// redacted replaces secrets in DebugInfo's config dump.
+const redacted = "<redacted>"
+
+// DebugInfo prints what a bug report usually needs: versions, the state of
+// the container and image, Calibre in the image and on the host, whether
+// the session and Dropbox tokens look usable, and the effective config with
+// secrets masked. It only reads; nothing is pulled, started or refreshed.
+// The output is a Markdown code block, ready to paste into an issue.
+func DebugInfo(dataDir, image, version string) error {
+	fmt.Println("```")
+	line := func(name, value string) { fmt.Printf("%-20s %s\n", name, value) }
+
+	line("kpub", version)
+	line("platform", fmt.Sprintf("%s/%s (%s)", runtime.GOOS, runtime.GOARCH, runtime.Version()))
+
+	if err := dockerutil.CheckDocker(); err != nil {
+		line("docker", "not found")
+	} else {
+		v, err := dockerutil.Version()
+		if err != nil {
+			v = "error: " + err.Error()
+		}
+		line("docker", v)
+
+		state, err := dockerutil.ContainerStatus("kpub")
+		if err != nil {
+			state = dockerutil.ContainerState("error: " + err.Error())
+		}
+		line("container", string(state))
+
+		if dockerutil.ImageExists(image) {
+			line("image", image)
+			line("ebook-convert", firstLine(dockerutil.RunInImage(image, "ebook-convert", "--version")))
+			line("kepubify", firstLine(dockerutil.RunInImage(image, "kepubify", "--version")))
+		} else {
+			line("image", image+" (not pulled)")
+		}
+	}
+	if _, err := exec.LookPath("ebook-convert"); err != nil {
+		line("ebook-convert (host)", "not installed")
+	} else {
+		out, err := exec.Command("ebook-convert", "--version").Output()
+		line("ebook-convert (host)", firstLine(string(out), err))
+	}
+
+	line("data dir", dataDir)
+	configPath := filepath.Join(dataDir, "config.yaml")
+	cfg, err := config.Load(configPath)
+	if err != nil {
+		line("config", "error: "+err.Error())
+		fmt.Println("```")
+		return nil
+	}
+	line("config", fmt.Sprintf("%s (%d chat(s))", configPath, len(cfg.Chats)))
+	line("session", sessionStatus(hostPath(dataDir, cfg.Telegram.SessionFile)))
+
+	seen := make(map[string]bool)
+	for _, chat := range cfg.Chats {
+		storage := config.ResolvedChatConfig(cfg, chat).Storage
+		if f := storage.Dropbox.TokenFile; storage.Type == "dropbox" && !seen[f] {
+			seen[f] = true
+			line("dropbox token", f+": "+tokenStatus(hostPath(dataDir, f)))
+		}
+	}
+
+	maskSecrets(cfg)
+	out, err := yaml.Marshal(cfg)
+	if err != nil {
+		return fmt.Errorf("encoding config: %w", err)
+	}
+	fmt.Println()
+	fmt.Println("# Effective config, secrets masked")
+	fmt.Print(string(out))
+	fmt.Println("```")
+	return nil
+}
+
+// hostPath maps a path in the container's /data, which is dataDir on the
+// host, to the host path. Other paths are returned as they are.
+func hostPath(dataDir, p string) string {
+	if rel, ok := strings.CutPrefix(p, "/data/"); ok {
+		return filepath.Join(dataDir, rel)
+	}
+	return p
+}
+
+// dataPaths returns the host paths of the history log and the upload state
+// directory, as set in dataDir's config. The defaults are used when the
+// config can't be loaded.
+func dataPaths(dataDir string) (historyPath, uploadStateDir string) {
+	historyPath = filepath.Join(dataDir, "history.jsonl")
+	uploadStateDir = filepath.Join(dataDir, "uploads")
+	if cfg, err := config.Load(filepath.Join(dataDir, "config.yaml")); err == nil {
+		historyPath = hostPath(dataDir, cfg.Paths.HistoryFile)
+		uploadStateDir = hostPath(dataDir, cfg.Paths.UploadStateDir)
+	}
+	return historyPath, uploadStateDir
+}
+
+// firstLine returns the first line of a command's output, or its error.
+func firstLine(out string, err error) string {
+	if err != nil {
+		return "error: " + err.Error()
+	}
+	first, _, _ := strings.Cut(strings.TrimSpace(out), "\n")
+	return first
+}
+
+func sessionStatus(p string) string {
+	data, err := os.ReadFile(p)
+	switch {
+	case os.IsNotExist(err):
+		return "missing (run kpub login)"
+	case err != nil:
+		return "error: " + err.Error()
+	case monitor.IsEncryptedSession(data):
+		return fmt.Sprintf("present, encrypted (%d bytes)", len(data))
+	}
+	return fmt.Sprintf("present (%d bytes)", len(data))
+}
+
+// tokenStatus checks that a Dropbox token file holds both tokens, without
+// using them.
+func tokenStatus(p string) string {
+	data, err := os.ReadFile(p)
+	switch {
+	case os.IsNotExist(err):
+		return "missing (run kpub setup)"
+	case err != nil:
+		return "error: " + err.Error()
+	}
+	var tokens struct {
+		AccessToken  string `json:"access_token"`
+		RefreshToken string `json:"refresh_token"`
+	}
+	if err := json.Unmarshal(data, &tokens); err != nil {
+		return "invalid JSON: " + err.Error()
+	}
+	if tokens.AccessToken == "" || tokens.RefreshToken == "" {
+		return "missing access_token or refresh_token"
+	}
+	return "valid"
+}
+
+// maskSecrets replaces every credential in cfg with a placeholder, leaving
+// empty ones empty so it's still visible which are set.
+func maskSecrets(cfg *config.Config) {
+	mask := func(s *string) {
+		if *s != "" {
+			*s = redacted
+		}
+	}
+	mask(&cfg.Telegram.AppHash)
+	mask(&cfg.Telegram.NotifyBotToken)
+
+	storages := []*config.StorageConfig{&cfg.Defaults.Storage}
+	for _, chat := range cfg.Chats {
+		if chat.Storage != nil {
+			storages = append(storages, chat.Storage)
+		}
+	}
+	for _, s := range storages {
+		mask(&s.Dropbox.AppKey)
+		mask(&s.Dropbox.AppSecret)
+		mask(&s.Email.Password)
+		mask(&s.B2.KeyID)
+		mask(&s.B2.ApplicationKey)
+	}
+}
@@ -0,0 +1,60 @@
+package cli
+
+import (
+	"fmt"
+
+	"github.com/spacesedan/kpub/internal/history"
+)
+
+// ShowHistory prints the most recent limit entries from the history log,
+// found through dataDir's config, newest last. With skippedOnly, only
+// skipped files are shown.
+func ShowHistory(dataDir string, skippedOnly bool, limit int) error {
+	historyPath, _ := dataPaths(dataDir)
+	entries, err := history.Read(historyPath)
+	if err != nil {
+		return err
+	}
+
+	if skippedOnly {
+		var skipped []history.Entry
+		for _, e := range entries {
+			if e.Status == history.Skipped {
+				skipped = append(skipped, e)
+			}
+		}
+		entries = skipped
+	}
+	if limit > 0 && len(entries) > limit {
+		entries = entries[len(entries)-limit:]
+	}
+
+	if len(entries) == 0 {
+		fmt.Println(Warning.Render("No history yet."))
+		return nil
+	}
+
+	fmt.Println()
+	for _, e := range entries {
+		status := string(e.Status)
+		switch e.Status {
+		case history.Delivered:
+			status = Success.Render(status)
+		case history.Failed:
+			status = Error.Render(status)
+		case history.Skipped:
+			status = Warning.Render(status)
+		}
+
+		name := e.FileName
+		if name == "" {
+			name = "(no filename)"
+		}
+		fmt.Printf("  %s  %-9s  %s  %s\n", Dim.Render(e.Time.Local().Format("2006-01-02 15:04")), status, Highlight.Render(e.Chat), name)
+		if e.Reason != "" {
+			fmt.Printf("  %s\n", Dim.Render("  "+e.Reason))
+		}
+	}
+	fmt.Println()
+	return nil
+}
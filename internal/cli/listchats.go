@@ -24,7 +24,11 @@ func ListChats(dataDir string) error {
 	fmt.Println("  " + Title.Render("Monitored chats:"))
 	fmt.Println()
 	for i, chat := range cfg.Chats {
-		fmt.Printf("  %s\n", Highlight.Render(fmt.Sprintf("%d. %s", i+1, chat.Handle)))
+		line := Highlight.Render(fmt.Sprintf("%d. %s", i+1, chat.Handle))
+		if chat.Enabled != nil && !*chat.Enabled {
+			line += " " + Dim.Render("(disabled)")
+		}
+		fmt.Printf("  %s\n", line)
 	}
 	fmt.Println()
 	return nil
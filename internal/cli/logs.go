@@ -0,0 +1,315 @@
+package cli
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/viewport"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+
+	"github.com/spacesedan/kpub/internal/dockerutil"
+	"github.com/spacesedan/kpub/internal/monitor"
+)
+
+// maxLogLines is how many log lines the viewer keeps for scrolling back.
+const maxLogLines = 2000
+
+var (
+	ansiEscape = regexp.MustCompile(`\x1b\[[0-9;]*m`)
+	// tintLine matches a line from the server's tint handler:
+	// "Jan  2 15:04:05.000 INF monitor/monitor.go:730 File received key=value".
+	tintLine  = regexp.MustCompile(`^(\w{3} [ \d]\d \d\d:\d\d:\d\d\.\d{3}) (DBG|INF|WRN|ERR)[+-]?\d* (.*)$`)
+	tintAttrs = regexp.MustCompile(` [A-Za-z_][\w.]*=`)
+	tintSrc   = regexp.MustCompile(`^\S+\.go:\d+ `)
+)
+
+// logEntry is one parsed server log line. Lines that aren't from the
+// logger, such as a panic, have only text.
+type logEntry struct {
+	time    string
+	level   string
+	message string
+	attrs   string
+	text    string
+}
+
+// parseLogLine splits a tint-formatted line into its parts, dropping colors
+// and the source location.
+func parseLogLine(line string) logEntry {
+	line = ansiEscape.ReplaceAllString(line, "")
+	m := tintLine.FindStringSubmatch(line)
+	if m == nil {
+		return logEntry{text: line}
+	}
+	e := logEntry{time: m[1], level: m[2]}
+	rest := tintSrc.ReplaceAllString(m[3], "")
+	if loc := tintAttrs.FindStringIndex(rest); loc != nil {
+		e.message, e.attrs = rest[:loc[0]], rest[loc[0]+1:]
+	} else {
+		e.message = rest
+	}
+	return e
+}
+
+// attr returns the value of key in e's attributes, unquoted, or "".
+func (e logEntry) attr(key string) string {
+	re := regexp.MustCompile(`(?:^| )` + regexp.QuoteMeta(key) + `=("(?:[^"\\]|\\.)*"|\S*)`)
+	m := re.FindStringSubmatch(e.attrs)
+	if m == nil {
+		return ""
+	}
+	if v, err := strconv.Unquote(m[1]); err == nil {
+		return v
+	}
+	return m[1]
+}
+
+func (e logEntry) render() string {
+	if e.level == "" {
+		return e.text
+	}
+	level := e.level
+	switch e.level {
+	case "DBG":
+		level = Dim.Render(level)
+	case "INF":
+		level = Success.Render(level)
+	case "WRN":
+		level = Warning.Render(level)
+	case "ERR":
+		level = Error.Render(level)
+	}
+	line := Dim.Render(e.time) + " " + level + " " + e.message
+	if e.attrs != "" {
+		line += " " + Dim.Render(e.attrs)
+	}
+	return line
+}
+
+// pipelineStatus follows the monitor's log lines to summarise what the
+// server is doing, for the viewer's pinned header.
+type pipelineStatus struct {
+	connected bool
+	active    int
+	delivered int
+	failed    int
+	current   string // latest pipeline step, e.g. "Downloading book.epub"
+}
+
+func (s *pipelineStatus) update(e logEntry) {
+	file := e.attr("fileName")
+	step := func(label string) {
+		s.current = label
+		if file != "" {
+			s.current += " " + file
+		}
+	}
+	switch {
+	case strings.HasPrefix(e.message, readyLogLine):
+		s.connected = true
+	case e.message == "File received, starting process":
+		s.active++
+		step("Received")
+	case e.message == "Downloading":
+		step("Downloading")
+	case strings.HasPrefix(e.message, "Download complete, converting"):
+		step("Converting")
+	case strings.HasPrefix(e.message, "Conversion complete, uploading"):
+		step("Uploading")
+	case strings.HasPrefix(e.message, "Success! Pipeline complete"):
+		s.active = max(s.active-1, 0)
+		s.delivered++
+		step("Delivered")
+	case e.level == "ERR" && (strings.HasPrefix(e.message, "Failed to ") || strings.HasPrefix(e.message, "Post-process hook failed")):
+		s.active = max(s.active-1, 0)
+		s.failed++
+		step("Failed")
+	}
+}
+
+func (s pipelineStatus) render() string {
+	state := Warning.Render("connecting")
+	if s.connected {
+		state = Success.Render("connected")
+	}
+	counts := fmt.Sprintf("%d in progress · %d delivered · ", s.active, s.delivered)
+	failed := fmt.Sprintf("%d failed", s.failed)
+	if s.failed > 0 {
+		failed = Error.Render(failed)
+	}
+	current := s.current
+	if current == "" {
+		current = "Waiting for files"
+	}
+	return Title.Render("kpub") + "  " + state + "  " + counts + failed + "\n" + Dim.Render(current)
+}
+
+// logLineMsg carries one line of the container's logs.
+type logLineMsg string
+
+// logsEndedMsg signals the container's log stream has ended.
+type logsEndedMsg struct{ err error }
+
+// containerStoppedMsg signals the viewer has stopped the container.
+type containerStoppedMsg struct{ err error }
+
+// LogsModel is the Bubbletea model for `kpub run --foreground-logs`: it
+// follows the container's logs in a scrolling, level-colored panel with
+// the processing status pinned above it. Quitting stops the container,
+// as Ctrl+C does in a plain foreground run.
+type LogsModel struct {
+	name     string
+	lines    []string
+	status   pipelineStatus
+	viewport viewport.Model
+	ready    bool
+	outputCh chan string
+	ended    bool
+	stopping bool
+	err      error
+}
+
+// NewLogsModel creates a log viewer for the named container.
+func NewLogsModel(name string) LogsModel {
+	return LogsModel{name: name, outputCh: make(chan string, 256)}
+}
+
+func (m LogsModel) Init() tea.Cmd {
+	ch := m.outputCh
+	name := m.name
+	follow := func() tea.Msg {
+		err := dockerutil.FollowLogs(name, ch)
+		close(ch)
+		return logsEndedMsg{err: err}
+	}
+	return tea.Batch(follow, m.listenOutput())
+}
+
+func (m LogsModel) listenOutput() tea.Cmd {
+	ch := m.outputCh
+	return func() tea.Msg {
+		line, ok := <-ch
+		if !ok {
+			return nil
+		}
+		return logLineMsg(line)
+	}
+}
+
+func (m LogsModel) stopContainer() tea.Cmd {
+	name := m.name
+	return func() tea.Msg {
+		return containerStoppedMsg{err: dockerutil.StopContainer(name)}
+	}
+}
+
+func (m LogsModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.KeyMsg:
+		switch msg.String() {
+		case "ctrl+c", "q":
+			if m.ended {
+				return m, tea.Quit
+			}
+			if !m.stopping {
+				m.stopping = true
+				return m, m.stopContainer()
+			}
+			return m, nil
+		case "end", "G":
+			m.viewport.GotoBottom()
+			return m, nil
+		}
+
+	case tea.WindowSizeMsg:
+		header := lipgloss.Height(m.header())
+		height := max(msg.Height-header-2, 1)
+		if !m.ready {
+			m.viewport = viewport.New(msg.Width, height)
+			m.ready = true
+		} else {
+			m.viewport.Width = msg.Width
+			m.viewport.Height = height
+		}
+		m.refresh(true)
+		return m, nil
+
+	case logLineMsg:
+		e := parseLogLine(string(msg))
+		m.status.update(e)
+		m.lines = append(m.lines, e.render())
+		if len(m.lines) > maxLogLines {
+			m.lines = m.lines[len(m.lines)-maxLogLines:]
+		}
+		m.refresh(m.viewport.AtBottom())
+		if strings.Contains(ansiEscape.ReplaceAllString(string(msg), ""), monitor.LoginRequiredMessage) {
+			m.err = fmt.Errorf("Telegram login required — run 'kpub login' or 'kpub run' without --foreground-logs once to sign in")
+			m.stopping = true
+			return m, m.stopContainer()
+		}
+		return m, m.listenOutput()
+
+	case logsEndedMsg:
+		m.ended = true
+		if msg.err != nil && m.err == nil && !m.stopping {
+			m.err = msg.err
+		}
+		if m.stopping {
+			return m, tea.Quit
+		}
+		return m, nil
+
+	case containerStoppedMsg:
+		if msg.err != nil && m.err == nil {
+			m.err = msg.err
+		}
+		if m.ended || m.err != nil {
+			return m, tea.Quit
+		}
+		return m, nil
+	}
+
+	var cmd tea.Cmd
+	m.viewport, cmd = m.viewport.Update(msg)
+	return m, cmd
+}
+
+// refresh puts the kept lines in the viewport, staying at the bottom if
+// follow is set, so new lines scroll in unless the user scrolled up.
+func (m *LogsModel) refresh(follow bool) {
+	if !m.ready {
+		return
+	}
+	m.viewport.SetContent(strings.Join(m.lines, "\n"))
+	if follow {
+		m.viewport.GotoBottom()
+	}
+}
+
+func (m LogsModel) header() string {
+	return m.status.render() + "\n" + Dim.Render(strings.Repeat("─", max(m.viewport.Width, 20)))
+}
+
+func (m LogsModel) View() string {
+	if !m.ready {
+		return "\n  Starting...\n"
+	}
+	var footer string
+	switch {
+	case m.stopping:
+		footer = Warning.Render("Stopping container...")
+	case m.ended:
+		footer = Warning.Render("Server stopped.") + " " + Dim.Render("q quit")
+	default:
+		footer = Dim.Render("↑/↓ scroll · G follow · q stop")
+	}
+	return m.header() + "\n" + m.viewport.View() + "\n" + footer
+}
+
+// Err returns any error that occurred.
+func (m LogsModel) Err() error {
+	return m.err
+}
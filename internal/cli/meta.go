@@ -0,0 +1,44 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/spacesedan/kpub/internal/converter"
+)
+
+// ShowMeta prints the title, authors, series and cover presence of the ebook
+// at path, as an e-reader would see them.
+func ShowMeta(ctx context.Context, path string) error {
+	md, err := converter.ReadMetadata(ctx, path)
+	if err != nil {
+		return err
+	}
+
+	field := func(name, value string) {
+		if value == "" {
+			value = Warning.Render("(none)")
+		}
+		fmt.Printf("  %s %s\n", Dim.Render(fmt.Sprintf("%-8s", name)), value)
+	}
+
+	series := md.Series
+	if series != "" && md.SeriesIndex != "" {
+		series += " #" + md.SeriesIndex
+	}
+	cover := Warning.Render("no")
+	if md.HasCover {
+		cover = Success.Render("yes")
+	}
+
+	fmt.Println()
+	fmt.Println("  " + Title.Render(path))
+	fmt.Println()
+	field("Title", md.Title)
+	field("Authors", strings.Join(md.Authors, ", "))
+	field("Series", series)
+	field("Cover", cover)
+	fmt.Println()
+	return nil
+}
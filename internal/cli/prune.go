@@ -0,0 +1,136 @@
+package cli
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spacesedan/kpub/internal/config"
+	"github.com/spacesedan/kpub/internal/dockerutil"
+	"github.com/spacesedan/kpub/internal/history"
+	"github.com/spacesedan/kpub/internal/storage"
+)
+
+// PruneOptions selects what Prune removes.
+type PruneOptions struct {
+	// OlderThan drops history entries older than this, in any form
+	// config.ParseSince accepts, e.g. "90d".
+	OlderThan string
+	// Vacuum drops history lines that don't parse and upload resume tokens
+	// too old to resume.
+	Vacuum bool
+	// DedupClear drops every delivered entry, so backfill and import-dir
+	// send those files again.
+	DedupClear bool
+	// Yes skips the confirmation.
+	Yes bool
+	// Force prunes even though the kpub container is running, which may
+	// lose entries it writes meanwhile.
+	Force bool
+}
+
+// Prune shrinks the history log, which also serves as the record of what
+// was delivered that backfill and import-dir skip, and clears out stale
+// upload state. What it is about to remove is listed and confirmed first.
+// It refuses while the kpub container is running unless opts.Force is set,
+// since the history is rewritten from a copy read beforehand.
+func Prune(dataDir string, opts PruneOptions) error {
+	if opts.OlderThan == "" && !opts.Vacuum && !opts.DedupClear {
+		return fmt.Errorf("nothing to prune: use --older-than, --vacuum or --dedup-clear")
+	}
+	running := dockerutil.IsContainerRunning("kpub")
+	if running && !opts.Force {
+		return fmt.Errorf("kpub is running and files it delivers while pruning would be left out of the history; stop it with 'kpub stop' first, or use --force")
+	}
+	var cutoff time.Time
+	if opts.OlderThan != "" {
+		var err error
+		if cutoff, err = config.ParseSince(opts.OlderThan, time.Now()); err != nil {
+			return fmt.Errorf("invalid --older-than: %w", err)
+		}
+	}
+
+	historyPath, uploadStateDir := dataPaths(dataDir)
+
+	lines, err := history.ReadLines(historyPath)
+	if err != nil {
+		return err
+	}
+	var kept []history.Line
+	var old, delivered, invalid int
+	for _, l := range lines {
+		switch {
+		case !l.Valid:
+			if opts.Vacuum {
+				invalid++
+				continue
+			}
+		case !cutoff.IsZero() && l.Entry.Time.Before(cutoff):
+			old++
+			continue
+		case opts.DedupClear && l.Entry.Status == history.Delivered:
+			delivered++
+			continue
+		}
+		kept = append(kept, l)
+	}
+
+	var tokens []string
+	if opts.Vacuum {
+		if tokens, err = storage.NewResumeStore(uploadStateDir).Stale(storage.SessionLifetime); err != nil {
+			return err
+		}
+	}
+
+	if old+delivered+invalid+len(tokens) == 0 {
+		fmt.Println("\n  " + Dim.Render("Nothing to prune."))
+		return nil
+	}
+
+	fmt.Println("\n  " + Title.Render("This will:"))
+	if old > 0 {
+		fmt.Printf("    - remove %d history entries from before %s\n", old, cutoff.Format("2006-01-02"))
+	}
+	if delivered > 0 {
+		fmt.Printf("    - %s\n", Error.Render(fmt.Sprintf("forget %d delivered files", delivered)))
+		fmt.Println("      " + Dim.Render("backfill and import-dir will send them again"))
+	}
+	if invalid > 0 {
+		fmt.Printf("    - remove %d unreadable history lines\n", invalid)
+	}
+	if len(tokens) > 0 {
+		fmt.Printf("    - delete %d upload resume tokens older than %d days\n", len(tokens), int(storage.SessionLifetime.Hours()/24))
+	}
+	if running {
+		fmt.Println("  " + Warning.Render("kpub is running; files delivered while pruning may be left out of the history."))
+	}
+
+	if !opts.Yes {
+		scanner := bufio.NewScanner(os.Stdin)
+		fmt.Print("\n  Continue? [y/N] ")
+		scanner.Scan()
+		answer := strings.TrimSpace(strings.ToLower(scanner.Text()))
+		if answer != "y" && answer != "yes" {
+			fmt.Println("\n" + Warning.Render("  Aborted. Nothing was removed."))
+			return nil
+		}
+	}
+
+	if len(kept) < len(lines) {
+		if err := history.Rewrite(historyPath, kept); err != nil {
+			return err
+		}
+		fmt.Println("\n  " + Success.Render(fmt.Sprintf("Removed %d history entries; %d kept.", len(lines)-len(kept), len(kept))))
+	}
+	for _, t := range tokens {
+		if err := os.Remove(t); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("deleting %s: %w", t, err)
+		}
+	}
+	if len(tokens) > 0 {
+		fmt.Println("  " + Success.Render(fmt.Sprintf("Deleted %d upload resume tokens.", len(tokens))))
+	}
+	return nil
+}
@@ -21,7 +21,7 @@ func RemoveChat(dataDir, handle string) error {
 
 	idx := -1
 	for i, chat := range cfg.Chats {
-		if chat.Handle == handle {
+		if config.HandleKey(chat.Handle) == config.HandleKey(handle) {
 			idx = i
 			break
 		}
@@ -31,8 +31,8 @@ func RemoveChat(dataDir, handle string) error {
 		return fmt.Errorf("chat %q not found", handle)
 	}
 
-	if len(cfg.Chats) == 1 {
-		return fmt.Errorf("cannot remove the only chat — at least one chat must be configured")
+	if len(cfg.Chats) == 1 && !cfg.AllowEmpty {
+		return fmt.Errorf("cannot remove the only chat — at least one chat must be configured, unless allow_empty is set")
 	}
 
 	fmt.Printf("\n  Remove chat %s? [y/N] ", Highlight.Render(handle))
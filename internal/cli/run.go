@@ -1,12 +1,15 @@
 package cli
 
 import (
+	"fmt"
 	"strings"
+	"time"
 
 	"github.com/charmbracelet/bubbles/spinner"
 	tea "github.com/charmbracelet/bubbletea"
 
 	"github.com/spacesedan/kpub/internal/dockerutil"
+	"github.com/spacesedan/kpub/internal/monitor"
 )
 
 type runPhase int
@@ -16,9 +19,13 @@ const (
 	runRemoving
 	runPulling
 	runStarting
+	runWaiting
 	runDone
 )
 
+// readyLogLine is logged by the monitor once it is connected to Telegram.
+const readyLogLine = "Connected and ready to monitor chats"
+
 type runStepDoneMsg struct{ err error }
 
 // runAlreadyRunningMsg signals the container is already running.
@@ -34,7 +41,10 @@ type dockerOutputMsg string
 type RunModel struct {
 	dataDir    string
 	detach     bool
+	force      bool // replace the container even if it's running
 	image      string
+	auth       string // encoded X-Registry-Auth, or "" for anonymous pulls
+	wait       time.Duration // how long to wait for readiness after a detached start
 	phase      runPhase
 	spinner    spinner.Model
 	outputCh   chan string // receives streaming docker output
@@ -45,8 +55,11 @@ type RunModel struct {
 	done       bool
 }
 
-// NewRunModel creates a new run command model.
-func NewRunModel(dataDir string, detach bool, image string) RunModel {
+// NewRunModel creates a new run command model. registryAuth is passed to
+// dockerutil.PullImage. If wait is positive and detach is true, the model
+// waits up to wait for the server to connect to Telegram. A running container
+// is left alone unless force is set.
+func NewRunModel(dataDir string, detach, force bool, image, registryAuth string, wait time.Duration) RunModel {
 	s := spinner.New()
 	s.Spinner = spinner.Dot
 	s.Style = Highlight
@@ -54,7 +67,10 @@ func NewRunModel(dataDir string, detach bool, image string) RunModel {
 	return RunModel{
 		dataDir:  dataDir,
 		detach:   detach,
+		force:    force,
 		image:    image,
+		auth:     registryAuth,
+		wait:     wait,
 		phase:    runChecking,
 		spinner:  s,
 		outputCh: make(chan string, 128),
@@ -67,8 +83,13 @@ func (m RunModel) Init() tea.Cmd {
 
 // checkState checks if the container is already running and if the image exists.
 func (m RunModel) checkState() tea.Cmd {
+	force := m.force
 	return func() tea.Msg {
-		if dockerutil.IsContainerRunning("kpub") {
+		state, err := dockerutil.ContainerStatus("kpub")
+		if err != nil {
+			return runStepDoneMsg{err: err}
+		}
+		if state == dockerutil.ContainerRunning && !force {
 			return runAlreadyRunningMsg{}
 		}
 		if dockerutil.ImageExists(m.image) {
@@ -99,8 +120,9 @@ func (m RunModel) removeContainer() tea.Cmd {
 func (m RunModel) pullImage() tea.Cmd {
 	ch := m.outputCh
 	image := m.image
+	auth := m.auth
 	return func() tea.Msg {
-		err := dockerutil.PullImage(image, ch)
+		_, err := dockerutil.PullImage(image, auth, ch)
 		return runStepDoneMsg{err: err}
 	}
 }
@@ -113,6 +135,47 @@ func (m RunModel) startContainer() tea.Cmd {
 	}
 }
 
+func (m RunModel) waitReady() tea.Cmd {
+	wait := m.wait
+	return func() tea.Msg {
+		return runStepDoneMsg{err: waitForReady("kpub", wait)}
+	}
+}
+
+// waitForReady polls the container's logs until the monitor reports it is
+// connected, the container exits, or timeout elapses.
+func waitForReady(name string, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		logs, err := dockerutil.ContainerLogs(name)
+		if err != nil {
+			return err
+		}
+		if strings.Contains(logs, readyLogLine) {
+			return nil
+		}
+		if strings.Contains(logs, monitor.LoginRequiredMessage) {
+			return fmt.Errorf("Telegram login required — run 'kpub run' without --detach once to sign in")
+		}
+		if !dockerutil.IsContainerRunning(name) {
+			return fmt.Errorf("container exited before becoming ready:\n%s", lastLines(logs, 10))
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("server not ready after %s, check 'docker logs %s'", timeout, name)
+		}
+		time.Sleep(time.Second)
+	}
+}
+
+// lastLines returns the last n non-empty lines of s.
+func lastLines(s string, n int) string {
+	lines := strings.Split(strings.TrimSpace(s), "\n")
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return strings.Join(lines, "\n")
+}
+
 func (m RunModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	switch msg := msg.(type) {
 	case tea.KeyMsg:
@@ -167,6 +230,14 @@ func (m RunModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			m.phase = runStarting
 			return m, m.startContainer()
 		case runStarting:
+			if m.wait > 0 {
+				m.phase = runWaiting
+				return m, m.waitReady()
+			}
+			m.phase = runDone
+			m.done = true
+			return m, tea.Quit
+		case runWaiting:
 			m.phase = runDone
 			m.done = true
 			return m, tea.Quit
@@ -183,13 +254,17 @@ func (m RunModel) View() string {
 	if m.done {
 		if m.alreadyRun {
 			return "\n" + Warning.Render("  Container 'kpub' is already running.") + "\n" +
-				"  " + Dim.Render("Use 'kpub stop' to stop it, or 'kpub reload' to restart.") + "\n\n"
+				"  " + Dim.Render("Use 'kpub stop' to stop it, 'kpub reload' to restart, or 'kpub run --force' to replace it.") + "\n\n"
 		}
 		if m.err != nil {
 			return "\n" + Error.Render("  Error: "+m.err.Error()) + "\n\n"
 		}
 		if m.detach {
-			return "\n" + Success.Render("  Container started in background.") + "\n" +
+			started := "  Container started in background."
+			if m.wait > 0 {
+				started = "  Container started and connected to Telegram."
+			}
+			return "\n" + Success.Render(started) + "\n" +
 				"  " + Dim.Render("Use 'docker logs -f kpub' to view logs.") + "\n\n"
 		}
 		return ""
@@ -209,6 +284,9 @@ func (m RunModel) View() string {
 		steps = append(steps, step{"Pulling " + m.image + "...", runPulling})
 	}
 	steps = append(steps, step{"Starting container...", runStarting})
+	if m.detach && m.wait > 0 {
+		steps = append(steps, step{"Waiting for Telegram connection...", runWaiting})
+	}
 
 	for _, s := range steps {
 		if m.phase > s.phase {
@@ -244,6 +322,20 @@ func RunForeground(image, dataDir string) error {
 	return dockerutil.RunContainer("kpub", image, dataDir, false)
 }
 
+// RunWithLogs starts the container in the background and follows its logs
+// in a LogsModel until the user quits, which stops the container.
+func RunWithLogs(image, dataDir string) error {
+	if err := dockerutil.RunContainer("kpub", image, dataDir, true); err != nil {
+		return err
+	}
+	p := tea.NewProgram(NewLogsModel("kpub"), tea.WithAltScreen(), tea.WithMouseCellMotion())
+	result, err := p.Run()
+	if err != nil {
+		return fmt.Errorf("log viewer: %w", err)
+	}
+	return result.(LogsModel).Err()
+}
+
 // Err returns any error that occurred.
 func (m RunModel) Err() error {
 	return m.err
@@ -9,6 +9,7 @@ import (
 	"github.com/charmbracelet/bubbles/textinput"
 	tea "github.com/charmbracelet/bubbletea"
 
+	"github.com/spacesedan/kpub/internal/config"
 	"github.com/spacesedan/kpub/internal/setup"
 )
 
@@ -39,8 +40,11 @@ type chatEntry struct {
 
 // SetupModel is the Bubbletea model for the setup wizard.
 type SetupModel struct {
-	dataDir string
-	step    wizardStep
+	dataDir     string
+	step        wizardStep
+	useDefaults bool // skip optional prompts and save without review
+	force       bool // overwrite existing files; set by --force or by confirming
+	existing    []string // files in dataDir that saving would overwrite
 
 	// Text inputs (reused across steps)
 	inputs    []textinput.Model
@@ -61,10 +65,14 @@ type SetupModel struct {
 	// Step-specific state
 	exchanging      bool // true while exchanging dropbox code
 	exchangeErr     string
-	browserOpened   bool // true after we've tried to open the browser
+	browserOpened   bool   // true after we've tried to open the browser
+	browserErr      string // why the browser couldn't be opened, if it couldn't
 	addingChat      bool // true when entering a new chat
 	confirmingChat  bool // asking "add another?"
 	confirmSave     bool // on review step, waiting for y/n
+	testingUpload   bool // true while the optional test upload runs
+	testUploadDone  bool
+	testUploadErr   string
 
 	// Final state
 	done    bool
@@ -79,26 +87,39 @@ type tokenExchangeMsg struct {
 	err    error
 }
 
+// testUploadMsg is sent when the optional Dropbox test upload completes.
+type testUploadMsg struct {
+	err error
+}
+
 // browserOpenedMsg is sent after attempting to open the browser.
-type browserOpenedMsg struct{}
+type browserOpenedMsg struct {
+	err error
+}
 
 func openBrowserCmd(url string) tea.Cmd {
 	return func() tea.Msg {
-		_ = setup.OpenBrowser(url)
-		return browserOpenedMsg{}
+		return browserOpenedMsg{err: setup.OpenBrowser(url)}
 	}
 }
 
-// NewSetupModel creates a new setup wizard model.
-func NewSetupModel(dataDir string) SetupModel {
+// NewSetupModel creates a new setup wizard model. With useDefaults the wizard
+// only asks for credentials and chats, and saves without a review step.
+// Existing files in dataDir are only overwritten when force is set.
+func NewSetupModel(dataDir string, useDefaults, force bool) SetupModel {
 	s := spinner.New()
 	s.Spinner = spinner.Dot
 	s.Style = Highlight
 
 	m := SetupModel{
-		dataDir: dataDir,
-		step:    stepTelegram,
-		spinner: s,
+		dataDir:     dataDir,
+		step:        stepTelegram,
+		useDefaults: useDefaults,
+		force:       force,
+		spinner:     s,
+	}
+	if !force {
+		m.existing = setup.ExistingFiles(dataDir)
 	}
 	m.initStepInputs()
 	return m
@@ -144,6 +165,7 @@ func (m *SetupModel) initStepInputs() {
 		m.exchanging = false
 		m.exchangeErr = ""
 		m.browserOpened = false
+		m.browserErr = ""
 
 	case stepChats:
 		m.chats = nil
@@ -153,6 +175,9 @@ func (m *SetupModel) initStepInputs() {
 
 	case stepReview:
 		m.confirmSave = true
+		m.testingUpload = false
+		m.testUploadDone = false
+		m.testUploadErr = ""
 		m.inputs = nil
 		m.inputIdx = 0
 	}
@@ -197,8 +222,19 @@ func (m SetupModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		m.step = stepChats
 		m.initStepInputs()
 		return m, textinput.Blink
+	case testUploadMsg:
+		m.testingUpload = false
+		m.testUploadDone = true
+		m.testUploadErr = ""
+		if msg.err != nil {
+			m.testUploadErr = msg.err.Error()
+		}
+		return m, nil
 	case browserOpenedMsg:
 		m.browserOpened = true
+		if msg.err != nil {
+			m.browserErr = msg.err.Error()
+		}
 		return m, nil
 	case spinner.TickMsg:
 		var cmd tea.Cmd
@@ -358,6 +394,11 @@ func (m SetupModel) updateChats(msg tea.Msg) (tea.Model, tea.Cmd) {
 
 		if key.Type == tea.KeyEnter {
 			val := strings.TrimSpace(m.inputs[0].Value())
+			if val == "" && m.useDefaults && len(m.chats) > 0 {
+				// An empty line ends the list; defaults mode saves straight away.
+				m.addingChat = false
+				return m.saveConfig()
+			}
 			if val == "" {
 				m.inputErr = "Value cannot be empty"
 				return m, nil
@@ -365,13 +406,17 @@ func (m SetupModel) updateChats(msg tea.Msg) (tea.Model, tea.Cmd) {
 			if strings.EqualFold(val, "back") {
 				return m.goBack()
 			}
-			if !strings.HasPrefix(val, "@") {
-				m.inputErr = "Handle must start with @"
+			if _, err := config.ParseChatRef(val); err != nil {
+				m.inputErr = "Handle must be an @handle, a chat ID like -100123456, or a t.me link"
 				return m, nil
 			}
 
 			m.chats = append(m.chats, chatEntry{handle: val})
 			m.inputErr = ""
+			if m.useDefaults {
+				m.initChatInput()
+				return m, textinput.Blink
+			}
 			m.confirmingChat = true
 			return m, nil
 		}
@@ -381,14 +426,25 @@ func (m SetupModel) updateChats(msg tea.Msg) (tea.Model, tea.Cmd) {
 }
 
 func (m SetupModel) updateReview(msg tea.Msg) (tea.Model, tea.Cmd) {
+	if m.testingUpload {
+		return m, nil
+	}
+
 	if key, ok := msg.(tea.KeyMsg); ok {
 		switch key.String() {
-		case "y", "Y", "enter":
+		case "t", "T":
+			return m.testUpload()
+		case "y", "Y":
+			m.force = true
+			return m.saveConfig()
+		case "enter":
+			if len(m.existing) > 0 && !m.force {
+				// Overwriting needs an explicit "y".
+				return m.abortSave()
+			}
 			return m.saveConfig()
 		case "n", "N":
-			m.done = true
-			m.result = Warning.Render("Aborted. No files were written.")
-			return m, tea.Quit
+			return m.abortSave()
 		case "b", "B":
 			return m.goBack()
 		}
@@ -396,9 +452,45 @@ func (m SetupModel) updateReview(msg tea.Msg) (tea.Model, tea.Cmd) {
 	return m, nil
 }
 
+// testUpload writes and deletes a marker file in the configured upload path
+// so permission or scope problems surface before the server runs.
+func (m SetupModel) testUpload() (tea.Model, tea.Cmd) {
+	cfg := setup.BuildConfig(m.appID, m.appHash, m.dropboxAppKey, m.dropboxAppSecret, m.chatsToSetupChats())
+	accessToken := m.tokens.AccessToken
+	uploadPath := cfg.Defaults.Storage.Dropbox.UploadPath
+
+	m.testingUpload = true
+	m.testUploadErr = ""
+	return m, func() tea.Msg {
+		return testUploadMsg{err: setup.TestDropboxUpload(accessToken, uploadPath)}
+	}
+}
+
+// abortSave ends the wizard without writing anything.
+func (m SetupModel) abortSave() (tea.Model, tea.Cmd) {
+	m.done = true
+	m.result = Warning.Render("Aborted. No files were written.")
+	if len(m.existing) > 0 {
+		m.result += "\n\n  " + Dim.Render("Your existing configuration was left untouched.")
+	}
+	return m, tea.Quit
+}
+
 func (m SetupModel) saveConfig() (tea.Model, tea.Cmd) {
 	cfg := setup.BuildConfig(m.appID, m.appHash, m.dropboxAppKey, m.dropboxAppSecret, m.chatsToSetupChats())
 
+	// Files may have appeared since the wizard started. Either way, stop at
+	// the review step and ask before overwriting them.
+	if !m.force {
+		if existing := setup.ExistingFiles(m.dataDir); len(existing) > 0 {
+			m.existing = existing
+			if m.step != stepReview {
+				m.step = stepReview
+				m.initStepInputs()
+			}
+			return m, nil
+		}
+	}
 	if err := setup.WriteConfig(m.dataDir, cfg); err != nil {
 		m.err = fmt.Errorf("writing config: %w", err)
 		m.done = true
@@ -463,6 +555,9 @@ func (m SetupModel) View() string {
 	b.WriteString("  " + Dim.Render("~ kpub setup wizard ~") + "\n\n")
 	b.WriteString("  " + Title.Render("Let's get your ebook pipeline set up!") + "\n")
 	b.WriteString("  Files will be saved to " + Highlight.Render(m.dataDir+"/") + "\n")
+	if len(m.existing) > 0 && !m.force {
+		b.WriteString("  " + Warning.Render("A configuration already exists there; you'll be asked before it's replaced.") + "\n")
+	}
 	b.WriteString("  " + Dim.Render("Type \"back\" or press Esc to go to the previous step.") + "\n\n")
 
 	// Progress bar
@@ -490,13 +585,19 @@ func (m SetupModel) View() string {
 		b.WriteString("  " + Title.Render("\U0001f511 Dropbox authorization") + "\n\n")
 		authURL := setup.DropboxAuthURL(m.dropboxAppKey)
 		authLink := Link(authURL, Highlight.Render(authURL))
-		if m.browserOpened {
+		switch {
+		case m.browserErr != "":
+			b.WriteString("  " + Warning.Render("Couldn't open your browser automatically ("+m.browserErr+").") + "\n")
+			b.WriteString("  " + Title.Render("Copy this URL into a browser to continue:") + "\n\n")
+			b.WriteString("    " + authLink + "\n\n")
+		case m.browserOpened:
 			b.WriteString("  Opening your browser now...\n")
 			b.WriteString("  " + Dim.Render("If it didn't open, click or copy this URL:") + "\n")
-		} else {
+			b.WriteString("  " + authLink + "\n\n")
+		default:
 			b.WriteString("  Open this URL in your browser:\n")
+			b.WriteString("  " + authLink + "\n\n")
 		}
-		b.WriteString("  " + authLink + "\n\n")
 		if m.exchanging {
 			b.WriteString("  " + m.spinner.View() + " Exchanging code for tokens...\n")
 		} else {
@@ -511,7 +612,12 @@ func (m SetupModel) View() string {
 		b.WriteString("  " + Title.Render("\U0001f4ac Chat configuration") + "\n\n")
 		b.WriteString("  Enter the handles of the chats you want to monitor for ebook files.\n")
 		b.WriteString("  This can be bots, groups, or channels (e.g. @ebook-bot, @bookgroup).\n")
-		b.WriteString("  You need at least one, but you can add as many as you like.\n\n")
+		b.WriteString("  Private groups can be given as a chat ID (-100123456) or a t.me link.\n")
+		b.WriteString("  You need at least one, but you can add as many as you like.\n")
+		if m.useDefaults && len(m.chats) > 0 {
+			b.WriteString("  " + Dim.Render("Press Enter on an empty line to save and finish.") + "\n")
+		}
+		b.WriteString("\n")
 		// Show already-added chats
 		for i, chat := range m.chats {
 			b.WriteString("  " + Success.Render(fmt.Sprintf("  Chat #%d: %s", i+1, chat.handle)) + "\n")
@@ -545,8 +651,29 @@ func (m SetupModel) View() string {
 			b.WriteString(fmt.Sprintf("    %s\n", Highlight.Render(chat.handle)))
 		}
 		b.WriteString("\n")
-		if m.confirmSave {
-			b.WriteString("  " + Prompt.Render("Save configuration? [Y/n] "))
+		switch {
+		case m.testingUpload:
+			b.WriteString("  " + m.spinner.View() + " Uploading a test file to Dropbox...\n\n")
+		case m.testUploadDone && m.testUploadErr != "":
+			b.WriteString("  " + Error.Render("Test upload failed: "+m.testUploadErr) + "\n")
+			b.WriteString("  " + Dim.Render("Check the app's permissions and access type, or go back to fix your credentials.") + "\n\n")
+		case m.testUploadDone:
+			b.WriteString("  " + Success.Render("\u2713 Test upload succeeded.") + "\n\n")
+		}
+		if m.confirmSave && !m.testingUpload {
+			if !m.testUploadDone {
+				b.WriteString("  " + Dim.Render("Press t to upload a test file to Dropbox first.") + "\n")
+			}
+			if len(m.existing) > 0 && !m.force {
+				b.WriteString("  " + Warning.Render("These files already exist and will be overwritten:") + "\n")
+				for _, p := range m.existing {
+					b.WriteString("    " + Highlight.Render(p) + "\n")
+				}
+				b.WriteString("  " + Dim.Render("Answering n keeps them as they are.") + "\n")
+				b.WriteString("  " + Prompt.Render("Overwrite existing configuration? [y/N] "))
+			} else {
+				b.WriteString("  " + Prompt.Render("Save configuration? [Y/n] "))
+			}
 		}
 	}
 
@@ -0,0 +1,100 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/spacesedan/kpub/internal/config"
+	"github.com/spacesedan/kpub/internal/monitor"
+)
+
+// TestChat connects as the user and reports whether a message in handle's
+// chat would be accepted, without downloading or uploading anything.
+// messageRef is a message link or ID; empty means the latest document. The
+// handle doesn't have to be configured yet, in which case defaults apply.
+func TestChat(dataDir, handle, messageRef, version string) error {
+	configPath := filepath.Join(dataDir, "config.yaml")
+	cfg, err := config.Load(configPath)
+	if err != nil {
+		return fmt.Errorf("loading config: %w", err)
+	}
+
+	messageID, err := parseMessageRef(messageRef)
+	if err != nil {
+		return err
+	}
+
+	chatCfg := config.ChatConfig{Handle: handle}
+	configured := false
+	for _, c := range cfg.Chats {
+		if config.HandleKey(c.Handle) == config.HandleKey(handle) {
+			chatCfg = c
+			configured = true
+			break
+		}
+	}
+	resolved := config.ResolvedChatConfig(cfg, chatCfg)
+
+	sessionPath := hostPath(dataDir, cfg.Telegram.SessionFile)
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+
+	m := monitor.New(cfg.Telegram.AppID, cfg.Telegram.AppHash, sessionPath, "", "", monitor.Options{
+		SessionPassphrase: os.Getenv(config.SessionPassphraseVar),
+		TestDC:            cfg.Telegram.TestDC,
+		DC:                cfg.Telegram.DC,
+		Device:            cfg.Telegram.Device,
+		Version:           version,
+	})
+	result, err := m.DryRun(ctx, resolved, messageID)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println()
+	if !configured {
+		fmt.Println("  " + Warning.Render(handle+" is not configured; testing with the default filters."))
+		fmt.Println()
+	}
+	fmt.Printf("  %s %d\n", Dim.Render("Message:  "), result.MessageID)
+	if result.FileName != "" {
+		fmt.Printf("  %s %s\n", Dim.Render("File:     "), result.FileName)
+	}
+	if result.MimeType != "" {
+		fmt.Printf("  %s %s\n", Dim.Render("MIME type:"), result.MimeType)
+	}
+	fmt.Println()
+	switch {
+	case !result.Accepted:
+		fmt.Println("  " + Error.Render("Would be skipped: "+result.Reason))
+	case result.Convert:
+		fmt.Println("  " + Success.Render("Would be accepted, converted to KEPUB, and uploaded."))
+	default:
+		fmt.Println("  " + Success.Render("Would be accepted and uploaded without conversion."))
+	}
+	fmt.Println()
+	return nil
+}
+
+// parseMessageRef accepts a message ID or a t.me link ending in one.
+func parseMessageRef(ref string) (int, error) {
+	if ref == "" {
+		return 0, nil
+	}
+	s, _, _ := strings.Cut(ref, "?")
+	s = strings.TrimSuffix(s, "/")
+	if i := strings.LastIndex(s, "/"); i >= 0 {
+		s = s[i+1:]
+	}
+	id, err := strconv.Atoi(s)
+	if err != nil || id <= 0 {
+		return 0, fmt.Errorf("%q is not a message ID or message link", ref)
+	}
+	return id, nil
+}
@@ -0,0 +1,83 @@
+package cli
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spacesedan/kpub/internal/dockerutil"
+)
+
+// Uninstall stops and removes the kpub container and, only if asked, its
+// image and data directory. Everything it is about to do is listed and
+// confirmed first; deleting the data directory, which holds the Telegram
+// session and Dropbox tokens, also needs the directory's name typed back.
+func Uninstall(container, image, dataDir string, purgeImage, purgeData bool) error {
+	absDataDir, err := filepath.Abs(dataDir)
+	if err != nil {
+		return fmt.Errorf("resolving data-dir: %w", err)
+	}
+	if purgeData {
+		if home, _ := os.UserHomeDir(); absDataDir == "/" || absDataDir == home {
+			return fmt.Errorf("refusing to delete %s; point --data-dir at kpub's own directory", absDataDir)
+		}
+	}
+
+	fmt.Println("\n  " + Title.Render("This will:"))
+	fmt.Println("    - stop and remove the " + Highlight.Render(container) + " container")
+	if purgeImage {
+		fmt.Println("    - remove the " + Highlight.Render(image) + " image")
+	}
+	if purgeData {
+		fmt.Println("    - " + Error.Render("permanently delete "+absDataDir) + ",")
+		fmt.Println("      including your config, Telegram session, Dropbox tokens and history")
+	} else {
+		fmt.Println("  " + Dim.Render("Your data in "+absDataDir+" is kept; use --purge-data to delete it."))
+	}
+
+	scanner := bufio.NewScanner(os.Stdin)
+	fmt.Print("\n  Continue? [y/N] ")
+	scanner.Scan()
+	answer := strings.TrimSpace(strings.ToLower(scanner.Text()))
+	if answer != "y" && answer != "yes" {
+		fmt.Println("\n" + Warning.Render("  Aborted. Nothing was removed."))
+		return nil
+	}
+
+	if purgeData {
+		name := filepath.Base(absDataDir)
+		fmt.Printf("\n  %s Type %s to confirm: ", Error.Render("This can't be undone."), Highlight.Render(name))
+		scanner.Scan()
+		if strings.TrimSpace(scanner.Text()) != name {
+			fmt.Println("\n" + Warning.Render("  Aborted. Nothing was removed."))
+			return nil
+		}
+	}
+
+	if err := dockerutil.CheckDocker(); err != nil {
+		return err
+	}
+	if err := dockerutil.StopContainer(container); err != nil {
+		return err
+	}
+	fmt.Println("\n  " + Success.Render("Container removed."))
+
+	if purgeImage {
+		if err := dockerutil.RemoveImage(image); err != nil {
+			return err
+		}
+		fmt.Println("  " + Success.Render("Image removed."))
+	}
+
+	if purgeData {
+		if err := os.RemoveAll(absDataDir); err != nil {
+			return fmt.Errorf("deleting %s: %w", absDataDir, err)
+		}
+		fmt.Println("  " + Success.Render("Deleted "+absDataDir+"."))
+	}
+
+	fmt.Println("\n  kpub is uninstalled. Remove the kpub binary itself to finish.")
+	return nil
+}
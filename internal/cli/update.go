@@ -17,7 +17,10 @@ const (
 	updateDone
 )
 
-type updateStepDoneMsg struct{ err error }
+type updateStepDoneMsg struct {
+	err     error
+	updated bool // pulling: whether a newer image was downloaded
+}
 
 // updateOutputMsg carries a single line of docker output (update command).
 type updateOutputMsg string
@@ -27,16 +30,19 @@ type UpdateModel struct {
 	dataDir  string
 	restart  bool
 	image    string
+	auth     string // encoded X-Registry-Auth, or "" for anonymous pulls
 	phase    updatePhase
 	spinner  spinner.Model
 	outputCh chan string
 	status   string
+	updated  bool
 	err      error
 	done     bool
 }
 
-// NewUpdateModel creates a new update command model.
-func NewUpdateModel(dataDir string, restart bool, image string) UpdateModel {
+// NewUpdateModel creates a new update command model. registryAuth is passed
+// to dockerutil.PullImage.
+func NewUpdateModel(dataDir string, restart bool, image, registryAuth string) UpdateModel {
 	s := spinner.New()
 	s.Spinner = spinner.Dot
 	s.Style = Highlight
@@ -45,6 +51,7 @@ func NewUpdateModel(dataDir string, restart bool, image string) UpdateModel {
 		dataDir:  dataDir,
 		restart:  restart,
 		image:    image,
+		auth:     registryAuth,
 		phase:    updatePulling,
 		spinner:  s,
 		outputCh: make(chan string, 128),
@@ -69,9 +76,10 @@ func (m UpdateModel) listenOutput() tea.Cmd {
 func (m UpdateModel) pullImage() tea.Cmd {
 	ch := m.outputCh
 	image := m.image
+	auth := m.auth
 	return func() tea.Msg {
-		err := dockerutil.PullImage(image, ch)
-		return updateStepDoneMsg{err: err}
+		updated, err := dockerutil.PullImage(image, auth, ch)
+		return updateStepDoneMsg{err: err, updated: updated}
 	}
 }
 
@@ -107,6 +115,7 @@ func (m UpdateModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		}
 		switch m.phase {
 		case updatePulling:
+			m.updated = msg.updated
 			if m.restart {
 				m.phase = updateRestarting
 				return m, m.restartContainer()
@@ -133,9 +142,13 @@ func (m UpdateModel) View() string {
 			return "\n" + Error.Render("  Error: "+m.err.Error()) + "\n\n"
 		}
 		msg := Success.Render("  Update complete!")
-		if m.restart {
+		if !m.updated {
+			msg = Success.Render("  Already up to date: " + m.image)
+		}
+		switch {
+		case m.restart:
 			msg += "\n  " + Dim.Render("Container restarted. Use 'docker logs -f kpub' to view logs.")
-		} else {
+		case m.updated:
 			msg += "\n  " + Dim.Render("Run 'kpub run' to start the updated container.")
 		}
 		return "\n" + msg + "\n\n"
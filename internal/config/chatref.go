@@ -0,0 +1,113 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// ChatRefKind identifies how a chat is referenced in ChatConfig.Handle.
+type ChatRefKind int
+
+const (
+	RefUsername  ChatRefKind = iota // @handle
+	RefChannelID                    // -100<id> or t.me/c/<id>
+	RefChatID                       // -<id> (basic group)
+	RefInvite                       // t.me/+<hash> or t.me/joinchat/<hash>
+)
+
+// ChatRef is a parsed chat handle.
+type ChatRef struct {
+	Kind       ChatRefKind
+	Username   string // RefUsername, without the @
+	ID         int64  // RefChannelID, RefChatID
+	InviteHash string // RefInvite
+}
+
+// ParseChatRef parses a chat handle, which may be an @username, a Bot API
+// style numeric ID (-100<id> for channels and supergroups, -<id> for basic
+// groups), a private t.me/c/<id> link, or an invite link.
+func ParseChatRef(handle string) (ChatRef, error) {
+	h := strings.TrimSpace(handle)
+
+	if strings.HasPrefix(h, "@") {
+		if len(h) == 1 {
+			return ChatRef{}, fmt.Errorf("handle %q is missing a username", handle)
+		}
+		return ChatRef{Kind: RefUsername, Username: h[1:]}, nil
+	}
+
+	if strings.HasPrefix(h, "-") {
+		if rest, ok := strings.CutPrefix(h, "-100"); ok && len(rest) > 0 {
+			if id, err := strconv.ParseInt(rest, 10, 64); err == nil && id > 0 {
+				return ChatRef{Kind: RefChannelID, ID: id}, nil
+			}
+		}
+		if id, err := strconv.ParseInt(h[1:], 10, 64); err == nil && id > 0 {
+			return ChatRef{Kind: RefChatID, ID: id}, nil
+		}
+		return ChatRef{}, fmt.Errorf("invalid chat ID %q", handle)
+	}
+
+	link := strings.TrimPrefix(strings.TrimPrefix(h, "https://"), "http://")
+	if path, ok := strings.CutPrefix(link, "t.me/"); ok {
+		switch {
+		case strings.HasPrefix(path, "c/"):
+			idStr, _, _ := strings.Cut(strings.TrimPrefix(path, "c/"), "/")
+			id, err := strconv.ParseInt(idStr, 10, 64)
+			if err != nil || id <= 0 {
+				return ChatRef{}, fmt.Errorf("invalid private chat link %q", handle)
+			}
+			return ChatRef{Kind: RefChannelID, ID: id}, nil
+		case strings.HasPrefix(path, "+"), strings.HasPrefix(path, "joinchat/"):
+			hash := strings.TrimPrefix(strings.TrimPrefix(path, "+"), "joinchat/")
+			hash, _, _ = strings.Cut(hash, "/")
+			if hash == "" {
+				return ChatRef{}, fmt.Errorf("invalid invite link %q", handle)
+			}
+			return ChatRef{Kind: RefInvite, InviteHash: hash}, nil
+		}
+	}
+
+	return ChatRef{}, fmt.Errorf("handle %q must be an @handle, a numeric chat ID like -100123456, or a t.me/c/ or invite link", handle)
+}
+
+// ChatFolder returns a folder name for handle that is safe to use as a single
+// path component: the username without the @, the numeric ID, or the invite
+// hash, with anything but letters, digits, '-', '_' and '.' replaced by '_'.
+func ChatFolder(handle string) string {
+	name := strings.TrimSpace(handle)
+	if ref, err := ParseChatRef(handle); err == nil {
+		switch ref.Kind {
+		case RefUsername:
+			name = ref.Username
+		case RefChannelID, RefChatID:
+			name = strconv.FormatInt(ref.ID, 10)
+		case RefInvite:
+			name = ref.InviteHash
+		}
+	}
+	name = strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || r == '-' || r == '_' || r == '.' {
+			return r
+		}
+		return '_'
+	}, name)
+	if strings.Trim(name, ".") == "" {
+		return "chat"
+	}
+	return name
+}
+
+// HandleKey returns the form of handle used to tell chats apart. Usernames
+// are case-insensitive on Telegram, so "@Book_Bot" and "@book_bot" share a
+// key; IDs and links (invite hashes are case-sensitive) are only trimmed.
+// The handle as written is still what gets shown.
+func HandleKey(handle string) string {
+	h := strings.TrimSpace(handle)
+	if strings.HasPrefix(h, "@") {
+		return strings.ToLower(h)
+	}
+	return h
+}
@@ -1,34 +1,124 @@
 package config
 
 import (
+	"cmp"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"os"
+	"regexp"
 	"strings"
+	"text/template"
+	"time"
 
 	"gopkg.in/yaml.v3"
+
+	"github.com/spacesedan/kpub/internal/converter"
+	"github.com/spacesedan/kpub/internal/throttle"
 )
 
 // Config is the top-level configuration loaded from YAML.
 type Config struct {
-	Telegram TelegramConfig `yaml:"telegram"`
-	Defaults DefaultsConfig `yaml:"defaults"`
-	Paths    PathsConfig    `yaml:"paths"`
-	Chats    []ChatConfig   `yaml:"chats"`
+	Telegram      TelegramConfig   `yaml:"telegram"`
+	Defaults      DefaultsConfig   `yaml:"defaults"`
+	Paths         PathsConfig      `yaml:"paths"`
+	Processing    ProcessingConfig `yaml:"processing,omitempty"`
+	Chats         []ChatConfig     `yaml:"chats"`
+	AdminCommands bool             `yaml:"admin_commands,omitempty"`
+	Messages      MessagesConfig   `yaml:"messages,omitempty"`
+
+	// Notifications sets where chats send their notifications unless they
+	// override it; see NotificationsConfig.
+	Notifications NotificationsConfig `yaml:"notifications,omitempty"`
+
+	// StartupNotification sends a message listing the monitored chats each
+	// time the server starts.
+	StartupNotification bool `yaml:"startup_notification,omitempty"`
+
+	// AllowEmpty lets the config have no chats, so the server can start
+	// empty and pick chats up as they are added.
+	AllowEmpty bool `yaml:"allow_empty,omitempty"`
 }
 
 type TelegramConfig struct {
-	AppID   int    `yaml:"app_id"`
-	AppHash string `yaml:"app_hash"`
+	AppID       int    `yaml:"app_id,omitempty"`
+	AppHash     string `yaml:"app_hash,omitempty"`
+	SessionFile string `yaml:"session_file"`
+
+	// CredentialsFile is a YAML or JSON file with app_id and app_hash, used
+	// for whichever of them the config leaves out. $KPUB_TELEGRAM_APP_ID and
+	// $KPUB_TELEGRAM_APP_HASH are the last fallback.
+	CredentialsFile string `yaml:"credentials_file,omitempty"`
+
+	// externalAppID and externalAppHash record credentials that came from
+	// CredentialsFile or the environment; see MarshalYAML.
+	externalAppID   bool
+	externalAppHash bool
+
+	// TestDC connects to Telegram's test datacenters instead of production.
+	// For development only; it needs a test account and its own session.
+	TestDC bool `yaml:"test_dc,omitempty"`
+	// DC is the datacenter ID (1-5) to connect to first. Zero uses the
+	// library default.
+	DC int `yaml:"dc,omitempty"`
+
+	// Device is how kpub describes itself to Telegram, as shown in the
+	// account's list of active sessions.
+	Device DeviceConfig `yaml:"device,omitempty"`
+
+	// UpdateWatchdog resyncs with Telegram when no update has arrived for
+	// this long, in case the server silently stopped sending them. Defaults
+	// to one hour; a negative value disables it.
+	UpdateWatchdog time.Duration `yaml:"update_watchdog,omitempty"`
+
+	// KeepAlive makes a cheap API call this often so NAT gateways and
+	// firewalls don't drop an idle connection. Defaults to five minutes; a
+	// negative value disables it.
+	KeepAlive time.Duration `yaml:"keep_alive,omitempty"`
+
+	// NotifyBotToken, from @BotFather, sends notifications from that bot
+	// instead of to Saved Messages. They go to NotifyChatID, or to your own
+	// account if it's zero; either way the chat must have started the bot.
+	NotifyBotToken string `yaml:"notify_bot_token,omitempty"`
+	NotifyChatID   int64  `yaml:"notify_chat_id,omitempty"`
 }
 
 type DefaultsConfig struct {
-	AcceptedFormats []string      `yaml:"accepted_formats"`
-	Storage         StorageConfig `yaml:"storage"`
+	AcceptedFormats   []string      `yaml:"accepted_formats"`
+	AcceptedMimeTypes []string      `yaml:"accepted_mime_types,omitempty"`
+	FilterMode        string        `yaml:"filter_mode,omitempty"`
+	ErrorNotifyTo     string        `yaml:"error_notify_to,omitempty"`
+	PreferFormats     []string      `yaml:"prefer_formats,omitempty"`
+	Storage           StorageConfig `yaml:"storage"`
+
+	// Convert, OutputFormats, ConvertOptions, InputEncoding and Language set
+	// how files are converted for every chat that doesn't override them;
+	// see ChatConfig.
+	Convert        *bool    `yaml:"convert,omitempty"`
+	OutputFormats  []string `yaml:"output_formats,omitempty"`
+	ConvertOptions []string `yaml:"convert_options,omitempty"`
+	InputEncoding  string   `yaml:"input_encoding,omitempty"`
+	Language       string   `yaml:"language,omitempty"`
+
+	// ConvertedExtension names KEPUB output: ".kepub.epub" (the default),
+	// ".kepub" or ".epub". See ChatConfig.
+	ConvertedExtension string `yaml:"converted_extension,omitempty"`
+
+	// MinFileSize skips documents smaller than this, e.g. "10KB". See
+	// ChatConfig.
+	MinFileSize string `yaml:"min_file_size,omitempty"`
 }
 
 type StorageConfig struct {
 	Type    string        `yaml:"type"`
 	Dropbox DropboxConfig `yaml:"dropbox"`
+	Email   EmailConfig   `yaml:"email,omitempty"`
+	B2      B2Config      `yaml:"b2,omitempty"`
+
+	// MaxConcurrentUploads caps how many uploads run at once to this
+	// backend. Chats sharing a backend share the limit. Zero means no limit.
+	MaxConcurrentUploads int `yaml:"max_concurrent_uploads,omitempty"`
 }
 
 type DropboxConfig struct {
@@ -36,38 +126,334 @@ type DropboxConfig struct {
 	AppSecret  string `yaml:"app_secret"`
 	TokenFile  string `yaml:"token_file"`
 	UploadPath string `yaml:"upload_path"`
+
+	// DateFolders uploads into a subfolder of UploadPath named after the
+	// date the file was received, laid out by DateFormat (a Go time layout,
+	// default "2006/01").
+	DateFolders bool   `yaml:"date_folders,omitempty"`
+	DateFormat  string `yaml:"date_format,omitempty"`
+
+	// FolderPerChat uploads into a subfolder of UploadPath named after the
+	// chat (see ChatFolder), above any date folder.
+	FolderPerChat bool `yaml:"folder_per_chat,omitempty"`
+
+	// IfExists decides what happens when a file with the same name is
+	// already at the destination: "upload" (the default) uploads anyway and
+	// Dropbox renames the copy, "skip" keeps the existing file, and
+	// "skip_identical" skips only if the contents match.
+	IfExists string `yaml:"if_exists,omitempty"`
+
+	// NotifyRefresh sends a notification every time the access token is
+	// refreshed. Repeated refresh failures are always reported.
+	NotifyRefresh bool `yaml:"notify_refresh,omitempty"`
+
+	// PathRoot is sent as the Dropbox-API-Path-Root header, so Dropbox
+	// Business users can upload into a team space rather than their own
+	// folder. It is the header's JSON value, e.g.
+	// {".tag": "root", "root": "1234567"}.
+	PathRoot string `yaml:"path_root,omitempty"`
+
+	// ChunkSize is how much of a large file each upload session request
+	// sends, like "8MB". Files no bigger than one chunk are uploaded in a
+	// single request. Empty means 8 MiB.
+	ChunkSize string `yaml:"chunk_size,omitempty"`
+}
+
+// EmailConfig configures delivery by email, e.g. to a Kindle address.
+type EmailConfig struct {
+	SMTPHost string `yaml:"smtp_host"`
+	SMTPPort int    `yaml:"smtp_port,omitempty"`
+	Username string `yaml:"username,omitempty"`
+	Password string `yaml:"password,omitempty"`
+	From     string `yaml:"from"`
+	To       string `yaml:"to"`
+}
+
+// B2Config configures uploads to a Backblaze B2 bucket. KeyID and
+// ApplicationKey are an application key from the B2 console; a key
+// restricted to Bucket is enough. Prefix is the folder inside the bucket.
+type B2Config struct {
+	KeyID          string `yaml:"key_id"`
+	ApplicationKey string `yaml:"application_key"`
+	Bucket         string `yaml:"bucket"`
+	Prefix         string `yaml:"prefix,omitempty"`
 }
 
 type PathsConfig struct {
 	DownloadDir  string `yaml:"download_dir"`
 	ConvertedDir string `yaml:"converted_dir"`
+	HistoryFile  string `yaml:"history_file,omitempty"`
+
+	// UploadStateDir holds resume tokens for interrupted uploads; see
+	// storage.ResumeStore.
+	UploadStateDir string `yaml:"upload_state_dir,omitempty"`
+}
+
+// ProcessingConfig tunes the download/convert/upload pipeline.
+type ProcessingConfig struct {
+	// Debounce waits this long after a document arrives before processing
+	// it, collapsing repeated updates for the same document into one run.
+	Debounce time.Duration `yaml:"debounce,omitempty"`
+
+	// FileTimeout caps the total download + convert + upload time for a
+	// single file. Zero means no limit.
+	FileTimeout time.Duration `yaml:"file_timeout,omitempty"`
+
+	// BandwidthLimit caps combined download and upload throughput, e.g.
+	// "2MB/s". Empty or "0" means unlimited.
+	BandwidthLimit string `yaml:"bandwidth_limit,omitempty"`
+
+	// PostProcess is a command (argv, not a shell string) run on each
+	// converted file before upload. {file} and {name} are substituted.
+	PostProcess []string `yaml:"post_process,omitempty"`
+
+	// Workers caps concurrent files; MaxQueue caps files waiting for a
+	// worker, with QueuePolicy ("block", "reject", "drop_oldest") applied
+	// when it's full. Zero means unlimited for both.
+	Workers     int    `yaml:"workers,omitempty"`
+	MaxQueue    int    `yaml:"max_queue,omitempty"`
+	QueuePolicy string `yaml:"queue_policy,omitempty"`
+
+	// MaxDownloads and MaxConversions cap how many files are downloading
+	// and converting at once, independently of Workers. Zero means no limit.
+	MaxDownloads   int `yaml:"max_downloads,omitempty"`
+	MaxConversions int `yaml:"max_conversions,omitempty"`
+
+	// ConversionLimits caps concurrent conversions per input extension,
+	// e.g. {".pdf": 1}. Listed types have their own slots; the rest share
+	// MaxConversions.
+	ConversionLimits map[string]int `yaml:"conversion_limits,omitempty"`
+
+	// PreferWindow is how long to wait for other formats of a title when a
+	// chat has prefer_formats. Defaults to 30s.
+	PreferWindow time.Duration `yaml:"prefer_window,omitempty"`
+
+	// KeepConverted keeps each delivered file in paths.converted_dir
+	// instead of deleting it after upload.
+	KeepConverted bool `yaml:"keep_converted,omitempty"`
+
+	// KeepOnFailure moves the download and any converted files of a file
+	// that fails into paths.converted_dir/failed/ for inspection, instead
+	// of deleting them.
+	KeepOnFailure bool `yaml:"keep_on_failure,omitempty"`
+
+	// UniqueNames stops a file from overwriting a different one delivered
+	// under the same name to the same destination, e.g. the same title
+	// from two chats, by adding part of its hash to its name.
+	UniqueNames bool `yaml:"unique_names,omitempty"`
+
+	// Converters lists conversion engines in the order to try them, e.g.
+	// ["kepubify", "calibre"]. An engine that can't handle a file is
+	// skipped, and one that fails falls back to the next. Defaults to
+	// Calibre alone.
+	Converters []string `yaml:"converters,omitempty"`
+
+	// MaxMessageAge ignores new-message updates older than this, which
+	// Telegram can replay after a long disconnect. Backfill isn't affected.
+	// Defaults to 24h; a negative value disables the check.
+	MaxMessageAge time.Duration `yaml:"max_message_age,omitempty"`
+
+	// UpdateBuffer is how many new messages can wait to be screened, so
+	// Telegram's update loop never waits on kpub. Defaults to 256; a
+	// negative value handles each message inside the update loop.
+	UpdateBuffer int `yaml:"update_buffer,omitempty"`
+}
+
+// DeviceConfig overrides the device info kpub sends when it connects to
+// Telegram. Empty fields keep the defaults: Model is "kpub <version>",
+// AppVersion kpub's version, and SystemVersion the OS it runs on.
+type DeviceConfig struct {
+	Model         string `yaml:"model,omitempty"`
+	SystemVersion string `yaml:"system_version,omitempty"`
+	AppVersion    string `yaml:"app_version,omitempty"`
+}
+
+// NotificationsConfig is the notification target and level every chat
+// inherits. A chat's own error_notify_to, then defaults.error_notify_to,
+// take precedence over Target, and its notify_level over Level.
+type NotificationsConfig struct {
+	// Target is a chat handle or ID to send notifications to instead of
+	// Saved Messages. Empty keeps them in Saved Messages.
+	Target string `yaml:"target,omitempty"`
+
+	// Level picks which notifications go to the target: NotifyErrors (the
+	// default) sends only failures, NotifyAll deliveries too.
+	Level string `yaml:"level,omitempty"`
+}
+
+// Notification levels for NotificationsConfig.Level.
+const (
+	NotifyErrors = "error" // only failures go to the notify target
+	NotifyAll    = "all"   // deliveries go to the notify target too
+)
+
+// MessagesConfig overrides the per-file notification texts. Each is a Go
+// text/template with {{.filename}}, {{.chat}} and {{.destination}}; Success
+// also has {{.converted}}, and Failure has {{.stage}} and {{.error}}. Empty
+// keeps the built-in message.
+type MessagesConfig struct {
+	Processing string `yaml:"processing,omitempty"`
+	Success    string `yaml:"success,omitempty"`
+	Failure    string `yaml:"failure,omitempty"`
+
+	// EditInPlace keeps one message per file: the processing message is
+	// edited through each stage and retry, then replaced by the outcome.
+	EditInPlace bool `yaml:"edit_in_place,omitempty"`
 }
 
 type ChatConfig struct {
-	Handle          string         `yaml:"handle"`
-	AcceptedFormats []string       `yaml:"accepted_formats,omitempty"`
-	Storage         *StorageConfig `yaml:"storage,omitempty"`
+	Handle            string         `yaml:"handle"`
+	AcceptedFormats   []string       `yaml:"accepted_formats,omitempty"`
+	AcceptedMimeTypes []string       `yaml:"accepted_mime_types,omitempty"`
+	FilterMode        string         `yaml:"filter_mode,omitempty"`
+	ErrorNotifyTo     string         `yaml:"error_notify_to,omitempty"`
+	Storage           *StorageConfig `yaml:"storage,omitempty"`
+
+	// NotifyLevel overrides notifications.level for this chat.
+	NotifyLevel string `yaml:"notify_level,omitempty"`
+
+	// Enabled set to false keeps the chat in the config but doesn't
+	// monitor it. Defaults to true.
+	Enabled *bool `yaml:"enabled,omitempty"`
+
+	// Convert set to false uploads files as received, skipping KEPUB
+	// conversion. Defaults to true.
+	Convert *bool `yaml:"convert,omitempty"`
+
+	// PreferFormats orders formats from most to least wanted. When the same
+	// title arrives in several formats within processing.prefer_window,
+	// only the first-listed one is processed.
+	PreferFormats []string `yaml:"prefer_formats,omitempty"`
+
+	// NoConvertFormats lists extensions that are uploaded as received even
+	// when Convert is on, e.g. [".pdf", ".cbz"].
+	NoConvertFormats []string `yaml:"no_convert_formats,omitempty"`
+
+	// OutputFormats lists the extensions each file is converted to, and
+	// uploaded as; defaults to [".kepub.epub"]. ConvertOptions are extra
+	// ebook-convert arguments, e.g. ["--output-profile", "kobo"].
+	OutputFormats  []string `yaml:"output_formats,omitempty"`
+	ConvertOptions []string `yaml:"convert_options,omitempty"`
+
+	// InputEncoding is the character encoding of text-based input (TXT,
+	// HTML, MOBI without a declared encoding), e.g. "cp1251", for when
+	// Calibre guesses wrong. Language sets the book's language as a code
+	// like "ru" or "pt-BR", which picks the reader's hyphenation and
+	// dictionary. Both are passed to ebook-convert.
+	InputEncoding string `yaml:"input_encoding,omitempty"`
+	Language      string `yaml:"language,omitempty"`
+
+	// ConvertedExtension is the extension KEPUB output is uploaded with.
+	// Kobo firmware only applies KEPUB rendering to ".kepub.epub" files, but
+	// some sync tools and other readers want ".kepub" or plain ".epub".
+	ConvertedExtension string `yaml:"converted_extension,omitempty"`
+
+	// MinFileSize skips documents smaller than this, like "10KB" or
+	// "1MiB", which weeds out placeholder files that aren't real books.
+	// Empty or "0" means no minimum.
+	MinFileSize string `yaml:"min_file_size,omitempty"`
+
+	// Backfill processes up to this many recent files when the chat is
+	// added, and BackfillSince limits that to messages newer than a
+	// duration or date (see ParseSince). BackfillScanLimit caps how many
+	// messages are looked through to find them, so a channel that is
+	// mostly text doesn't stall startup. Files already delivered are
+	// skipped.
+	Backfill          int    `yaml:"backfill,omitempty"`
+	BackfillSince     string `yaml:"backfill_since,omitempty"`
+	BackfillScanLimit int    `yaml:"backfill_scan_limit,omitempty"`
 }
 
+// Filter modes for combining the extension and MIME type filters.
+const (
+	FilterAny = "any" // accept if either filter matches
+	FilterAll = "all" // accept only if both filters match
+)
+
+// Policies for DropboxConfig.IfExists.
+const (
+	IfExistsUpload        = "upload"
+	IfExistsSkip          = "skip"
+	IfExistsSkipIdentical = "skip_identical"
+)
+
 // ResolvedChat holds the fully-merged configuration for a single monitored chat.
 type ResolvedChat struct {
-	Handle          string
-	AcceptedFormats map[string]bool
-	Storage         StorageConfig
+	Handle             string
+	Enabled            bool
+	AcceptedFormats    map[string]bool
+	AcceptAll          bool            // accepted_formats is a "*" or "any" wildcard
+	AcceptedMimeTypes  map[string]bool // empty means the MIME type isn't checked
+	RequireAll         bool            // filter_mode is FilterAll
+	ErrorNotifyTo      string          // failure notification target; "" means Saved Messages
+	NotifyLevel        string          // NotifyErrors or NotifyAll
+	Convert            bool
+	NoConvertFormats   map[string]bool
+	OutputFormats      []string // lowercased; at least one
+	ConvertOptions     []string
+	InputEncoding      string   // "" lets Calibre detect it
+	ConvertedExtension string   // name for KEPUB output; ".kepub.epub" by default
+	MinFileSize        int64    // bytes; 0 means no minimum
+	Language           string   // "" keeps the book's own
+	PreferFormats      []string // lowercased; empty processes every format
+	Backfill           int
+	BackfillSince      string
+	BackfillScanLimit  int // 0 means the monitor's default
+	Storage            StorageConfig
 }
 
-// Load reads the YAML config file, applies defaults, and validates.
-func Load(path string) (*Config, error) {
-	data, err := os.ReadFile(path)
-	if err != nil {
-		return nil, fmt.Errorf("reading config file: %w", err)
+// ErrNoChats is returned by Load when the config has no chats configured
+// and allow_empty isn't set.
+var ErrNoChats = errors.New("at least one chat must be configured")
+
+const (
+	// StdinSource is the --config value that reads YAML from standard input.
+	StdinSource = "-"
+	// EnvSource is the --config value that reads inline YAML from EnvVar.
+	EnvSource = "env"
+	// EnvVar holds inline YAML config when EnvSource is used.
+	EnvVar = "KPUB_CONFIG"
+	// SessionPassphraseVar, when set, encrypts the Telegram session file.
+	SessionPassphraseVar = "KPUB_SESSION_PASSPHRASE"
+)
+
+// IsFile reports whether path refers to a config file or directory on disk
+// rather than stdin or an environment variable.
+func IsFile(path string) bool {
+	return path != StdinSource && path != EnvSource
+}
+
+// IsDir reports whether path is a config directory (see Load).
+func IsDir(path string) bool {
+	if !IsFile(path) {
+		return false
 	}
+	info, err := os.Stat(path)
+	return err == nil && info.IsDir()
+}
 
+// Load reads the YAML config, applies defaults, and validates. path is
+// usually a file, but may also be StdinSource, EnvSource, or a config
+// directory whose files are merged by loadDir.
+func Load(path string) (*Config, error) {
 	var cfg Config
-	if err := yaml.Unmarshal(data, &cfg); err != nil {
-		return nil, fmt.Errorf("parsing config file: %w", err)
+	if IsDir(path) {
+		if err := loadDir(path, &cfg); err != nil {
+			return nil, err
+		}
+	} else {
+		data, err := readSource(path)
+		if err != nil {
+			return nil, err
+		}
+		if err := yaml.Unmarshal(data, &cfg); err != nil {
+			return nil, fmt.Errorf("parsing config file: %w", err)
+		}
 	}
 
+	if err := loadCredentials(&cfg.Telegram); err != nil {
+		return nil, err
+	}
 	applyDefaults(&cfg)
 
 	if err := validate(&cfg); err != nil {
@@ -77,7 +463,46 @@ func Load(path string) (*Config, error) {
 	return &cfg, nil
 }
 
+// readSource returns the raw YAML for the given config source.
+func readSource(path string) ([]byte, error) {
+	switch path {
+	case StdinSource:
+		data, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			return nil, fmt.Errorf("reading config from stdin: %w", err)
+		}
+		return data, nil
+	case EnvSource:
+		data, ok := os.LookupEnv(EnvVar)
+		if !ok || strings.TrimSpace(data) == "" {
+			return nil, fmt.Errorf("%s is not set", EnvVar)
+		}
+		return []byte(data), nil
+	default:
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("reading config file: %w", err)
+		}
+		return data, nil
+	}
+}
+
 func applyDefaults(cfg *Config) {
+	if cfg.Telegram.SessionFile == "" {
+		cfg.Telegram.SessionFile = "/data/session.json"
+	}
+	if cfg.Telegram.UpdateWatchdog == 0 {
+		cfg.Telegram.UpdateWatchdog = time.Hour
+	}
+	if cfg.Telegram.KeepAlive == 0 {
+		cfg.Telegram.KeepAlive = 5 * time.Minute
+	}
+	if cfg.Processing.MaxMessageAge == 0 {
+		cfg.Processing.MaxMessageAge = 24 * time.Hour
+	}
+	if cfg.Processing.UpdateBuffer == 0 {
+		cfg.Processing.UpdateBuffer = 256
+	}
 	if len(cfg.Defaults.AcceptedFormats) == 0 {
 		cfg.Defaults.AcceptedFormats = []string{".epub", ".mobi", ".azw3"}
 	}
@@ -87,26 +512,123 @@ func applyDefaults(cfg *Config) {
 	if cfg.Defaults.Storage.Dropbox.TokenFile == "" {
 		cfg.Defaults.Storage.Dropbox.TokenFile = "/data/dropbox.json"
 	}
+	if cfg.Defaults.Storage.Email.SMTPPort == 0 {
+		cfg.Defaults.Storage.Email.SMTPPort = 587
+	}
 	if cfg.Defaults.Storage.Dropbox.UploadPath == "" {
 		cfg.Defaults.Storage.Dropbox.UploadPath = "/Apps/Rakuten Kobo/"
 	}
+	if cfg.Defaults.Storage.Dropbox.DateFormat == "" {
+		cfg.Defaults.Storage.Dropbox.DateFormat = "2006/01"
+	}
 	if cfg.Paths.DownloadDir == "" {
 		cfg.Paths.DownloadDir = "/data/downloads"
 	}
 	if cfg.Paths.ConvertedDir == "" {
 		cfg.Paths.ConvertedDir = "/data/converted"
 	}
+	if cfg.Paths.HistoryFile == "" {
+		cfg.Paths.HistoryFile = "/data/history.jsonl"
+	}
+	if cfg.Paths.UploadStateDir == "" {
+		cfg.Paths.UploadStateDir = "/data/uploads"
+	}
 }
 
 func validate(cfg *Config) error {
 	if cfg.Telegram.AppID == 0 {
-		return fmt.Errorf("telegram.app_id is required")
+		return fmt.Errorf("telegram.app_id is required (or set it in telegram.credentials_file or $%s)", AppIDVar)
 	}
 	if cfg.Telegram.AppHash == "" {
-		return fmt.Errorf("telegram.app_hash is required")
+		return fmt.Errorf("telegram.app_hash is required (or set it in telegram.credentials_file or $%s)", AppHashVar)
+	}
+	if cfg.Telegram.DC < 0 || cfg.Telegram.DC > 5 {
+		return fmt.Errorf("telegram.dc must be between 1 and 5, got %d", cfg.Telegram.DC)
+	}
+	if cfg.Telegram.NotifyChatID != 0 && cfg.Telegram.NotifyBotToken == "" {
+		return fmt.Errorf("telegram.notify_chat_id requires telegram.notify_bot_token")
+	}
+	if len(cfg.Chats) == 0 && !cfg.AllowEmpty {
+		return ErrNoChats
+	}
+	if cfg.Processing.Debounce < 0 {
+		return fmt.Errorf("processing.debounce must not be negative")
+	}
+	if cfg.Processing.FileTimeout < 0 {
+		return fmt.Errorf("processing.file_timeout must not be negative")
+	}
+	if _, err := throttle.ParseRate(cfg.Processing.BandwidthLimit); err != nil {
+		return fmt.Errorf("processing.bandwidth_limit: %w", err)
+	}
+	if cfg.Processing.Workers < 0 || cfg.Processing.MaxQueue < 0 {
+		return fmt.Errorf("processing.workers and processing.max_queue must not be negative")
+	}
+	if _, err := converter.NewChain(cfg.Processing.Converters); err != nil {
+		return fmt.Errorf("processing.converters: %w", err)
+	}
+	if cfg.Processing.PreferWindow < 0 {
+		return fmt.Errorf("processing.prefer_window must not be negative")
+	}
+	if cfg.Processing.MaxDownloads < 0 || cfg.Processing.MaxConversions < 0 {
+		return fmt.Errorf("processing.max_downloads and processing.max_conversions must not be negative")
+	}
+	if err := validateConversionLimits("processing.conversion_limits", cfg.Processing.ConversionLimits); err != nil {
+		return err
+	}
+	if cfg.Processing.MaxQueue > 0 && cfg.Processing.Workers == 0 {
+		return fmt.Errorf("processing.max_queue requires processing.workers to be set")
+	}
+	switch cfg.Processing.QueuePolicy {
+	case "", "block", "reject", "drop_oldest":
+	default:
+		return fmt.Errorf("processing.queue_policy must be block, reject or drop_oldest, got %q", cfg.Processing.QueuePolicy)
+	}
+	if len(cfg.Processing.PostProcess) > 0 && strings.TrimSpace(cfg.Processing.PostProcess[0]) == "" {
+		return fmt.Errorf("processing.post_process: command must not be empty")
+	}
+
+	for field, text := range map[string]string{
+		"messages.processing": cfg.Messages.Processing,
+		"messages.success":    cfg.Messages.Success,
+		"messages.failure":    cfg.Messages.Failure,
+	} {
+		if _, err := template.New(field).Parse(text); err != nil {
+			return fmt.Errorf("%s: %w", field, err)
+		}
+	}
+
+	if err := validateFormats("defaults.accepted_formats", cfg.Defaults.AcceptedFormats); err != nil {
+		return err
+	}
+	if err := validateFilterMode("defaults.filter_mode", cfg.Defaults.FilterMode); err != nil {
+		return err
+	}
+	if err := validateNotifyTarget("defaults.error_notify_to", cfg.Defaults.ErrorNotifyTo); err != nil {
+		return err
+	}
+	if err := validateNotifyTarget("notifications.target", cfg.Notifications.Target); err != nil {
+		return err
+	}
+	if err := validateNotifyLevel("notifications.level", cfg.Notifications.Level); err != nil {
+		return err
+	}
+	if err := validateExtensions("defaults.prefer_formats", cfg.Defaults.PreferFormats); err != nil {
+		return err
 	}
-	if len(cfg.Chats) == 0 {
-		return fmt.Errorf("at least one chat must be configured")
+	if err := validateExtensions("defaults.output_formats", cfg.Defaults.OutputFormats); err != nil {
+		return err
+	}
+	if err := validateEncoding("defaults.input_encoding", cfg.Defaults.InputEncoding); err != nil {
+		return err
+	}
+	if err := validateLanguage("defaults.language", cfg.Defaults.Language); err != nil {
+		return err
+	}
+	if err := validateConvertedExtension("defaults.converted_extension", cfg.Defaults.ConvertedExtension); err != nil {
+		return err
+	}
+	if _, err := throttle.ParseSize(cfg.Defaults.MinFileSize); err != nil {
+		return fmt.Errorf("defaults.min_file_size: %w", err)
 	}
 
 	handles := make(map[string]bool)
@@ -114,13 +636,81 @@ func validate(cfg *Config) error {
 		if chat.Handle == "" {
 			return fmt.Errorf("chats[%d].handle is required", i)
 		}
-		if !strings.HasPrefix(chat.Handle, "@") {
-			return fmt.Errorf("chats[%d].handle must start with @", i)
+		if _, err := ParseChatRef(chat.Handle); err != nil {
+			return fmt.Errorf("chats[%d].handle: %w", i, err)
 		}
-		if handles[chat.Handle] {
+		if handles[HandleKey(chat.Handle)] {
 			return fmt.Errorf("duplicate chat handle: %q", chat.Handle)
 		}
-		handles[chat.Handle] = true
+		handles[HandleKey(chat.Handle)] = true
+
+		if err := validateFormats(fmt.Sprintf("chats[%d].accepted_formats", i), chat.AcceptedFormats); err != nil {
+			return err
+		}
+		if err := validateFilterMode(fmt.Sprintf("chats[%d].filter_mode", i), chat.FilterMode); err != nil {
+			return err
+		}
+		if err := validateNotifyTarget(fmt.Sprintf("chats[%d].error_notify_to", i), chat.ErrorNotifyTo); err != nil {
+			return err
+		}
+		if err := validateNotifyLevel(fmt.Sprintf("chats[%d].notify_level", i), chat.NotifyLevel); err != nil {
+			return err
+		}
+		if err := validateNoConvert(i, cfg, chat); err != nil {
+			return err
+		}
+		if err := validateExtensions(fmt.Sprintf("chats[%d].prefer_formats", i), chat.PreferFormats); err != nil {
+			return err
+		}
+		if err := validateExtensions(fmt.Sprintf("chats[%d].output_formats", i), chat.OutputFormats); err != nil {
+			return err
+		}
+		if err := validateEncoding(fmt.Sprintf("chats[%d].input_encoding", i), chat.InputEncoding); err != nil {
+			return err
+		}
+		if err := validateLanguage(fmt.Sprintf("chats[%d].language", i), chat.Language); err != nil {
+			return err
+		}
+		if err := validateConvertedExtension(fmt.Sprintf("chats[%d].converted_extension", i), chat.ConvertedExtension); err != nil {
+			return err
+		}
+		if _, err := throttle.ParseSize(chat.MinFileSize); err != nil {
+			return fmt.Errorf("chats[%d].min_file_size: %w", i, err)
+		}
+		if chat.Storage != nil {
+			if err := validateDateFormat(fmt.Sprintf("chats[%d].storage.dropbox.date_format", i), chat.Storage.Dropbox.DateFormat); err != nil {
+				return err
+			}
+			if err := validatePathRoot(fmt.Sprintf("chats[%d].storage.dropbox.path_root", i), chat.Storage.Dropbox.PathRoot); err != nil {
+				return err
+			}
+			if err := validateIfExists(fmt.Sprintf("chats[%d].storage.dropbox.if_exists", i), chat.Storage.Dropbox.IfExists); err != nil {
+				return err
+			}
+			if err := validateChunkSize(fmt.Sprintf("chats[%d].storage.dropbox.chunk_size", i), chat.Storage.Dropbox.ChunkSize); err != nil {
+				return err
+			}
+			if chat.Storage.MaxConcurrentUploads < 0 {
+				return fmt.Errorf("chats[%d].storage.max_concurrent_uploads must not be negative", i)
+			}
+		}
+		if chat.Backfill < 0 {
+			return fmt.Errorf("chats[%d].backfill must not be negative", i)
+		}
+		if chat.BackfillScanLimit < 0 {
+			return fmt.Errorf("chats[%d].backfill_scan_limit must not be negative", i)
+		}
+		if chat.BackfillScanLimit > 0 && chat.Backfill == 0 {
+			return fmt.Errorf("chats[%d].backfill_scan_limit requires backfill to be set", i)
+		}
+		if chat.BackfillSince != "" {
+			if chat.Backfill == 0 {
+				return fmt.Errorf("chats[%d].backfill_since requires backfill to be set", i)
+			}
+			if _, err := ParseSince(chat.BackfillSince, time.Now()); err != nil {
+				return fmt.Errorf("chats[%d].backfill_since: %w", i, err)
+			}
+		}
 	}
 
 	// Validate storage config for defaults (and any chat-level overrides)
@@ -133,21 +723,399 @@ func validate(cfg *Config) error {
 			return fmt.Errorf("defaults.storage.dropbox.app_secret is required")
 		}
 	}
+	if err := validateDateFormat("defaults.storage.dropbox.date_format", cfg.Defaults.Storage.Dropbox.DateFormat); err != nil {
+		return err
+	}
+	if err := validatePathRoot("defaults.storage.dropbox.path_root", cfg.Defaults.Storage.Dropbox.PathRoot); err != nil {
+		return err
+	}
+	if err := validateIfExists("defaults.storage.dropbox.if_exists", cfg.Defaults.Storage.Dropbox.IfExists); err != nil {
+		return err
+	}
+	if err := validateChunkSize("defaults.storage.dropbox.chunk_size", cfg.Defaults.Storage.Dropbox.ChunkSize); err != nil {
+		return err
+	}
+	if cfg.Defaults.Storage.MaxConcurrentUploads < 0 {
+		return fmt.Errorf("defaults.storage.max_concurrent_uploads must not be negative")
+	}
+	if cfg.Defaults.Storage.Type == "email" {
+		if err := validateEmail("defaults.storage.email", cfg.Defaults.Storage.Email); err != nil {
+			return err
+		}
+	}
+	if cfg.Defaults.Storage.Type == "b2" {
+		if err := validateB2("defaults.storage.b2", cfg.Defaults.Storage.B2); err != nil {
+			return err
+		}
+	}
+	for i, chat := range cfg.Chats {
+		resolved := ResolvedChatConfig(cfg, chat)
+		if resolved.Storage.Type == "email" && chat.Storage != nil {
+			if err := validateEmail(fmt.Sprintf("chats[%d].storage.email", i), resolved.Storage.Email); err != nil {
+				return err
+			}
+		}
+		if resolved.Storage.Type == "b2" && chat.Storage != nil {
+			if err := validateB2(fmt.Sprintf("chats[%d].storage.b2", i), resolved.Storage.B2); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// validateEmail checks the fields an email backend can't work without.
+func validateEmail(field string, e EmailConfig) error {
+	if e.SMTPHost == "" {
+		return fmt.Errorf("%s.smtp_host is required", field)
+	}
+	if e.From == "" {
+		return fmt.Errorf("%s.from is required", field)
+	}
+	if e.To == "" {
+		return fmt.Errorf("%s.to is required", field)
+	}
+	return nil
+}
+
+var (
+	encodingPattern = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_.:-]*$`)
+	languagePattern = regexp.MustCompile(`^[A-Za-z]{2,3}(-[A-Za-z0-9]{2,8})*$`)
+)
+
+// validateEncoding checks that an optional input_encoding looks like a
+// codec name such as "utf-8" or "cp1251". Whether Calibre knows it is only
+// found out at conversion time.
+func validateEncoding(field, enc string) error {
+	if enc != "" && !encodingPattern.MatchString(enc) {
+		return fmt.Errorf("%s: %q is not an encoding name like \"utf-8\" or \"cp1251\"", field, enc)
+	}
+	return nil
+}
+
+// validateLanguage checks that an optional language is a language code
+// like "ru", "deu" or "pt-BR".
+func validateLanguage(field, lang string) error {
+	if lang != "" && !languagePattern.MatchString(lang) {
+		return fmt.Errorf("%s: %q is not a language code like \"ru\" or \"pt-BR\"", field, lang)
+	}
+	return nil
+}
+
+// validateConvertedExtension checks that an optional converted_extension is
+// one a KEPUB can sensibly be named with.
+func validateConvertedExtension(field, ext string) error {
+	switch NormalizeFormat(ext) {
+	case "", ".kepub.epub", ".kepub", ".epub":
+		return nil
+	}
+	return fmt.Errorf("%s: must be \".kepub.epub\", \".kepub\" or \".epub\", got %q", field, ext)
+}
+
+// validateB2 checks the fields a B2 backend can't work without.
+func validateB2(field string, b B2Config) error {
+	if b.KeyID == "" {
+		return fmt.Errorf("%s.key_id is required", field)
+	}
+	if b.ApplicationKey == "" {
+		return fmt.Errorf("%s.application_key is required", field)
+	}
+	if b.Bucket == "" {
+		return fmt.Errorf("%s.bucket is required", field)
+	}
+	return nil
+}
+
+// validatePathRoot checks that an optional path_root is a Dropbox path root
+// object: {".tag": "home"}, or a "root" or "namespace_id" tag with a numeric
+// namespace ID.
+func validatePathRoot(field, v string) error {
+	if v == "" {
+		return nil
+	}
+	var root map[string]string
+	if err := json.Unmarshal([]byte(v), &root); err != nil {
+		return fmt.Errorf("%s: must be a JSON object like {\".tag\": \"root\", \"root\": \"1234567\"}: %w", field, err)
+	}
+	tag := root[".tag"]
+	switch tag {
+	case "home":
+		return nil
+	case "root", "namespace_id":
+		id := root[tag]
+		if id == "" || strings.TrimFunc(id, func(r rune) bool { return r >= '0' && r <= '9' }) != "" {
+			return fmt.Errorf("%s: %q must be a numeric namespace ID, got %q", field, tag, id)
+		}
+		return nil
+	default:
+		return fmt.Errorf("%s: .tag must be \"home\", \"root\" or \"namespace_id\", got %q", field, tag)
+	}
+}
+
+// Dropbox upload session chunk bounds. Dropbox accepts up to 150 MiB per
+// request; much below 1 MiB the per-request overhead dominates.
+const (
+	minChunkSize = 1 << 20
+	maxChunkSize = 150 << 20
+)
+
+// validateChunkSize checks that an optional chunk_size parses and is within
+// what Dropbox accepts.
+func validateChunkSize(field, v string) error {
+	n, err := throttle.ParseSize(v)
+	if err != nil {
+		return fmt.Errorf("%s: %w", field, err)
+	}
+	if v != "" && (n < minChunkSize || n > maxChunkSize) {
+		return fmt.Errorf("%s: must be between 1MiB and 150MiB, got %q", field, v)
+	}
+	return nil
+}
+
+// validateDateFormat checks that an optional date_format actually contains
+// date fields and yields a relative folder path.
+func validateDateFormat(field, layout string) error {
+	if layout == "" {
+		return nil
+	}
+	ref := time.Date(2001, 2, 3, 4, 5, 6, 0, time.UTC)
+	folder := ref.Format(layout)
+	if folder == layout {
+		return fmt.Errorf("%s: %q has no date fields, use a Go layout like \"2006/01\"", field, layout)
+	}
+	if strings.HasPrefix(folder, "/") || strings.Contains(folder, "..") {
+		return fmt.Errorf("%s: %q must produce a relative folder", field, layout)
+	}
+	return nil
+}
 
+// validateFormats rejects a wildcard entry mixed with specific formats, which
+// would otherwise silently accept everything, and entries that aren't
+// extensions.
+func validateFormats(field string, formats []string) error {
+	for _, f := range formats {
+		if isWildcard(f) {
+			if len(formats) > 1 {
+				return fmt.Errorf("%s: %q cannot be combined with other formats", field, f)
+			}
+			continue
+		}
+		if !validExtension(NormalizeFormat(f)) {
+			return fmt.Errorf("%s: %q must be an extension like \".epub\"", field, f)
+		}
+	}
+	return nil
+}
+
+// validateConversionLimits checks that every key is an extension and every
+// limit allows at least one conversion.
+func validateConversionLimits(field string, limits map[string]int) error {
+	for ext, n := range limits {
+		if isWildcard(ext) || !validExtension(NormalizeFormat(ext)) {
+			return fmt.Errorf("%s: %q must be an extension like \".pdf\"", field, ext)
+		}
+		if n < 1 {
+			return fmt.Errorf("%s: limit for %q must be at least 1, got %d", field, ext, n)
+		}
+	}
 	return nil
 }
 
-// ResolvedChatConfig merges per-chat overrides onto global defaults.
-func ResolvedChatConfig(defaults DefaultsConfig, chat ChatConfig) ResolvedChat {
+func validateFilterMode(field, mode string) error {
+	switch mode {
+	case "", FilterAny, FilterAll:
+		return nil
+	}
+	return fmt.Errorf("%s: must be %q or %q, got %q", field, FilterAny, FilterAll, mode)
+}
+
+func validateNotifyLevel(field, level string) error {
+	switch level {
+	case "", NotifyErrors, NotifyAll:
+		return nil
+	}
+	return fmt.Errorf("%s: must be %q or %q, got %q", field, NotifyErrors, NotifyAll, level)
+}
+
+func validateIfExists(field, policy string) error {
+	switch policy {
+	case "", IfExistsUpload, IfExistsSkip, IfExistsSkipIdentical:
+		return nil
+	}
+	return fmt.Errorf("%s: must be %q, %q or %q, got %q", field, IfExistsUpload, IfExistsSkip, IfExistsSkipIdentical, policy)
+}
+
+// validateExtensions checks that every entry is an extension like ".epub",
+// after NormalizeFormat, so "EPUB" and "epub" are fine too.
+func validateExtensions(field string, exts []string) error {
+	for _, f := range exts {
+		if !validExtension(NormalizeFormat(f)) {
+			return fmt.Errorf("%s: %q must be an extension like \".epub\"", field, f)
+		}
+	}
+	return nil
+}
+
+// validExtension reports whether a normalized format is a usable extension.
+func validExtension(ext string) bool {
+	return len(ext) >= 2 && !isWildcard(ext) && !strings.ContainsAny(ext, `/\ `)
+}
+
+// validateNoConvert checks that no_convert_formats are extensions the chat
+// actually accepts, so a typo doesn't silently do nothing.
+func validateNoConvert(i int, cfg *Config, chat ChatConfig) error {
+	if len(chat.NoConvertFormats) == 0 {
+		return nil
+	}
+	resolved := ResolvedChatConfig(cfg, chat)
+	for _, f := range chat.NoConvertFormats {
+		ext := NormalizeFormat(f)
+		if !validExtension(ext) {
+			return fmt.Errorf("chats[%d].no_convert_formats: %q must be an extension like \".pdf\"", i, f)
+		}
+		if !resolved.AcceptAll && !resolved.AcceptedFormats[ext] {
+			return fmt.Errorf("chats[%d].no_convert_formats: %q is not in the chat's accepted formats", i, f)
+		}
+	}
+	return nil
+}
+
+// validateNotifyTarget checks an optional notification handle. Invite links
+// aren't accepted since kpub won't join a chat just to post to it.
+func validateNotifyTarget(field, handle string) error {
+	if handle == "" {
+		return nil
+	}
+	ref, err := ParseChatRef(handle)
+	if err != nil {
+		return fmt.Errorf("%s: %w", field, err)
+	}
+	if ref.Kind == RefInvite {
+		return fmt.Errorf("%s: invite links can't be used, give an @handle or chat ID", field)
+	}
+	return nil
+}
+
+// NormalizeMimeType lowercases a MIME type and drops any parameters, so
+// "Application/EPUB+zip; charset=binary" matches "application/epub+zip".
+func NormalizeMimeType(mimeType string) string {
+	t, _, _ := strings.Cut(mimeType, ";")
+	return strings.ToLower(strings.TrimSpace(t))
+}
+
+// NormalizeFormat lowercases a format and adds the leading dot if it's
+// missing, so "EPUB", ".epub" and "epub" all become ".epub". The "*" and
+// "any" wildcards are only lowercased.
+func NormalizeFormat(format string) string {
+	f := strings.ToLower(strings.TrimSpace(format))
+	if f == "" || isWildcard(f) || strings.HasPrefix(f, ".") {
+		return f
+	}
+	return "." + f
+}
+
+// isWildcard reports whether an accepted_formats entry means "accept all".
+func isWildcard(format string) bool {
+	f := strings.ToLower(strings.TrimSpace(format))
+	return f == "*" || f == "any"
+}
+
+// ResolvedChatConfig merges per-chat overrides onto cfg's defaults and
+// notifications.
+func ResolvedChatConfig(cfg *Config, chat ChatConfig) ResolvedChat {
+	defaults := cfg.Defaults
+
 	// Accepted formats: use chat-specific if provided, else global defaults
 	formats := defaults.AcceptedFormats
 	if len(chat.AcceptedFormats) > 0 {
 		formats = chat.AcceptedFormats
 	}
 
+	acceptAll := false
 	fmtMap := make(map[string]bool, len(formats))
 	for _, f := range formats {
-		fmtMap[strings.ToLower(f)] = true
+		if isWildcard(f) {
+			acceptAll = true
+			continue
+		}
+		fmtMap[NormalizeFormat(f)] = true
+	}
+
+	// MIME types and filter mode: same chat-over-defaults rule
+	mimeTypes := defaults.AcceptedMimeTypes
+	if len(chat.AcceptedMimeTypes) > 0 {
+		mimeTypes = chat.AcceptedMimeTypes
+	}
+	mimeMap := make(map[string]bool, len(mimeTypes))
+	for _, t := range mimeTypes {
+		mimeMap[NormalizeMimeType(t)] = true
+	}
+	mode := defaults.FilterMode
+	if chat.FilterMode != "" {
+		mode = chat.FilterMode
+	}
+	errorNotifyTo := cmp.Or(chat.ErrorNotifyTo, defaults.ErrorNotifyTo, cfg.Notifications.Target)
+	notifyLevel := cmp.Or(chat.NotifyLevel, cfg.Notifications.Level, NotifyErrors)
+
+	preferFormats := defaults.PreferFormats
+	if len(chat.PreferFormats) > 0 {
+		preferFormats = chat.PreferFormats
+	}
+	prefer := make([]string, 0, len(preferFormats))
+	for _, f := range preferFormats {
+		prefer = append(prefer, NormalizeFormat(f))
+	}
+
+	noConvert := make(map[string]bool, len(chat.NoConvertFormats))
+	for _, f := range chat.NoConvertFormats {
+		noConvert[NormalizeFormat(f)] = true
+	}
+
+	// Conversion: chat fields override defaults, which override the built-in
+	// KEPUB conversion.
+	convert := true
+	if defaults.Convert != nil {
+		convert = *defaults.Convert
+	}
+	if chat.Convert != nil {
+		convert = *chat.Convert
+	}
+	outputFormats := defaults.OutputFormats
+	if len(chat.OutputFormats) > 0 {
+		outputFormats = chat.OutputFormats
+	}
+	outputs := make([]string, 0, len(outputFormats))
+	for _, f := range outputFormats {
+		outputs = append(outputs, NormalizeFormat(f))
+	}
+	if len(outputs) == 0 {
+		outputs = []string{".kepub.epub"}
+	}
+	convertOptions := defaults.ConvertOptions
+	if len(chat.ConvertOptions) > 0 {
+		convertOptions = chat.ConvertOptions
+	}
+	inputEncoding := defaults.InputEncoding
+	if chat.InputEncoding != "" {
+		inputEncoding = chat.InputEncoding
+	}
+	language := defaults.Language
+	if chat.Language != "" {
+		language = chat.Language
+	}
+	minSize := defaults.MinFileSize
+	if chat.MinFileSize != "" {
+		minSize = chat.MinFileSize
+	}
+	// Validated by Load.
+	minFileSize, _ := throttle.ParseSize(minSize)
+	convertedExt := ".kepub.epub"
+	if defaults.ConvertedExtension != "" {
+		convertedExt = NormalizeFormat(defaults.ConvertedExtension)
+	}
+	if chat.ConvertedExtension != "" {
+		convertedExt = NormalizeFormat(chat.ConvertedExtension)
 	}
 
 	// Storage: start with global defaults, overlay chat-specific fields
@@ -156,6 +1124,9 @@ func ResolvedChatConfig(defaults DefaultsConfig, chat ChatConfig) ResolvedChat {
 		if chat.Storage.Type != "" {
 			storage.Type = chat.Storage.Type
 		}
+		if chat.Storage.MaxConcurrentUploads != 0 {
+			storage.MaxConcurrentUploads = chat.Storage.MaxConcurrentUploads
+		}
 		// Merge dropbox sub-fields
 		if chat.Storage.Dropbox.AppKey != "" {
 			storage.Dropbox.AppKey = chat.Storage.Dropbox.AppKey
@@ -169,11 +1140,84 @@ func ResolvedChatConfig(defaults DefaultsConfig, chat ChatConfig) ResolvedChat {
 		if chat.Storage.Dropbox.UploadPath != "" {
 			storage.Dropbox.UploadPath = chat.Storage.Dropbox.UploadPath
 		}
+		if chat.Storage.Dropbox.DateFolders {
+			storage.Dropbox.DateFolders = true
+		}
+		if chat.Storage.Dropbox.DateFormat != "" {
+			storage.Dropbox.DateFormat = chat.Storage.Dropbox.DateFormat
+		}
+		if chat.Storage.Dropbox.FolderPerChat {
+			storage.Dropbox.FolderPerChat = true
+		}
+		if chat.Storage.Dropbox.PathRoot != "" {
+			storage.Dropbox.PathRoot = chat.Storage.Dropbox.PathRoot
+		}
+		if chat.Storage.Dropbox.IfExists != "" {
+			storage.Dropbox.IfExists = chat.Storage.Dropbox.IfExists
+		}
+		if chat.Storage.Dropbox.NotifyRefresh {
+			storage.Dropbox.NotifyRefresh = true
+		}
+		if chat.Storage.Dropbox.ChunkSize != "" {
+			storage.Dropbox.ChunkSize = chat.Storage.Dropbox.ChunkSize
+		}
+		// Merge email sub-fields
+		e := chat.Storage.Email
+		if e.SMTPHost != "" {
+			storage.Email.SMTPHost = e.SMTPHost
+		}
+		if e.SMTPPort != 0 {
+			storage.Email.SMTPPort = e.SMTPPort
+		}
+		if e.Username != "" {
+			storage.Email.Username = e.Username
+		}
+		if e.Password != "" {
+			storage.Email.Password = e.Password
+		}
+		if e.From != "" {
+			storage.Email.From = e.From
+		}
+		if e.To != "" {
+			storage.Email.To = e.To
+		}
+		// Merge B2 sub-fields
+		b := chat.Storage.B2
+		if b.KeyID != "" {
+			storage.B2.KeyID = b.KeyID
+		}
+		if b.ApplicationKey != "" {
+			storage.B2.ApplicationKey = b.ApplicationKey
+		}
+		if b.Bucket != "" {
+			storage.B2.Bucket = b.Bucket
+		}
+		if b.Prefix != "" {
+			storage.B2.Prefix = b.Prefix
+		}
 	}
 
 	return ResolvedChat{
-		Handle:          chat.Handle,
-		AcceptedFormats: fmtMap,
-		Storage:         storage,
+		Handle:             chat.Handle,
+		Enabled:            chat.Enabled == nil || *chat.Enabled,
+		AcceptedFormats:    fmtMap,
+		AcceptAll:          acceptAll,
+		AcceptedMimeTypes:  mimeMap,
+		RequireAll:         mode == FilterAll,
+		ErrorNotifyTo:      errorNotifyTo,
+		NotifyLevel:        notifyLevel,
+		Convert:            convert,
+		NoConvertFormats:   noConvert,
+		OutputFormats:      outputs,
+		ConvertOptions:     convertOptions,
+		InputEncoding:      inputEncoding,
+		Language:           language,
+		ConvertedExtension: convertedExt,
+		MinFileSize:        minFileSize,
+		PreferFormats:      prefer,
+		Backfill:           chat.Backfill,
+		BackfillSince:      chat.BackfillSince,
+		BackfillScanLimit:  chat.BackfillScanLimit,
+		Storage:            storage,
 	}
 }
@@ -0,0 +1,140 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestNormalizeFormat(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"EPUB", ".epub"},
+		{".epub", ".epub"},
+		{" .Epub ", ".epub"},
+		{"kepub.epub", ".kepub.epub"},
+		{".KEPUB.EPUB", ".kepub.epub"},
+		{"", ""},
+		{"   ", ""},
+		{"ANY", "any"},
+		{"*", "*"},
+	}
+	for _, tt := range tests {
+		if got := NormalizeFormat(tt.in); got != tt.want {
+			t.Errorf("NormalizeFormat(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+// TestResolvedChatConversion checks that each conversion setting comes from
+// the chat if set, else from defaults, else the built-in default.
+func TestResolvedChatConversion(t *testing.T) {
+	yes, no := true, false
+	type want struct {
+		convert        bool
+		outputs        []string
+		convertOptions []string
+		inputEncoding  string
+		language       string
+		convertedExt   string
+	}
+	builtIn := want{convert: true, outputs: []string{".kepub.epub"}, convertedExt: ".kepub.epub"}
+
+	defaults := DefaultsConfig{
+		Convert:            &no,
+		OutputFormats:      []string{"AZW3"},
+		ConvertOptions:     []string{"--output-profile", "kindle"},
+		InputEncoding:      "cp1251",
+		Language:           "ru",
+		ConvertedExtension: "kepub",
+	}
+	fromDefaults := want{
+		convert:        false,
+		outputs:        []string{".azw3"},
+		convertOptions: []string{"--output-profile", "kindle"},
+		inputEncoding:  "cp1251",
+		language:       "ru",
+		convertedExt:   ".kepub",
+	}
+	chat := ChatConfig{
+		Handle:             "@books",
+		Convert:            &yes,
+		OutputFormats:      []string{".epub", "kepub.epub"},
+		ConvertOptions:     []string{"--output-profile", "kobo"},
+		InputEncoding:      "utf-8",
+		Language:           "pt-BR",
+		ConvertedExtension: ".epub",
+	}
+	fromChat := want{
+		convert:        true,
+		outputs:        []string{".epub", ".kepub.epub"},
+		convertOptions: []string{"--output-profile", "kobo"},
+		inputEncoding:  "utf-8",
+		language:       "pt-BR",
+		convertedExt:   ".epub",
+	}
+
+	tests := []struct {
+		name     string
+		defaults DefaultsConfig
+		chat     ChatConfig
+		want     want
+	}{
+		{"built-in defaults", DefaultsConfig{}, ChatConfig{Handle: "@books"}, builtIn},
+		{"defaults over built-in", defaults, ChatConfig{Handle: "@books"}, fromDefaults},
+		{"chat over defaults", defaults, chat, fromChat},
+		{"chat over built-in", DefaultsConfig{}, chat, fromChat},
+		{
+			name:     "chat turns conversion off, the rest from defaults",
+			defaults: DefaultsConfig{Convert: &yes, Language: "ru"},
+			chat:     ChatConfig{Handle: "@books", Convert: &no},
+			want:     want{convert: false, outputs: []string{".kepub.epub"}, language: "ru", convertedExt: ".kepub.epub"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := ResolvedChatConfig(&Config{Defaults: tt.defaults}, tt.chat)
+			got := want{
+				convert:        r.Convert,
+				outputs:        r.OutputFormats,
+				convertOptions: r.ConvertOptions,
+				inputEncoding:  r.InputEncoding,
+				language:       r.Language,
+				convertedExt:   r.ConvertedExtension,
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("resolved conversion = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadRejectsCaseVariantDuplicates(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	data := `telegram:
+  app_id: 1
+  app_hash: "hash"
+defaults:
+  accepted_formats: [".epub"]
+  storage:
+    type: dropbox
+    dropbox:
+      app_key: "key"
+      app_secret: "secret"
+      token_file: "/data/dropbox.json"
+      upload_path: "/Books"
+chats:
+  - handle: "@Book_Bot"
+  - handle: "@book_bot"
+`
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	_, err := Load(path)
+	if err == nil || !strings.Contains(err.Error(), `duplicate chat handle: "@book_bot"`) {
+		t.Errorf("Load = %v, want a duplicate handle error", err)
+	}
+}
@@ -0,0 +1,85 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// AppIDVar and AppHashVar supply Telegram credentials that are neither
+	// in the config nor in telegram.credentials_file.
+	AppIDVar   = "KPUB_TELEGRAM_APP_ID"
+	AppHashVar = "KPUB_TELEGRAM_APP_HASH"
+)
+
+// telegramCredentials is the format of telegram.credentials_file. JSON works
+// too, since it's valid YAML.
+type telegramCredentials struct {
+	AppID   int    `yaml:"app_id"`
+	AppHash string `yaml:"app_hash"`
+}
+
+// loadCredentials fills in a missing app_id or app_hash, first from
+// CredentialsFile and then from the environment. Values set in the config
+// always win.
+func loadCredentials(t *TelegramConfig) error {
+	if t.CredentialsFile != "" && (t.AppID == 0 || t.AppHash == "") {
+		data, err := os.ReadFile(t.CredentialsFile)
+		if err != nil {
+			return fmt.Errorf("reading telegram.credentials_file: %w", err)
+		}
+		var creds telegramCredentials
+		if err := yaml.Unmarshal(data, &creds); err != nil {
+			return fmt.Errorf("parsing telegram.credentials_file %q: %w", t.CredentialsFile, err)
+		}
+		if creds.AppID == 0 && creds.AppHash == "" {
+			return fmt.Errorf("telegram.credentials_file %q has neither app_id nor app_hash", t.CredentialsFile)
+		}
+		t.fill(creds.AppID, creds.AppHash)
+	}
+
+	if t.AppID == 0 {
+		if v := os.Getenv(AppIDVar); v != "" {
+			id, err := strconv.Atoi(v)
+			if err != nil {
+				return fmt.Errorf("$%s: %q is not a number", AppIDVar, v)
+			}
+			t.fill(id, "")
+		}
+	}
+	if t.AppHash == "" {
+		t.fill(0, os.Getenv(AppHashVar))
+	}
+	return nil
+}
+
+// fill sets whichever of AppID and AppHash are still empty, remembering that
+// they came from outside the config so they aren't written back into it.
+func (t *TelegramConfig) fill(appID int, appHash string) {
+	if t.AppID == 0 && appID != 0 {
+		t.AppID = appID
+		t.externalAppID = true
+	}
+	if t.AppHash == "" && appHash != "" {
+		t.AppHash = appHash
+		t.externalAppHash = true
+	}
+}
+
+// MarshalYAML leaves out credentials that were loaded from
+// telegram.credentials_file or the environment, so rewriting the config
+// (e.g. after /add) doesn't copy secrets into it.
+func (t TelegramConfig) MarshalYAML() (any, error) {
+	type plain TelegramConfig
+	p := plain(t)
+	if t.externalAppID {
+		p.AppID = 0
+	}
+	if t.externalAppHash {
+		p.AppHash = ""
+	}
+	return p, nil
+}
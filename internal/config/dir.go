@@ -0,0 +1,85 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// BaseFile is the file in a config directory that holds everything except
+// chats. Every other *.yaml / *.yml file is a chat fragment.
+const BaseFile = "config.yaml"
+
+// chatFragment is the only content allowed in a non-base file.
+type chatFragment struct {
+	Chats []ChatConfig `yaml:"chats"`
+}
+
+// loadDir reads BaseFile from dir into cfg, then appends the chats from every
+// other YAML file in lexical filename order. Fragments may only set chats, and
+// a handle may only appear once across all files.
+func loadDir(dir string, cfg *Config) error {
+	basePath := filepath.Join(dir, BaseFile)
+	data, err := os.ReadFile(basePath)
+	if err != nil {
+		return fmt.Errorf("reading config file: %w", err)
+	}
+	if err := yaml.Unmarshal(data, cfg); err != nil {
+		return fmt.Errorf("parsing %s: %w", basePath, err)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return fmt.Errorf("reading config directory: %w", err)
+	}
+
+	seen := make(map[string]string, len(cfg.Chats))
+	for _, chat := range cfg.Chats {
+		seen[HandleKey(chat.Handle)] = BaseFile
+	}
+
+	var names []string
+	for _, e := range entries {
+		ext := filepath.Ext(e.Name())
+		if e.IsDir() || e.Name() == BaseFile || (ext != ".yaml" && ext != ".yml") {
+			continue
+		}
+		names = append(names, e.Name())
+	}
+	slices.Sort(names)
+
+	for _, name := range names {
+		path := filepath.Join(dir, name)
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("reading %s: %w", path, err)
+		}
+
+		var frag chatFragment
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+		if err := dec.Decode(&frag); err != nil && !errors.Is(err, io.EOF) {
+			if strings.Contains(err.Error(), "not found in type") {
+				return fmt.Errorf("%s: only \"chats\" may be set outside %s: %w", path, BaseFile, err)
+			}
+			return fmt.Errorf("parsing %s: %w", path, err)
+		}
+
+		for _, chat := range frag.Chats {
+			if prev, ok := seen[HandleKey(chat.Handle)]; ok {
+				return fmt.Errorf("duplicate chat handle %q in %s (already in %s)", chat.Handle, name, prev)
+			}
+			seen[HandleKey(chat.Handle)] = name
+			cfg.Chats = append(cfg.Chats, chat)
+		}
+	}
+
+	return nil
+}
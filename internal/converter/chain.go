@@ -0,0 +1,141 @@
+package converter
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Engine is a Converter that can say up front which conversions it handles,
+// so a Chain only tries it where it can work.
+type Engine interface {
+	Converter
+	Name() string
+	// Supports reports whether the engine can turn a file with extension
+	// inputExt into format, both lowercased with a leading dot.
+	Supports(inputExt, format string) bool
+}
+
+// Name implements Engine.
+func (Calibre) Name() string { return "calibre" }
+
+// Supports implements Engine. ebook-convert handles every format kpub sees.
+func (Calibre) Supports(_, _ string) bool { return true }
+
+// Kepubify converts EPUB to KEPUB with kepubify, which is much faster than
+// Calibre and keeps the book's own styling, but reads nothing but EPUB.
+type Kepubify struct{}
+
+// Name implements Engine.
+func (Kepubify) Name() string { return "kepubify" }
+
+// Supports implements Engine.
+func (Kepubify) Supports(inputExt, format string) bool {
+	return inputExt == ".epub" && format == DefaultFormat
+}
+
+// Convert implements Converter. convert_options are for ebook-convert and
+// aren't passed on.
+func (Kepubify) Convert(ctx context.Context, inputPath, outDir string) (string, error) {
+	baseName := filepath.Base(inputPath)
+	outputPath := filepath.Join(outDir, strings.TrimSuffix(baseName, filepath.Ext(baseName))+DefaultFormat)
+
+	slog.Info("Starting conversion with kepubify", "input", inputPath, "output", outputPath)
+	started := time.Now()
+
+	cmd := exec.CommandContext(ctx, "kepubify", "-o", outputPath, inputPath)
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	if err := cmd.Run(); err != nil {
+		os.Remove(outputPath)
+		return "", fmt.Errorf("kepubify failed: %v\nOutput: %s", err, output.String())
+	}
+
+	slog.Info("kepubify completed successfully")
+	outputPath, err := findOutput(outputPath, DefaultFormat, started)
+	if err != nil {
+		return "", err
+	}
+	keepSeries(ctx, inputPath, outputPath)
+	return renameKEPUB(ctx, outputPath)
+}
+
+// engines are the Engines a Chain can be built from, by name.
+var engines = map[string]Engine{
+	"calibre":  Calibre{},
+	"kepubify": Kepubify{},
+}
+
+// Result describes a finished conversion.
+type Result struct {
+	Path     string
+	Engine   string // name of the engine that produced Path
+	Fallback bool   // an earlier engine failed first
+}
+
+// Chain is a Converter that tries its engines in order, skipping those that
+// don't support the conversion, until one succeeds.
+type Chain struct {
+	engines []Engine
+}
+
+// NewChain builds a Chain from engine names, e.g. ["kepubify", "calibre"].
+// No names means Calibre alone.
+func NewChain(names []string) (*Chain, error) {
+	if len(names) == 0 {
+		names = []string{"calibre"}
+	}
+	c := &Chain{}
+	for _, name := range names {
+		e, ok := engines[strings.ToLower(strings.TrimSpace(name))]
+		if !ok {
+			return nil, fmt.Errorf("unknown converter %q, expected \"calibre\" or \"kepubify\"", name)
+		}
+		c.engines = append(c.engines, e)
+	}
+	return c, nil
+}
+
+// Convert implements Converter.
+func (c *Chain) Convert(ctx context.Context, inputPath, outDir string) (string, error) {
+	r, err := c.ConvertWith(ctx, inputPath, outDir)
+	return r.Path, err
+}
+
+// ConvertWith is Convert, also reporting which engine did the work. The
+// output format comes from WithTarget, as for Calibre.
+func (c *Chain) ConvertWith(ctx context.Context, inputPath, outDir string) (Result, error) {
+	t := targetFrom(ctx)
+	inputExt := strings.ToLower(filepath.Ext(inputPath))
+
+	var lastErr error
+	for _, e := range c.engines {
+		if !e.Supports(inputExt, t.format) {
+			continue
+		}
+		out, err := e.Convert(ctx, inputPath, outDir)
+		if err == nil {
+			if lastErr != nil {
+				slog.Info("Converted with fallback engine", "engine", e.Name(), "input", inputPath)
+			}
+			return Result{Path: out, Engine: e.Name(), Fallback: lastErr != nil}, nil
+		}
+		lastErr = fmt.Errorf("%s: %w", e.Name(), err)
+		if ctx.Err() != nil {
+			break
+		}
+		first, _, _ := strings.Cut(err.Error(), "\n")
+		slog.Warn("Conversion engine failed, trying the next one", "engine", e.Name(), "input", inputPath, "error", first)
+	}
+	if lastErr == nil {
+		return Result{}, fmt.Errorf("no configured converter can turn %s into %s", inputExt, t.format)
+	}
+	return Result{}, lastErr
+}
@@ -4,30 +4,215 @@ import (
 	"bytes"
 	"context"
 	"fmt"
+	"io"
 	"log/slog"
+	"os"
 	"os/exec"
 	"path/filepath"
+	"slices"
 	"strings"
+	"time"
 )
 
-// Convert runs ebook-convert to produce a .kepub.epub file in convertedDir.
-// Returns the path to the converted file.
+// Converter turns a downloaded ebook into the file that gets uploaded.
+// Convert writes its output into outDir and returns the output path.
+type Converter interface {
+	Convert(ctx context.Context, inputPath, outDir string) (string, error)
+}
+
+// DefaultFormat is the extension Calibre converts to unless WithTarget says
+// otherwise.
+const DefaultFormat = ".kepub.epub"
+
+// Calibre converts to KEPUB with Calibre's ebook-convert, or to the format
+// attached with WithTarget.
+type Calibre struct{}
+
+// Convert implements Converter.
+func (Calibre) Convert(ctx context.Context, inputPath, outDir string) (string, error) {
+	return Convert(ctx, inputPath, outDir)
+}
+
+// Identity copies the input into outDir unchanged, for files that are
+// already in the right format.
+type Identity struct{}
+
+// Convert implements Converter.
+func (Identity) Convert(_ context.Context, inputPath, outDir string) (string, error) {
+	outputPath := filepath.Join(outDir, filepath.Base(inputPath))
+
+	in, err := os.Open(inputPath)
+	if err != nil {
+		return "", fmt.Errorf("opening %q: %w", inputPath, err)
+	}
+	defer in.Close()
+
+	out, err := os.Create(outputPath)
+	if err != nil {
+		return "", fmt.Errorf("creating %q: %w", outputPath, err)
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(outputPath)
+		return "", fmt.Errorf("copying to %q: %w", outputPath, err)
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(outputPath)
+		return "", fmt.Errorf("closing %q: %w", outputPath, err)
+	}
+	return outputPath, nil
+}
+
+// Func adapts an ordinary function to a Converter, which makes it easy to
+// fake conversion in tests.
+type Func func(ctx context.Context, inputPath, outDir string) (string, error)
+
+// Convert implements Converter.
+func (f Func) Convert(ctx context.Context, inputPath, outDir string) (string, error) {
+	return f(ctx, inputPath, outDir)
+}
+
+type targetKey struct{}
+
+type target struct {
+	format string
+	args   []string
+}
+
+// WithTarget returns a context that makes Convert produce format, an output
+// extension like ".kepub.epub" or ".azw3", passing args to ebook-convert
+// after the input and output paths.
+func WithTarget(ctx context.Context, format string, args []string) context.Context {
+	return context.WithValue(ctx, targetKey{}, target{format: format, args: args})
+}
+
+type extensionKey struct{}
+
+// WithExtension returns a context that makes KEPUB conversions name their
+// output with ext, e.g. ".kepub" or ".epub", instead of DefaultFormat. The
+// contents are the same; only the name changes, for readers that expect it.
+func WithExtension(ctx context.Context, ext string) context.Context {
+	return context.WithValue(ctx, extensionKey{}, ext)
+}
+
+// outputExtensions are the extensions ebook-convert can write, which
+// findOutput accepts in place of the one asked for.
+var outputExtensions = []string{
+	".azw3", ".docx", ".epub", ".fb2", ".htmlz", ".kepub", ".kepub.epub", ".lit", ".lrf", ".mobi",
+	".oeb", ".pdb", ".pdf", ".pml", ".rb", ".rtf", ".snb", ".tcr", ".txt", ".txtz", ".zip",
+}
+
+// findOutput returns the file an engine that reported success wrote for
+// want, which ends in format. That is usually want itself, but
+// ebook-convert has been seen to exit 0 having written nothing, or a file
+// with another extension, so when want is missing this looks in its
+// directory for a file with the same stem and an ebook extension written
+// since started. The stem must match exactly, so "Book.kepub.epub" can't
+// pick up "Book.Vol2.kepub.epub" from another conversion. An error lets a
+// Chain fall back to its next engine instead of uploading nothing.
+func findOutput(want, format string, started time.Time) (string, error) {
+	if _, err := os.Stat(want); err == nil {
+		return want, nil
+	}
+	dir := filepath.Dir(want)
+	stem := strings.TrimSuffix(filepath.Base(want), format)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return "", fmt.Errorf("converter reported success but %q is missing: %w", want, err)
+	}
+	var found string
+	var newest time.Time
+	for _, e := range entries {
+		ext, ok := strings.CutPrefix(e.Name(), stem)
+		if e.IsDir() || !ok || (ext != format && !slices.Contains(outputExtensions, strings.ToLower(ext))) {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil || info.ModTime().Before(started) || info.ModTime().Before(newest) {
+			continue
+		}
+		found, newest = filepath.Join(dir, e.Name()), info.ModTime()
+	}
+	if found == "" {
+		return "", fmt.Errorf("converter reported success but wrote no output: %q is missing", want)
+	}
+	slog.Warn("Converted file has a different name than expected", "expected", want, "found", found)
+	return found, nil
+}
+
+// renameKEPUB renames a finished KEPUB conversion at p to the extension
+// attached with WithExtension, if any, returning the new path. Engines
+// write DefaultFormat first because ebook-convert picks the output format
+// from the name.
+func renameKEPUB(ctx context.Context, p string) (string, error) {
+	ext, _ := ctx.Value(extensionKey{}).(string)
+	if ext == "" || ext == DefaultFormat || !strings.HasSuffix(p, DefaultFormat) {
+		return p, nil
+	}
+	renamed := strings.TrimSuffix(p, DefaultFormat) + ext
+	if err := os.Rename(p, renamed); err != nil {
+		os.Remove(p)
+		return "", fmt.Errorf("renaming %q to %s: %w", p, ext, err)
+	}
+	return renamed, nil
+}
+
+// CalibreArgs returns the ebook-convert arguments for an input encoding and
+// a language, either of which may be empty, followed by extra.
+func CalibreArgs(inputEncoding, language string, extra []string) []string {
+	var args []string
+	if inputEncoding != "" {
+		args = append(args, "--input-encoding", inputEncoding)
+	}
+	if language != "" {
+		args = append(args, "--language", language)
+	}
+	return append(args, extra...)
+}
+
+// targetFrom returns the target attached with WithTarget, defaulting to
+// DefaultFormat.
+func targetFrom(ctx context.Context) target {
+	t, _ := ctx.Value(targetKey{}).(target)
+	if t.format == "" {
+		t.format = DefaultFormat
+	}
+	return t
+}
+
+// Convert runs ebook-convert to produce a .kepub.epub file in convertedDir,
+// or the format attached with WithTarget. Returns the path to the converted
+// file. Progress is reported to the ProgressFunc attached with WithProgress,
+// if any.
 func Convert(ctx context.Context, inputPath, convertedDir string) (string, error) {
+	t := targetFrom(ctx)
+
 	baseName := filepath.Base(inputPath)
 	ext := filepath.Ext(baseName)
-	newBaseName := strings.TrimSuffix(baseName, ext) + ".kepub.epub"
+	newBaseName := strings.TrimSuffix(baseName, ext) + t.format
 	outputPath := filepath.Join(convertedDir, newBaseName)
 
 	slog.Info("Starting conversion with ebook-convert", "input", inputPath, "output", outputPath)
+	started := time.Now()
 
-	cmd := exec.CommandContext(ctx, "ebook-convert", inputPath, outputPath)
+	cmd := exec.CommandContext(ctx, "ebook-convert", append([]string{inputPath, outputPath}, t.args...)...)
 
 	var stderr bytes.Buffer
 	cmd.Stderr = &stderr
+	if fn := progressFrom(ctx); fn != nil {
+		cmd.Stdout = &progressWriter{fn: fn}
+	}
 	if err := cmd.Run(); err != nil {
+		// Don't leave a partial output behind if ebook-convert was killed.
+		os.Remove(outputPath)
 		return "", fmt.Errorf("ebook-convert failed: %v\nStderr: %s", err, stderr.String())
 	}
 
 	slog.Info("ebook-convert completed successfully")
-	return outputPath, nil
+	outputPath, err := findOutput(outputPath, t.format, started)
+	if err != nil {
+		return "", err
+	}
+	keepSeries(ctx, inputPath, outputPath)
+	return renameKEPUB(ctx, outputPath)
 }
@@ -0,0 +1,84 @@
+package converter
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFindOutput(t *testing.T) {
+	started := time.Now()
+	stale := started.Add(-time.Hour)
+
+	tests := []struct {
+		name  string
+		files map[string]time.Time // name → modification time
+		want  string               // "" expects an error
+	}{
+		{
+			name:  "expected output",
+			files: map[string]time.Time{"Book.kepub.epub": started},
+			want:  "Book.kepub.epub",
+		},
+		{
+			name:  "missing output",
+			files: map[string]time.Time{"Other.epub": started.Add(time.Second)},
+		},
+		{
+			name:  "other extension written since started",
+			files: map[string]time.Time{"Book.epub": started.Add(time.Second)},
+			want:  "Book.epub",
+		},
+		{
+			name:  "newest of several",
+			files: map[string]time.Time{"Book.epub": started.Add(time.Second), "Book.mobi": started.Add(2 * time.Second)},
+			want:  "Book.mobi",
+		},
+		{
+			name:  "stale file from before started",
+			files: map[string]time.Time{"Book.epub": stale},
+		},
+		{
+			name:  "another conversion with a longer stem",
+			files: map[string]time.Time{"Book.Vol2.kepub.epub": started.Add(time.Second)},
+		},
+		{
+			name:  "not an ebook extension",
+			files: map[string]time.Time{"Book.log": started.Add(time.Second)},
+		},
+		{
+			name:  "extension case",
+			files: map[string]time.Time{"Book.EPUB": started.Add(time.Second)},
+			want:  "Book.EPUB",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for name, mtime := range tt.files {
+				p := filepath.Join(dir, name)
+				if err := os.WriteFile(p, []byte("book"), 0o600); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.Chtimes(p, mtime, mtime); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			got, err := findOutput(filepath.Join(dir, "Book.kepub.epub"), DefaultFormat, started)
+			if tt.want == "" {
+				if err == nil {
+					t.Fatalf("findOutput = %q, want an error so a Chain falls back", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("findOutput: %v", err)
+			}
+			if want := filepath.Join(dir, tt.want); got != want {
+				t.Errorf("findOutput = %q, want %q", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,49 @@
+package converter
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// PostProcess runs a user-configured hook command on a converted file.
+// argv is executed directly, never through a shell, and the placeholders
+// {file} and {name} are replaced with the file's path and base name in each
+// argument. The path is also exported as KPUB_FILE. A non-zero exit returns
+// an error including the command's output.
+func PostProcess(ctx context.Context, argv []string, path string) error {
+	if len(argv) == 0 {
+		return nil
+	}
+
+	replacer := strings.NewReplacer("{file}", path, "{name}", filepath.Base(path))
+	args := make([]string, len(argv))
+	for i, a := range argv {
+		args[i] = replacer.Replace(a)
+	}
+
+	slog.Info("Running post-process hook", "command", argv[0], "file", path)
+
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Env = append(os.Environ(), "KPUB_FILE="+path)
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+
+	if out := strings.TrimSpace(stdout.String()); out != "" {
+		slog.Debug("Post-process hook stdout", "output", out)
+	}
+	if err != nil {
+		return fmt.Errorf("post-process hook %q failed: %v\nStderr: %s", argv[0], err, stderr.String())
+	}
+
+	slog.Info("Post-process hook completed successfully")
+	return nil
+}
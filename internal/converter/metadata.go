@@ -0,0 +1,194 @@
+package converter
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Metadata is what a reader shows for a book.
+type Metadata struct {
+	Title       string
+	Authors     []string
+	Series      string
+	SeriesIndex string
+	HasCover    bool
+}
+
+// ReadMetadata reads the metadata of the ebook at p. EPUBs, including
+// KEPUBs, are read directly from their OPF package document, so Calibre
+// isn't needed for them; other formats go through Calibre's ebook-meta.
+func ReadMetadata(ctx context.Context, p string) (Metadata, error) {
+	if strings.EqualFold(filepath.Ext(p), ".epub") {
+		return readEPUBMetadata(p)
+	}
+	return readEbookMeta(ctx, p)
+}
+
+// opfPackage is the subset of an OPF package document ReadMetadata needs.
+// Series comes from Calibre's calibre:series meta, or EPUB 3's
+// belongs-to-collection.
+type opfPackage struct {
+	Metadata struct {
+		Titles   []string `xml:"title"`
+		Creators []string `xml:"creator"`
+		Metas    []struct {
+			Name     string `xml:"name,attr"`
+			Content  string `xml:"content,attr"`
+			Property string `xml:"property,attr"`
+			ID       string `xml:"id,attr"`
+			Refines  string `xml:"refines,attr"`
+			Value    string `xml:",chardata"`
+		} `xml:"meta"`
+	} `xml:"metadata"`
+	Manifest struct {
+		Items []struct {
+			ID         string `xml:"id,attr"`
+			Href       string `xml:"href,attr"`
+			Properties string `xml:"properties,attr"`
+		} `xml:"item"`
+	} `xml:"manifest"`
+}
+
+func readEPUBMetadata(p string) (Metadata, error) {
+	zr, err := zip.OpenReader(p)
+	if err != nil {
+		return Metadata{}, fmt.Errorf("opening %s: %w", p, err)
+	}
+	defer zr.Close()
+
+	pkg, opfPath, err := readOPF(&zr.Reader, p)
+	if err != nil {
+		return Metadata{}, err
+	}
+
+	var md Metadata
+	if len(pkg.Metadata.Titles) > 0 {
+		md.Title = strings.TrimSpace(pkg.Metadata.Titles[0])
+	}
+	for _, c := range pkg.Metadata.Creators {
+		if c = strings.TrimSpace(c); c != "" {
+			md.Authors = append(md.Authors, c)
+		}
+	}
+
+	coverID := ""
+	for _, m := range pkg.Metadata.Metas {
+		switch {
+		case m.Name == "calibre:series":
+			md.Series = m.Content
+		case m.Name == "calibre:series_index":
+			md.SeriesIndex = m.Content
+		case m.Name == "cover":
+			coverID = m.Content
+		case m.Property == "belongs-to-collection" && md.Series == "":
+			md.Series = strings.TrimSpace(m.Value)
+			for _, r := range pkg.Metadata.Metas {
+				if r.Property == "group-position" && r.Refines == "#"+m.ID {
+					md.SeriesIndex = strings.TrimSpace(r.Value)
+				}
+			}
+		}
+	}
+
+	// The cover counts only if the manifest item it names is in the file.
+	dir := path.Dir(opfPath)
+	for _, item := range pkg.Manifest.Items {
+		if item.ID != coverID && !strings.Contains(" "+item.Properties+" ", " cover-image ") {
+			continue
+		}
+		if _, err := zr.Open(path.Join(dir, item.Href)); err == nil {
+			md.HasCover = true
+			break
+		}
+	}
+	return md, nil
+}
+
+// readOPF decodes the package document of the EPUB p, returning it and its
+// path inside the EPUB.
+func readOPF(zr *zip.Reader, p string) (opfPackage, string, error) {
+	var container struct {
+		Rootfiles []struct {
+			FullPath string `xml:"full-path,attr"`
+		} `xml:"rootfiles>rootfile"`
+	}
+	if err := decodeZipXML(zr, "META-INF/container.xml", &container); err != nil {
+		return opfPackage{}, "", err
+	}
+	if len(container.Rootfiles) == 0 {
+		return opfPackage{}, "", fmt.Errorf("%s: container.xml names no package document", p)
+	}
+	opfPath := container.Rootfiles[0].FullPath
+
+	var pkg opfPackage
+	if err := decodeZipXML(zr, opfPath, &pkg); err != nil {
+		return opfPackage{}, "", err
+	}
+	return pkg, opfPath, nil
+}
+
+// decodeZipXML decodes the XML file name inside an EPUB into v.
+func decodeZipXML(zr *zip.Reader, name string, v any) error {
+	f, err := zr.Open(name)
+	if err != nil {
+		return fmt.Errorf("reading %s: %w", name, err)
+	}
+	defer f.Close()
+	if err := xml.NewDecoder(f).Decode(v); err != nil {
+		return fmt.Errorf("parsing %s: %w", name, err)
+	}
+	return nil
+}
+
+// readEbookMeta runs ebook-meta, which prints "Field : value" lines, and
+// asks it to extract the cover to learn whether there is one.
+func readEbookMeta(ctx context.Context, p string) (Metadata, error) {
+	cover, err := os.CreateTemp("", "kpub-cover-*")
+	if err != nil {
+		return Metadata{}, err
+	}
+	cover.Close()
+	os.Remove(cover.Name()) // ebook-meta only creates it if there is a cover
+	defer os.Remove(cover.Name())
+
+	out, err := exec.CommandContext(ctx, "ebook-meta", p, "--get-cover", cover.Name()).Output()
+	if err != nil {
+		return Metadata{}, fmt.Errorf("ebook-meta: %w", err)
+	}
+
+	var md Metadata
+	for _, line := range strings.Split(string(out), "\n") {
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		switch strings.TrimSpace(key) {
+		case "Title":
+			md.Title = value
+		case "Author(s)":
+			// "Jane Doe [Doe, Jane] & John Roe" lists authors with their sort names.
+			for _, a := range strings.Split(value, " & ") {
+				name, _, _ := strings.Cut(a, " [")
+				md.Authors = append(md.Authors, strings.TrimSpace(name))
+			}
+		case "Series":
+			// "Name #2"
+			md.Series, md.SeriesIndex = value, ""
+			if i := strings.LastIndex(value, " #"); i >= 0 {
+				md.Series, md.SeriesIndex = value[:i], value[i+2:]
+			}
+		}
+	}
+	if info, err := os.Stat(cover.Name()); err == nil && info.Size() > 0 {
+		md.HasCover = true
+	}
+	return md, nil
+}
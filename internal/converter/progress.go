@@ -0,0 +1,78 @@
+package converter
+
+import (
+	"bytes"
+	"context"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ProgressFunc receives conversion progress as a percentage (0-100) and the
+// stage description ebook-convert printed alongside it.
+type ProgressFunc func(percent int, stage string)
+
+type progressKey struct{}
+
+// WithProgress returns a context that makes Convert report progress to fn.
+func WithProgress(ctx context.Context, fn ProgressFunc) context.Context {
+	return context.WithValue(ctx, progressKey{}, fn)
+}
+
+func progressFrom(ctx context.Context) ProgressFunc {
+	fn, _ := ctx.Value(progressKey{}).(ProgressFunc)
+	return fn
+}
+
+// progressLine matches ebook-convert's "34% Running transforms on e-book..."
+// lines. Older Calibre versions print the percentage as a fraction ("0.34")
+// or with leading whitespace, so both are accepted.
+var progressLine = regexp.MustCompile(`^\s*(\d{1,3}(?:\.\d+)?)(%?)\s+(.*)$`)
+
+// progressWriter scans ebook-convert's stdout for progress lines. Lines that
+// don't look like progress are ignored, and progress is only reported when
+// the percentage goes up.
+type progressWriter struct {
+	fn   ProgressFunc
+	buf  bytes.Buffer
+	last int
+}
+
+func (w *progressWriter) Write(p []byte) (int, error) {
+	w.buf.Write(p)
+	for {
+		data := w.buf.Bytes()
+		i := bytes.IndexAny(data, "\r\n")
+		if i < 0 {
+			break
+		}
+		line := string(data[:i])
+		w.buf.Next(i + 1)
+		w.parse(line)
+	}
+	return len(p), nil
+}
+
+func (w *progressWriter) parse(line string) {
+	m := progressLine.FindStringSubmatch(line)
+	if m == nil {
+		return
+	}
+	v, err := strconv.ParseFloat(m[1], 64)
+	if err != nil {
+		return
+	}
+	if m[2] == "" {
+		// Without a percent sign only a 0-1 fraction is unambiguous.
+		if !strings.Contains(m[1], ".") || v > 1 {
+			return
+		}
+		v *= 100
+	}
+	percent := min(int(v), 100)
+	if percent <= w.last {
+		return
+	}
+	w.last = percent
+	w.fn(percent, strings.TrimSpace(m[3]))
+}
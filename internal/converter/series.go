@@ -0,0 +1,72 @@
+package converter
+
+import (
+	"archive/zip"
+	"context"
+	"fmt"
+	"log/slog"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// keepSeries copies the series and series index of the source book at src
+// into the converted book at out when out has none, so the Kobo can group
+// it with the rest of its series. The Kobo only reads Calibre's
+// calibre:series meta, which kepubify doesn't add when the source has just
+// an EPUB 3 collection. A series already in out, including one set with
+// ebook-convert's --series option, is left alone. It needs Calibre's
+// ebook-meta, and failing to copy the series only logs a warning, since
+// the book is still readable without it.
+func keepSeries(ctx context.Context, src, out string) {
+	if _, err := exec.LookPath("ebook-meta"); err != nil {
+		return
+	}
+	want, err := ReadMetadata(ctx, src)
+	if err != nil {
+		slog.Debug("Could not read series from source", "input", src, "error", err)
+		return
+	}
+	if want.Series == "" {
+		return
+	}
+	if has, err := hasSeries(ctx, out); err != nil || has {
+		return
+	}
+
+	args := []string{out, "--series", want.Series}
+	if want.SeriesIndex != "" {
+		args = append(args, "--index", want.SeriesIndex)
+	}
+	if output, err := exec.CommandContext(ctx, "ebook-meta", args...).CombinedOutput(); err != nil {
+		slog.Warn("Could not copy series to converted file", "output", out, "series", want.Series,
+			"error", fmt.Errorf("ebook-meta: %v: %s", err, strings.TrimSpace(string(output))))
+		return
+	}
+	slog.Info("Copied series to converted file", "series", want.Series, "index", want.SeriesIndex)
+}
+
+// hasSeries reports whether the book at p names a series the Kobo reads.
+// For EPUBs that means a calibre:series meta; an EPUB 3 collection alone
+// doesn't count.
+func hasSeries(ctx context.Context, p string) (bool, error) {
+	if !strings.EqualFold(filepath.Ext(p), ".epub") {
+		md, err := readEbookMeta(ctx, p)
+		return md.Series != "", err
+	}
+	zr, err := zip.OpenReader(p)
+	if err != nil {
+		return false, fmt.Errorf("opening %s: %w", p, err)
+	}
+	defer zr.Close()
+	pkg, _, err := readOPF(&zr.Reader, p)
+	if err != nil {
+		return false, err
+	}
+	for _, m := range pkg.Metadata.Metas {
+		if m.Name == "calibre:series" && strings.TrimSpace(m.Content) != "" {
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,166 @@
+package dockerutil
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// dockerHubServer is the key Docker uses for Docker Hub credentials.
+const dockerHubServer = "https://index.docker.io/v1/"
+
+// registryAuthConfig is the JSON payload of the X-Registry-Auth header.
+type registryAuthConfig struct {
+	Username      string `json:"username,omitempty"`
+	Password      string `json:"password,omitempty"`
+	IdentityToken string `json:"identitytoken,omitempty"`
+	ServerAddress string `json:"serveraddress"`
+}
+
+// RegistryAuth returns the encoded X-Registry-Auth header value for pulling
+// image. If override is "user:password" it is used directly; otherwise
+// credentials are looked up in ~/.docker/config.json, including credential
+// helpers. Returns "" with no error when no credentials are configured.
+func RegistryAuth(image, override string) (string, error) {
+	server := registryHost(image)
+
+	if override != "" {
+		user, pass, ok := strings.Cut(override, ":")
+		if !ok || user == "" {
+			return "", fmt.Errorf("registry auth must be in the form user:password")
+		}
+		return encodeAuth(registryAuthConfig{Username: user, Password: pass, ServerAddress: server})
+	}
+
+	cfg, err := loadDockerConfig()
+	if err != nil || cfg == nil {
+		return "", err
+	}
+
+	if helper := cfg.helperFor(server); helper != "" {
+		auth, err := helperCredentials(helper, server)
+		if err != nil {
+			return "", err
+		}
+		return encodeAuth(auth)
+	}
+
+	for _, key := range []string{server, "https://" + server} {
+		entry, ok := cfg.Auths[key]
+		if !ok {
+			continue
+		}
+		auth := registryAuthConfig{IdentityToken: entry.IdentityToken, ServerAddress: server}
+		if entry.Auth != "" {
+			decoded, err := base64.StdEncoding.DecodeString(entry.Auth)
+			if err != nil {
+				return "", fmt.Errorf("decoding docker credentials for %s: %w", server, err)
+			}
+			auth.Username, auth.Password, _ = strings.Cut(string(decoded), ":")
+		}
+		return encodeAuth(auth)
+	}
+
+	return "", nil
+}
+
+// registryHost returns the registry server for an image reference, using
+// the same rule as Docker: the first path component is a registry if it
+// looks like a hostname.
+func registryHost(image string) string {
+	first, _, ok := strings.Cut(image, "/")
+	if ok && (strings.ContainsAny(first, ".:") || first == "localhost") {
+		return first
+	}
+	return dockerHubServer
+}
+
+func encodeAuth(auth registryAuthConfig) (string, error) {
+	data, err := json.Marshal(auth)
+	if err != nil {
+		return "", err
+	}
+	return base64.URLEncoding.EncodeToString(data), nil
+}
+
+// dockerConfig is the subset of ~/.docker/config.json kpub understands.
+type dockerConfig struct {
+	Auths map[string]struct {
+		Auth          string `json:"auth"`
+		IdentityToken string `json:"identitytoken"`
+	} `json:"auths"`
+	CredsStore  string            `json:"credsStore"`
+	CredHelpers map[string]string `json:"credHelpers"`
+}
+
+func (c *dockerConfig) helperFor(server string) string {
+	if h, ok := c.CredHelpers[server]; ok {
+		return h
+	}
+	return c.CredsStore
+}
+
+// loadDockerConfig reads $DOCKER_CONFIG/config.json or ~/.docker/config.json.
+// A missing file is not an error.
+func loadDockerConfig() (*dockerConfig, error) {
+	dir := os.Getenv("DOCKER_CONFIG")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, nil
+		}
+		dir = filepath.Join(home, ".docker")
+	}
+
+	path := filepath.Join(dir, "config.json")
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("reading %s: %w", path, err)
+	}
+
+	var cfg dockerConfig
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	return &cfg, nil
+}
+
+// helperCredentials asks docker-credential-<helper> for server's credentials.
+func helperCredentials(helper, server string) (registryAuthConfig, error) {
+	cmd := exec.Command("docker-credential-"+helper, "get")
+	cmd.Stdin = strings.NewReader(server)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		msg := strings.TrimSpace(string(out) + stderr.String())
+		if strings.Contains(msg, "credentials not found") {
+			return registryAuthConfig{ServerAddress: server}, nil
+		}
+		return registryAuthConfig{}, fmt.Errorf("docker-credential-%s: %s", helper, msg)
+	}
+
+	var creds struct {
+		Username string `json:"Username"`
+		Secret   string `json:"Secret"`
+	}
+	if err := json.Unmarshal(out, &creds); err != nil {
+		return registryAuthConfig{}, fmt.Errorf("parsing docker-credential-%s output: %w", helper, err)
+	}
+
+	auth := registryAuthConfig{ServerAddress: server}
+	if creds.Username == "<token>" {
+		auth.IdentityToken = creds.Secret
+	} else {
+		auth.Username, auth.Password = creds.Username, creds.Secret
+	}
+	return auth, nil
+}
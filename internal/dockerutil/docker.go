@@ -1,6 +1,7 @@
 package dockerutil
 
 import (
+	"bufio"
 	"context"
 	"encoding/json"
 	"fmt"
@@ -49,14 +50,97 @@ func StopContainer(name string) error {
 	return RemoveContainer(name)
 }
 
+// ContainerState is a coarse container status.
+type ContainerState string
+
+const (
+	ContainerRunning ContainerState = "running"
+	ContainerStopped ContainerState = "stopped" // exists but isn't running
+	ContainerAbsent  ContainerState = "absent"
+)
+
+// ContainerStatus reports whether a container with the given name is running,
+// exists but is stopped, or doesn't exist.
+func ContainerStatus(name string) (ContainerState, error) {
+	cmd := exec.Command("docker", "inspect", "-f", "{{.State.Running}}", name)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		if strings.Contains(string(out), "No such") {
+			return ContainerAbsent, nil
+		}
+		return "", fmt.Errorf("inspecting container %q: %s", name, strings.TrimSpace(string(out)))
+	}
+	if strings.TrimSpace(string(out)) == "true" {
+		return ContainerRunning, nil
+	}
+	return ContainerStopped, nil
+}
+
 // IsContainerRunning checks if a container with the given name is currently running.
 func IsContainerRunning(name string) bool {
-	cmd := exec.Command("docker", "inspect", "-f", "{{.State.Running}}", name)
-	out, err := cmd.Output()
+	state, err := ContainerStatus(name)
+	return err == nil && state == ContainerRunning
+}
+
+// ContainerLogs returns the combined stdout/stderr logs of a container.
+func ContainerLogs(name string) (string, error) {
+	out, err := exec.Command("docker", "logs", name).CombinedOutput()
 	if err != nil {
-		return false
+		return "", fmt.Errorf("reading logs for %q: %s", name, strings.TrimSpace(string(out)))
 	}
-	return strings.TrimSpace(string(out)) == "true"
+	return string(out), nil
+}
+
+// FollowLogs streams a container's combined stdout/stderr to output, one
+// line at a time, from the start of its logs until the container stops.
+func FollowLogs(name string, output chan<- string) error {
+	cmd := exec.Command("docker", "logs", "-f", name)
+	pr, pw := io.Pipe()
+	cmd.Stdout = pw
+	cmd.Stderr = pw
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("following logs for %q: %w", name, err)
+	}
+	go func() {
+		pw.CloseWithError(cmd.Wait())
+	}()
+
+	scanner := bufio.NewScanner(pr)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		output <- scanner.Text()
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("following logs for %q: %w", name, err)
+	}
+	return nil
+}
+
+// Version returns the Docker client and server versions, e.g.
+// "client 27.1.1, server 27.1.1", or "client 27.1.1, server unreachable"
+// if the daemon isn't running.
+func Version() (string, error) {
+	out, err := exec.Command("docker", "version", "--format", "client {{.Client.Version}}, server {{.Server.Version}}").Output()
+	v := strings.TrimSpace(string(out))
+	if err != nil {
+		if v == "" {
+			return "", fmt.Errorf("docker version: %w", err)
+		}
+		return v + " unreachable", nil
+	}
+	return v, nil
+}
+
+// RunInImage runs a command in a throwaway container from image, without
+// mounting anything, and returns its combined output. The image must
+// already be present; it is never pulled.
+func RunInImage(image string, command ...string) (string, error) {
+	args := []string{"run", "--rm", "--pull", "never", "--platform", "linux/amd64", "--entrypoint", command[0], image}
+	out, err := exec.Command("docker", append(args, command[1:]...)...).CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("running %s in %s: %s", command[0], image, strings.TrimSpace(string(out)))
+	}
+	return string(out), nil
 }
 
 // ImageExists checks if a Docker image exists locally.
@@ -67,9 +151,14 @@ func ImageExists(image string) bool {
 
 // PullImage pulls a Docker image via the Docker Engine API, streaming
 // progress to the output channel as human-readable lines like
-// "Downloading  120.5 MB / 557.3 MB".
-func PullImage(image string, output chan<- string) error {
-	name, tag := parseImageRef(image)
+// "Downloading  120.5 MB / 557.3 MB". registryAuth is an encoded
+// X-Registry-Auth value from RegistryAuth, or "" for anonymous pulls.
+// updated is false if the local image was already the latest.
+func PullImage(image, registryAuth string, output chan<- string) (updated bool, err error) {
+	name, tag, digest := parseImageRef(image)
+	if tag != "" && digest != "" {
+		output <- fmt.Sprintf("Pulling %s by digest; the tag %q is ignored", name, tag)
+	}
 
 	sock := dockerSocket()
 	httpc := &http.Client{
@@ -82,18 +171,30 @@ func PullImage(image string, output chan<- string) error {
 
 	params := url.Values{}
 	params.Set("fromImage", name)
-	params.Set("tag", tag)
+	params.Set("tag", pullRef(tag, digest))
 	params.Set("platform", "linux/amd64")
 
-	resp, err := httpc.Post("http://localhost/v1.41/images/create?"+params.Encode(), "", nil)
+	req, err := http.NewRequest(http.MethodPost, "http://localhost/v1.41/images/create?"+params.Encode(), nil)
 	if err != nil {
-		return fmt.Errorf("pull request failed: %w", err)
+		return false, fmt.Errorf("creating pull request: %w", err)
+	}
+	if registryAuth != "" {
+		req.Header.Set("X-Registry-Auth", registryAuth)
+	}
+
+	resp, err := httpc.Do(req)
+	if err != nil {
+		return false, fmt.Errorf("pull request failed: %w", err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("pull failed (HTTP %d): %s", resp.StatusCode, strings.TrimSpace(string(body)))
+		msg := strings.TrimSpace(string(body))
+		if resp.StatusCode == http.StatusUnauthorized || isAuthError(msg) {
+			return false, authRequiredError(image, msg)
+		}
+		return false, fmt.Errorf("pull failed (HTTP %d): %s", resp.StatusCode, msg)
 	}
 
 	tracker := &pullTracker{
@@ -107,14 +208,31 @@ func PullImage(image string, output chan<- string) error {
 			break
 		}
 		if evt.Error != "" {
-			return fmt.Errorf("pull: %s", evt.Error)
+			if isAuthError(evt.Error) {
+				return false, authRequiredError(image, evt.Error)
+			}
+			return false, fmt.Errorf("pull: %s", evt.Error)
 		}
+		tracker.update(evt)
 		if output != nil {
-			tracker.update(evt)
 			output <- tracker.render()
 		}
 	}
-	return nil
+	return tracker.updated, nil
+}
+
+// isAuthError reports whether a Docker error message means the registry
+// rejected or required credentials.
+func isAuthError(msg string) bool {
+	msg = strings.ToLower(msg)
+	return strings.Contains(msg, "unauthorized") ||
+		strings.Contains(msg, "authentication required") ||
+		strings.Contains(msg, "pull access denied")
+}
+
+func authRequiredError(image, msg string) error {
+	return fmt.Errorf("registry requires authentication to pull %s — run 'docker login %s' or pass --registry-auth user:password (%s)",
+		image, strings.TrimSuffix(registryHost(image), "/"), msg)
 }
 
 // pullEvent represents a single JSON event from the Docker pull stream.
@@ -142,6 +260,11 @@ type pullTracker struct {
 	ids    []string // insertion order
 	layers map[string]*layerProgress
 	header string // top-level status like "Pulling from ..."
+
+	// updated is set once Docker reports a new image, either by finishing
+	// a layer or in its closing "Downloaded newer image" status. An image
+	// that was already current ends with "Image is up to date" instead.
+	updated bool
 }
 
 func (t *pullTracker) update(evt pullEvent) {
@@ -150,8 +273,14 @@ func (t *pullTracker) update(evt pullEvent) {
 		if evt.Status != "" {
 			t.header = evt.Status
 		}
+		if strings.Contains(evt.Status, "Downloaded newer image") {
+			t.updated = true
+		}
 		return
 	}
+	if evt.Status == "Pull complete" {
+		t.updated = true
+	}
 
 	lp, ok := t.layers[evt.ID]
 	if !ok {
@@ -222,15 +351,34 @@ func (t *pullTracker) render() string {
 	return b.String()
 }
 
-// parseImageRef splits "ghcr.io/spacesedan/kpub:latest" into name and tag.
-func parseImageRef(image string) (name, tag string) {
-	if i := strings.LastIndex(image, ":"); i > 0 {
-		afterColon := image[i+1:]
-		if !strings.Contains(afterColon, "/") {
-			return image[:i], afterColon
-		}
+// parseImageRef splits an image reference into the repository, tag and
+// digest, following Docker's reference grammar. Either of tag and digest
+// may be empty:
+//
+//	ghcr.io/spacesedan/kpub:latest  → ghcr.io/spacesedan/kpub, latest, ""
+//	localhost:5000/kpub             → localhost:5000/kpub, "", ""
+//	kpub:1.2@sha256:abc...          → kpub, 1.2, sha256:abc...
+func parseImageRef(image string) (name, tag, digest string) {
+	name, digest, _ = strings.Cut(image, "@")
+
+	// Only a colon in the last path component starts a tag; one before a
+	// slash belongs to a registry port.
+	if i := strings.LastIndex(name, ":"); i > strings.LastIndex(name, "/") {
+		name, tag = name[:i], name[i+1:]
 	}
-	return image, "latest"
+	return name, tag, digest
+}
+
+// pullRef returns what to pull for a parsed reference: the digest when there
+// is one, as docker pull does, else the tag, defaulting to "latest".
+func pullRef(tag, digest string) string {
+	switch {
+	case digest != "":
+		return digest
+	case tag != "":
+		return tag
+	}
+	return "latest"
 }
 
 // dockerSocket returns the path to the Docker daemon Unix socket.
@@ -272,3 +420,15 @@ func RunContainer(name, image, dataDir string, detach bool) error {
 	}
 	return nil
 }
+
+// RemoveImage removes a local Docker image, ignoring "not found" errors.
+func RemoveImage(image string) error {
+	out, err := exec.Command("docker", "image", "rm", image).CombinedOutput()
+	if err != nil {
+		if strings.Contains(string(out), "No such image") {
+			return nil
+		}
+		return fmt.Errorf("removing image %q: %s", image, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
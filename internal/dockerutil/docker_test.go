@@ -0,0 +1,32 @@
+package dockerutil
+
+import "testing"
+
+func TestParseImageRef(t *testing.T) {
+	const digest = "sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+	tests := []struct {
+		image                string
+		name, tag, dig, pull string
+	}{
+		{"kpub", "kpub", "", "", "latest"},
+		{"kpub:1.2", "kpub", "1.2", "", "1.2"},
+		{"ghcr.io/spacesedan/kpub:latest", "ghcr.io/spacesedan/kpub", "latest", "", "latest"},
+		{"ghcr.io/spacesedan/kpub", "ghcr.io/spacesedan/kpub", "", "", "latest"},
+		{"localhost:5000/kpub", "localhost:5000/kpub", "", "", "latest"},
+		{"localhost:5000/kpub:dev", "localhost:5000/kpub", "dev", "", "dev"},
+		{"registry.example.com:443/team/kpub:v2", "registry.example.com:443/team/kpub", "v2", "", "v2"},
+		{"kpub@" + digest, "kpub", "", digest, digest},
+		{"localhost:5000/kpub@" + digest, "localhost:5000/kpub", "", digest, digest},
+		{"kpub:1.2@" + digest, "kpub", "1.2", digest, digest},
+		{"localhost:5000/kpub:1.2@" + digest, "localhost:5000/kpub", "1.2", digest, digest},
+	}
+	for _, tt := range tests {
+		name, tag, dig := parseImageRef(tt.image)
+		if name != tt.name || tag != tt.tag || dig != tt.dig {
+			t.Errorf("parseImageRef(%q) = %q, %q, %q, want %q, %q, %q", tt.image, name, tag, dig, tt.name, tt.tag, tt.dig)
+		}
+		if pull := pullRef(tag, dig); pull != tt.pull {
+			t.Errorf("pullRef for %q = %q, want %q", tt.image, pull, tt.pull)
+		}
+	}
+}
@@ -0,0 +1,226 @@
+package history
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Status is the outcome recorded for a file.
+type Status string
+
+const (
+	Delivered Status = "delivered"
+	Failed    Status = "failed"
+	Skipped   Status = "skipped"
+)
+
+// Entry is one line of the history log.
+type Entry struct {
+	Time     time.Time `json:"time"`
+	Chat     string    `json:"chat"`
+	FileName string    `json:"file_name"`
+	Status   Status    `json:"status"`
+	Reason   string    `json:"reason,omitempty"`
+
+	// Hash and Remote are set on delivered entries when
+	// processing.unique_names is on: the SHA-256 of the received file and
+	// the destination paths it was uploaded to.
+	Hash   string   `json:"hash,omitempty"`
+	Remote []string `json:"remote,omitempty"`
+}
+
+// Store appends entries to a JSON Lines file. A nil *Store records nothing,
+// so callers don't need to check whether history is enabled.
+type Store struct {
+	mu   sync.Mutex
+	path string
+}
+
+// Open returns a Store that appends to path, creating it on first write.
+func Open(path string) *Store {
+	return &Store{path: path}
+}
+
+// Record appends e, filling in Time if it's zero. The entry is on disk when
+// Record returns, so a crash right after can't lose it.
+func (s *Store) Record(e Entry) error {
+	if s == nil {
+		return nil
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f, err := os.OpenFile(s.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("opening history file: %w", err)
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return fmt.Errorf("writing history file: %w", err)
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return fmt.Errorf("syncing history file: %w", err)
+	}
+	return f.Close()
+}
+
+// Close waits for a Record in progress and flushes the log and its
+// directory entry to disk. It is called on shutdown, once the last file is
+// done; the Store can still be used afterwards.
+func (s *Store) Close() error {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, p := range []string{s.path, filepath.Dir(s.path)} {
+		f, err := os.Open(p)
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("opening history file: %w", err)
+		}
+		err = f.Sync()
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("syncing history file: %w", err)
+		}
+	}
+	return nil
+}
+
+// Read returns all entries in path, oldest first. A missing file is an empty
+// history, and lines that don't parse are skipped.
+func Read(path string) ([]Entry, error) {
+	lines, err := ReadLines(path)
+	if err != nil {
+		return nil, err
+	}
+	var entries []Entry
+	for _, l := range lines {
+		if l.Valid {
+			entries = append(entries, l.Entry)
+		}
+	}
+	return entries, nil
+}
+
+// Line is one line of the history log as written, with the entry it holds.
+// Valid is false for a line that doesn't parse, e.g. one cut short by a
+// crash.
+type Line struct {
+	Raw   []byte
+	Entry Entry
+	Valid bool
+}
+
+// ReadLines returns every line in path, oldest first, including those that
+// don't parse. A missing file has no lines.
+func ReadLines(path string) ([]Line, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("opening history file: %w", err)
+	}
+	defer f.Close()
+
+	var lines []Line
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		l := Line{Raw: slices.Clone(scanner.Bytes())}
+		l.Valid = json.Unmarshal(l.Raw, &l.Entry) == nil
+		lines = append(lines, l)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading history file: %w", err)
+	}
+	return lines, nil
+}
+
+// Rewrite replaces the history log at path with lines, written as they were
+// read. The new file is renamed into place, so a crash leaves either the old
+// log or the new one.
+func Rewrite(path string, lines []Line) error {
+	var buf bytes.Buffer
+	for _, l := range lines {
+		buf.Write(l.Raw)
+		buf.WriteByte('\n')
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, buf.Bytes(), 0o600); err != nil {
+		return fmt.Errorf("writing history file: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("replacing history file: %w", err)
+	}
+	return nil
+}
+
+// Delivered returns the file names already delivered from chat. A nil Store
+// has no history.
+func (s *Store) Delivered(chat string) (map[string]bool, error) {
+	if s == nil {
+		return nil, nil
+	}
+	s.mu.Lock()
+	entries, err := Read(s.path)
+	s.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	delivered := make(map[string]bool)
+	for _, e := range entries {
+		if e.Chat == chat && e.Status == Delivered {
+			delivered[e.FileName] = true
+		}
+	}
+	return delivered, nil
+}
+
+// Names returns, for each destination path recorded on a delivered entry,
+// the hash of the file last delivered there. A nil Store has no history.
+func (s *Store) Names() (map[string]string, error) {
+	if s == nil {
+		return nil, nil
+	}
+	s.mu.Lock()
+	entries, err := Read(s.path)
+	s.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	names := make(map[string]string)
+	for _, e := range entries {
+		if e.Status != Delivered || e.Hash == "" {
+			continue
+		}
+		for _, r := range e.Remote {
+			names[r] = e.Hash
+		}
+	}
+	return names, nil
+}
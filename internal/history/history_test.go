@@ -0,0 +1,65 @@
+package history
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+// TestRecordSurvivesRestart records entries, then reads them back through a
+// new Store, as the server does after a restart, with and without Close
+// having run.
+func TestRecordSurvivesRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.jsonl")
+
+	s := Open(path)
+	if err := s.Record(Entry{Chat: "@books", FileName: "a.epub", Status: Delivered, Hash: "aa", Remote: []string{"/Books/a.epub"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Record(Entry{Chat: "@books", FileName: "b.pdf", Status: Skipped, Reason: "format"}); err != nil {
+		t.Fatal(err)
+	}
+	// No Close: a process killed here must still have both entries.
+	entries, err := Read(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[0].FileName != "a.epub" || entries[1].Reason != "format" {
+		t.Fatalf("entries after a kill = %+v", entries)
+	}
+
+	if err := s.Record(Entry{Chat: "@other", FileName: "c.epub", Status: Delivered}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	reopened := Open(path)
+	delivered, err := reopened.Delivered("@books")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(delivered) != 1 || !delivered["a.epub"] {
+		t.Errorf("Delivered(@books) = %v, want just a.epub", delivered)
+	}
+	names, err := reopened.Names()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if names["/Books/a.epub"] != "aa" {
+		t.Errorf("Names = %v, want /Books/a.epub owned by aa", names)
+	}
+	if entries, _ := Read(path); len(entries) != 3 || entries[2].Time.IsZero() {
+		t.Errorf("entries after Close = %+v, want 3 with times filled in", entries)
+	}
+}
+
+func TestCloseWithoutHistory(t *testing.T) {
+	if err := Open(filepath.Join(t.TempDir(), "history.jsonl")).Close(); err != nil {
+		t.Errorf("Close before any Record: %v", err)
+	}
+	var s *Store
+	if err := s.Close(); err != nil {
+		t.Errorf("nil Store Close: %v", err)
+	}
+}
@@ -0,0 +1,265 @@
+// Package importer runs a folder of existing ebooks through the convert and
+// upload pipeline, without Telegram.
+package importer
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/spacesedan/kpub/internal/config"
+	"github.com/spacesedan/kpub/internal/converter"
+	"github.com/spacesedan/kpub/internal/history"
+	"github.com/spacesedan/kpub/internal/storage"
+	"github.com/spacesedan/kpub/internal/throttle"
+)
+
+// Source is the chat name imported files are recorded under in the history
+// log, so re-running an import skips files it already delivered.
+const Source = "import"
+
+// Result is the outcome for one file.
+type Result struct {
+	File   string
+	Status history.Status
+	Reason string
+}
+
+// ReportFunc is called as each file finishes. done counts finished files,
+// including this one, out of total.
+type ReportFunc func(done, total int, r Result)
+
+// Importer converts and uploads local files using a chat's settings.
+type Importer struct {
+	cfg       *config.Config
+	chat      config.ResolvedChat
+	uploader  storage.Uploader
+	converter converter.Converter
+	history   *history.Store
+	resume    *storage.ResumeStore
+}
+
+// New returns an Importer that filters, converts and uploads like the chat
+// with the given handle, or like the defaults when handle is "".
+func New(cfg *config.Config, handle string) (*Importer, error) {
+	chatCfg := config.ChatConfig{Handle: Source}
+	if handle != "" {
+		found := false
+		for _, c := range cfg.Chats {
+			if config.HandleKey(c.Handle) == config.HandleKey(handle) {
+				chatCfg, found = c, true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("chat %q is not in the config", handle)
+		}
+	}
+	chat := config.ResolvedChatConfig(cfg, chatCfg)
+
+	// The rate was validated by config.Load.
+	rate, _ := throttle.ParseRate(cfg.Processing.BandwidthLimit)
+	uploader, err := storage.NewUploader(chat.Storage, throttle.New(rate))
+	if err != nil {
+		return nil, fmt.Errorf("creating uploader: %w", err)
+	}
+
+	// The engine names were validated by config.Load.
+	conv, _ := converter.NewChain(cfg.Processing.Converters)
+
+	return &Importer{
+		cfg:       cfg,
+		chat:      chat,
+		uploader:  storage.Limit(uploader, chat.Storage.MaxConcurrentUploads),
+		converter: conv,
+		history:   history.Open(cfg.Paths.HistoryFile),
+		resume:    storage.NewResumeStore(cfg.Paths.UploadStateDir),
+	}, nil
+}
+
+// Scan returns the files under dir that the chat's accepted formats and
+// minimum size allow, sorted by path.
+func (im *Importer) Scan(dir string) ([]string, error) {
+	var files []string
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || strings.HasPrefix(d.Name(), ".") {
+			return nil
+		}
+		ext := strings.ToLower(filepath.Ext(d.Name()))
+		if !im.chat.AcceptAll && !im.chat.AcceptedFormats[ext] {
+			return nil
+		}
+		if im.chat.MinFileSize > 0 {
+			if info, err := d.Info(); err == nil && info.Size() < im.chat.MinFileSize {
+				return nil
+			}
+		}
+		files = append(files, p)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("scanning %q: %w", dir, err)
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// Run processes files, at most processing.workers at a time (one if unset),
+// skipping any whose name was already delivered. The source files are never
+// modified. It returns the number of files that failed.
+func (im *Importer) Run(ctx context.Context, files []string, report ReportFunc) (int, error) {
+	delivered, err := im.history.Delivered(Source)
+	if err != nil {
+		return 0, err
+	}
+	if err := os.MkdirAll(im.cfg.Paths.ConvertedDir, 0o750); err != nil {
+		return 0, fmt.Errorf("creating converted directory: %w", err)
+	}
+
+	workers := max(im.cfg.Processing.Workers, 1)
+	slots := workers
+	if n := im.cfg.Processing.MaxConversions; n > 0 && n < workers {
+		slots = n
+	}
+	conversions := make(chan struct{}, slots)
+	// Extensions with a conversion limit get their own slots.
+	typeSlots := make(map[string]chan struct{}, len(im.cfg.Processing.ConversionLimits))
+	for ext, n := range im.cfg.Processing.ConversionLimits {
+		typeSlots[config.NormalizeFormat(ext)] = make(chan struct{}, n)
+	}
+
+	var (
+		mu     sync.Mutex
+		done   int
+		failed int
+		wg     sync.WaitGroup
+		jobs   = make(chan string)
+	)
+	finish := func(r Result) {
+		mu.Lock()
+		defer mu.Unlock()
+		done++
+		if r.Status == history.Failed {
+			failed++
+		}
+		if r.Status != history.Skipped {
+			_ = im.history.Record(history.Entry{Chat: Source, FileName: r.File, Status: r.Status, Reason: r.Reason})
+		}
+		if report != nil {
+			report(done, len(files), r)
+		}
+	}
+
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for p := range jobs {
+				name := filepath.Base(p)
+				if delivered[name] {
+					finish(Result{File: name, Status: history.Skipped, Reason: "already delivered"})
+					continue
+				}
+				slots, ok := typeSlots[strings.ToLower(filepath.Ext(p))]
+				if !ok {
+					slots = conversions
+				}
+				if err := im.process(ctx, p, slots); err != nil {
+					// ebook-convert errors carry its stderr on later lines.
+					reason, _, _ := strings.Cut(err.Error(), "\n")
+					finish(Result{File: name, Status: history.Failed, Reason: reason})
+					continue
+				}
+				finish(Result{File: name, Status: history.Delivered})
+			}
+		}()
+	}
+
+	for _, p := range files {
+		select {
+		case jobs <- p:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	if ctx.Err() != nil {
+		return failed, ctx.Err()
+	}
+	return failed, nil
+}
+
+// process converts (or copies) one file into the converted directory, once
+// per output format, runs the post-process hook, and uploads the results.
+func (im *Importer) process(ctx context.Context, p string, conversions chan struct{}) error {
+	ext := strings.ToLower(filepath.Ext(p))
+	var conv converter.Converter = converter.Identity{}
+	stage := "copy"
+	formats := []string{ext}
+	if im.chat.Convert && !im.chat.NoConvertFormats[ext] {
+		conv, stage, formats = im.converter, "convert", im.chat.OutputFormats
+	}
+
+	var outs []string
+	keep := false
+	defer func() {
+		if !keep {
+			for _, out := range outs {
+				os.Remove(out)
+			}
+		}
+	}()
+	for _, format := range formats {
+		select {
+		case conversions <- struct{}{}:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		convertCtx := converter.WithTarget(ctx, format, converter.CalibreArgs(im.chat.InputEncoding, im.chat.Language, im.chat.ConvertOptions))
+		out, err := conv.Convert(converter.WithExtension(convertCtx, im.chat.ConvertedExtension), p, im.cfg.Paths.ConvertedDir)
+		<-conversions
+		if err != nil {
+			return fmt.Errorf("%s: %w", stage, err)
+		}
+		outs = append(outs, out)
+	}
+
+	for _, out := range outs {
+		if err := converter.PostProcess(ctx, im.cfg.Processing.PostProcess, out); err != nil {
+			return fmt.Errorf("post-process: %w", err)
+		}
+	}
+
+	for _, out := range outs {
+		remoteName := filepath.Base(out)
+		if d := im.chat.Storage.Dropbox; im.chat.Storage.Type == "dropbox" && d.DateFolders {
+			if info, err := os.Stat(p); err == nil {
+				remoteName = path.Join(info.ModTime().Format(d.DateFormat), remoteName)
+			}
+		}
+		if d := im.chat.Storage.Dropbox; im.chat.Storage.Type == "dropbox" && d.FolderPerChat {
+			remoteName = path.Join(config.ChatFolder(im.chat.Handle), remoteName)
+		}
+		if storage.SkipExisting(ctx, im.uploader, im.chat.Storage.Dropbox.IfExists, out, remoteName) {
+			continue // already there, which counts as imported
+		}
+		if err := im.resume.Upload(ctx, im.uploader, out, remoteName); err != nil {
+			return fmt.Errorf("upload: %w", err)
+		}
+	}
+	keep = im.cfg.Processing.KeepConverted
+	return nil
+}
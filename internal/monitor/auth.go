@@ -3,6 +3,7 @@ package monitor
 import (
 	"bufio"
 	"context"
+	"errors"
 	"fmt"
 	"os"
 	"strings"
@@ -11,6 +12,22 @@ import (
 	"github.com/gotd/td/tg"
 )
 
+// LoginRequiredMessage is the text of ErrLoginRequired. The server exits
+// with it in its output, which is how the CLI tells from a container's logs
+// that it needs a login.
+const LoginRequiredMessage = "telegram login required"
+
+// ErrLoginRequired is returned by Run when there is no authorized session
+// and no terminal to log in from, e.g. in a detached container.
+var ErrLoginRequired = errors.New(LoginRequiredMessage)
+
+// hasTerminal reports whether stdin is an interactive terminal that
+// terminalAuth can prompt on.
+func hasTerminal() bool {
+	info, err := os.Stdin.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
 // terminalAuth implements auth.UserAuthenticator for interactive terminal login.
 type terminalAuth struct{}
 
@@ -50,3 +67,28 @@ func (a terminalAuth) AcceptTermsOfService(_ context.Context, _ tg.HelpTermsOfSe
 func (a terminalAuth) SignUp(_ context.Context) (auth.UserInfo, error) {
 	return auth.UserInfo{}, fmt.Errorf("sign-up not supported")
 }
+
+// passwordAttempts is how many times the 2FA password is prompted for before
+// the login gives up.
+const passwordAttempts = 3
+
+// retryPassword wraps an auth.FlowClient so a mistyped 2FA password prompts
+// again instead of failing the whole login. The flow only asks for the
+// password once, so the retries happen here, after the code was accepted.
+type retryPassword struct {
+	auth.FlowClient
+	prompt auth.UserAuthenticator
+}
+
+func (c retryPassword) Password(ctx context.Context, password string) (*tg.AuthAuthorization, error) {
+	for attempt := 1; ; attempt++ {
+		a, err := c.FlowClient.Password(ctx, password)
+		if !errors.Is(err, auth.ErrPasswordInvalid) || attempt == passwordAttempts {
+			return a, err
+		}
+		fmt.Printf("Wrong password, try again (%d attempts left).\n", passwordAttempts-attempt)
+		if password, err = c.prompt.Password(ctx); err != nil {
+			return nil, err
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package monitor
+
+import (
+	"context"
+	"log/slog"
+	"slices"
+	"time"
+
+	"github.com/gotd/td/tg"
+
+	"github.com/spacesedan/kpub/internal/config"
+)
+
+// backfillPageSize is the most messages Telegram returns per history request.
+const backfillPageSize = 100
+
+// defaultBackfillScanLimit is how many messages backfill looks through when
+// backfill_scan_limit isn't set (or backfill itself, if that is larger).
+const defaultBackfillScanLimit = 1000
+
+// backfill runs up to chat.Backfill recent files, optionally only those newer
+// than chat.BackfillSince, through the normal pipeline, oldest first. At most
+// chat.BackfillScanLimit messages are looked through to find them. Files
+// already delivered from this chat are skipped so a restart doesn't upload
+// them again.
+func (m *Monitor) backfill(ctx context.Context, chat config.ResolvedChat) {
+	logger := m.logger.With(slog.String("chat", chat.Handle))
+
+	var since time.Time
+	if chat.BackfillSince != "" {
+		var err error
+		if since, err = config.ParseSince(chat.BackfillSince, time.Now()); err != nil {
+			logger.Error("Invalid backfill_since, skipping backfill", slog.Any("reason", err))
+			return
+		}
+	}
+
+	peer, err := m.resolveInputPeer(ctx, chat.Handle)
+	if err != nil {
+		logger.Error("Could not resolve chat for backfill", slog.Any("reason", err))
+		return
+	}
+
+	delivered, err := m.opts.History.Delivered(chat.Handle)
+	if err != nil {
+		logger.Warn("Could not read history, backfill may repeat deliveries", slog.Any("reason", err))
+	}
+
+	scanLimit := chat.BackfillScanLimit
+	if scanLimit == 0 {
+		scanLimit = max(defaultBackfillScanLimit, chat.Backfill)
+	}
+	msgs, err := m.fetchHistory(ctx, peer, chat.Backfill, scanLimit, since, hasDocument)
+	if err != nil {
+		logger.Error("Backfill failed", slog.Any("reason", err))
+		return
+	}
+	logger.Info("Backfilling chat", slog.Int("files", len(msgs)))
+
+	m.mu.RLock()
+	var target *monitoredChat
+	for _, c := range m.peers {
+		if config.HandleKey(c.handle) == config.HandleKey(chat.Handle) {
+			target = c
+		}
+	}
+	m.mu.RUnlock()
+	if target == nil {
+		return
+	}
+
+	for _, msg := range slices.Backward(msgs) {
+		if media, ok := msg.Media.(*tg.MessageMediaDocument); ok {
+			if doc, ok := media.Document.AsNotEmpty(); ok && delivered[historyName(doc)] {
+				logger.Debug("Already delivered, skipping", slog.String("fileName", historyName(doc)))
+				continue
+			} else if ok && m.opts.Jobs.pending(doc.ID) {
+				logger.Debug("Already being processed, skipping", slog.String("fileName", historyName(doc)))
+				continue
+			}
+		}
+		if err := m.processDocument(ctx, msg, target); err != nil {
+			logger.Warn("Backfill failed for message", slog.Int("id", msg.ID), slog.Any("reason", err))
+		}
+	}
+}
+
+// hasDocument reports whether msg carries a document, i.e. a file that
+// backfill could process.
+func hasDocument(msg *tg.Message) bool {
+	_, ok := msg.Media.(*tg.MessageMediaDocument)
+	return ok
+}
+
+// fetchHistory returns up to limit messages from peer that keep accepts, or
+// any messages if keep is nil, newest first. It stops after looking through
+// scanLimit messages, or at the first message older than since (if set).
+func (m *Monitor) fetchHistory(ctx context.Context, peer tg.InputPeerClass, limit, scanLimit int, since time.Time, keep func(*tg.Message) bool) ([]*tg.Message, error) {
+	var out []*tg.Message
+	offsetID := 0
+	scanned := 0
+	for len(out) < limit && scanned < scanLimit {
+		res, err := m.api.MessagesGetHistory(ctx, &tg.MessagesGetHistoryRequest{
+			Peer:     peer,
+			OffsetID: offsetID,
+			Limit:    min(backfillPageSize, scanLimit-scanned),
+		})
+		if err != nil {
+			return out, err
+		}
+		modified, ok := res.AsModified()
+		if !ok {
+			return out, nil
+		}
+		page := modified.GetMessages()
+		if len(page) == 0 {
+			return out, nil
+		}
+
+		for _, mc := range page {
+			scanned++
+			msg, ok := mc.(*tg.Message)
+			if !ok {
+				continue
+			}
+			if !since.IsZero() && time.Unix(int64(msg.Date), 0).Before(since) {
+				return out, nil
+			}
+			if keep != nil && !keep(msg) {
+				continue
+			}
+			out = append(out, msg)
+			if len(out) == limit {
+				return out, nil
+			}
+		}
+		offsetID = page[len(page)-1].GetID()
+	}
+	return out, nil
+}
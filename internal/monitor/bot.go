@@ -0,0 +1,78 @@
+package monitor
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// botAPIURL is the Telegram Bot API endpoint; the token and method are
+// appended.
+const botAPIURL = "https://api.telegram.org/bot"
+
+// botNotifier sends notifications from a bot through the Bot API, while the
+// user client keeps doing the monitoring. It speaks plain HTTPS, so the bot
+// needs no MTProto session of its own.
+type botNotifier struct {
+	token  string
+	chatID int64 // 0 until Run learns the user's own ID
+	client *http.Client
+}
+
+func newBotNotifier(token string, chatID int64) *botNotifier {
+	return &botNotifier{token: token, chatID: chatID, client: &http.Client{Timeout: 30 * time.Second}}
+}
+
+// send posts text and returns the new message's ID.
+func (b *botNotifier) send(ctx context.Context, text string) (int, error) {
+	var msg struct {
+		MessageID int `json:"message_id"`
+	}
+	err := b.call(ctx, "sendMessage", map[string]any{"chat_id": b.chatID, "text": text}, &msg)
+	return msg.MessageID, err
+}
+
+// edit replaces the text of a message sent by send.
+func (b *botNotifier) edit(ctx context.Context, id int, text string) error {
+	return b.call(ctx, "editMessageText", map[string]any{"chat_id": b.chatID, "message_id": id, "text": text}, nil)
+}
+
+// call invokes a Bot API method and decodes its result into out, if non-nil.
+func (b *botNotifier) call(ctx context.Context, method string, params map[string]any, out any) error {
+	body, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, botAPIURL+b.token+"/"+method, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := b.client.Do(req)
+	if err != nil {
+		// The URL holds the token; don't let it reach the logs.
+		return fmt.Errorf("bot API %s: request failed", method)
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		OK          bool            `json:"ok"`
+		Description string          `json:"description"`
+		Result      json.RawMessage `json:"result"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return fmt.Errorf("bot API %s: decoding response: %w", method, err)
+	}
+	if !result.OK {
+		return fmt.Errorf("bot API %s: %s (chat %s)", method, result.Description, strconv.FormatInt(b.chatID, 10))
+	}
+	if out != nil {
+		return json.Unmarshal(result.Result, out)
+	}
+	return nil
+}
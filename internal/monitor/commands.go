@@ -0,0 +1,103 @@
+package monitor
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+
+	"github.com/gotd/td/tg"
+)
+
+// ChatEditor persists chat additions and removals requested through admin
+// commands. The supervisor implements it by rewriting the config file.
+type ChatEditor interface {
+	AddHandle(handle string) error
+	RemoveHandle(handle string) error
+}
+
+// EnableAdminCommands turns on the Saved Messages command interface. Must be
+// called before Run.
+func (m *Monitor) EnableAdminCommands(editor ChatEditor) {
+	m.editor = editor
+}
+
+// isAdminCommand reports whether msg is a command the user sent to their own
+// Saved Messages.
+func (m *Monitor) isAdminCommand(msg *tg.Message) bool {
+	if m.editor == nil || !msg.Out {
+		return false
+	}
+	p, ok := msg.PeerID.(*tg.PeerUser)
+	if !ok || p.UserID != m.selfID {
+		return false
+	}
+	return strings.HasPrefix(msg.Message, "/")
+}
+
+// handleCommand runs a single admin command and replies in Saved Messages.
+func (m *Monitor) handleCommand(ctx context.Context, text string) {
+	fields := strings.Fields(text)
+	cmd := strings.ToLower(fields[0])
+	args := fields[1:]
+
+	m.logger.Info("Admin command received", slog.String("command", cmd))
+
+	switch cmd {
+	case "/status":
+		state := "running"
+		if m.paused.Load() {
+			state = "paused"
+		}
+		m.mu.RLock()
+		chats := len(m.peers)
+		m.mu.RUnlock()
+		m.notify(ctx, fmt.Sprintf("[kpub] Status: %s, %d chat(s) monitored, %d file(s) in progress.",
+			state, chats, m.inFlight.Load()))
+
+	case "/pause":
+		m.paused.Store(true)
+		m.notify(ctx, "[kpub] Paused. New files will be ignored until /resume.")
+
+	case "/resume":
+		m.paused.Store(false)
+		m.notify(ctx, "[kpub] Resumed.")
+
+	case "/list":
+		m.mu.RLock()
+		handles := make([]string, 0, len(m.peers))
+		for _, chat := range m.peers {
+			handles = append(handles, chat.handle)
+		}
+		m.mu.RUnlock()
+		sort.Strings(handles)
+		if len(handles) == 0 {
+			m.notify(ctx, "[kpub] No chats monitored.")
+			return
+		}
+		m.notify(ctx, "[kpub] Monitored chats:\n"+strings.Join(handles, "\n"))
+
+	case "/add", "/remove":
+		if len(args) != 1 {
+			m.notify(ctx, fmt.Sprintf("[kpub] Usage: %s @handle", cmd))
+			return
+		}
+		handle := args[0]
+		var err error
+		if cmd == "/add" {
+			err = m.editor.AddHandle(handle)
+		} else {
+			err = m.editor.RemoveHandle(handle)
+		}
+		if err != nil {
+			m.logger.Error("Admin command failed", slog.String("command", cmd), slog.Any("reason", err))
+			m.notify(ctx, fmt.Sprintf("[kpub] %s %s failed: %s", cmd, handle, shortError(err)))
+			return
+		}
+		m.notify(ctx, fmt.Sprintf("[kpub] Config updated (%s %s), reloading.", cmd, handle))
+
+	default:
+		m.notify(ctx, "[kpub] Unknown command. Available: /status, /pause, /resume, /list, /add @handle, /remove @handle")
+	}
+}
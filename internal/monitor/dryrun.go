@@ -0,0 +1,119 @@
+package monitor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gotd/td/telegram"
+	"github.com/gotd/td/tg"
+
+	"github.com/spacesedan/kpub/internal/config"
+)
+
+// DryRunResult describes what the pipeline would do with one message.
+type DryRunResult struct {
+	MessageID int
+	FileName  string
+	MimeType  string
+	Accepted  bool
+	Reason    string // why the message would be skipped, if it would be
+	Convert   bool   // whether an accepted file would be converted
+}
+
+// DryRun connects, fetches a message from chat, and runs it through the same
+// filters as live messages without downloading anything. A messageID of 0
+// picks the most recent message with a document.
+func (m *Monitor) DryRun(ctx context.Context, chat config.ResolvedChat, messageID int) (DryRunResult, error) {
+	client := m.newClient(telegram.UpdateHandlerFunc(func(context.Context, tg.UpdatesClass) error {
+		return nil
+	}))
+
+	var result DryRunResult
+	err := client.Run(ctx, func(ctx context.Context) error {
+		if err := m.authorize(ctx, client); err != nil {
+			return err
+		}
+		m.api = tg.NewClient(client)
+
+		peer, err := m.resolveInputPeer(ctx, chat.Handle)
+		if err != nil {
+			return err
+		}
+		msg, err := m.fetchMessage(ctx, peer, messageID)
+		if err != nil {
+			return err
+		}
+
+		mc := &monitoredChat{
+			handle:     chat.Handle,
+			formats:    chat.AcceptedFormats,
+			acceptAll:  chat.AcceptAll,
+			mimeTypes:  chat.AcceptedMimeTypes,
+			requireAll: chat.RequireAll,
+			convert:    chat.Convert,
+			noConvert:  chat.NoConvertFormats,
+		}
+		r := m.screen(msg, mc)
+
+		result = DryRunResult{
+			MessageID: msg.ID,
+			FileName:  r.fileName,
+			Accepted:  r.skip == "",
+			Reason:    r.skip,
+		}
+		if r.doc != nil {
+			result.MimeType = r.doc.MimeType
+		}
+		if result.Accepted {
+			result.Convert = mc.convert && !mc.noConvert[strings.ToLower(filepath.Ext(r.fileName))]
+		}
+		return nil
+	})
+	return result, err
+}
+
+// fetchMessage returns message id from peer, or the newest message with a
+// document among the last page of history when id is 0.
+func (m *Monitor) fetchMessage(ctx context.Context, peer tg.InputPeerClass, id int) (*tg.Message, error) {
+	if id == 0 {
+		msgs, err := m.fetchHistory(ctx, peer, 1, backfillPageSize, time.Time{}, hasDocument)
+		if err != nil {
+			return nil, fmt.Errorf("fetching history: %w", err)
+		}
+		if len(msgs) > 0 {
+			return msgs[0], nil
+		}
+		return nil, fmt.Errorf("no documents in the last %d messages", backfillPageSize)
+	}
+
+	ids := []tg.InputMessageClass{&tg.InputMessageID{ID: id}}
+	var res tg.MessagesMessagesClass
+	var err error
+	if ch, ok := peer.(*tg.InputPeerChannel); ok {
+		res, err = m.api.ChannelsGetMessages(ctx, &tg.ChannelsGetMessagesRequest{
+			Channel: &tg.InputChannel{ChannelID: ch.ChannelID, AccessHash: ch.AccessHash},
+			ID:      ids,
+		})
+	} else {
+		res, err = m.api.MessagesGetMessages(ctx, ids)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("fetching message %d: %w", id, err)
+	}
+	if modified, ok := res.AsModified(); ok {
+		for _, mc := range modified.GetMessages() {
+			if msg, ok := mc.(*tg.Message); ok {
+				return msg, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("message %d %w", id, errMessageNotFound)
+}
+
+// errMessageNotFound is returned by fetchMessage for a message that doesn't
+// exist, e.g. because it was deleted.
+var errMessageNotFound = errors.New("not found")
@@ -0,0 +1,187 @@
+package monitor
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/spacesedan/kpub/internal/config"
+	"github.com/spacesedan/kpub/internal/storage"
+)
+
+// pendingJob is a file the monitor accepted but hasn't finished with.
+type pendingJob struct {
+	Chat       string    `json:"chat"`
+	MessageID  int       `json:"message_id"`
+	DocumentID int64     `json:"document_id"`
+	FileName   string    `json:"file_name"`
+	Stage      string    `json:"stage"` // "queued", "downloading", "converting" or "uploading"
+	Saved      time.Time `json:"saved"`
+}
+
+// JobStore keeps a record of every file the monitor has accepted but not
+// finished, one small file per document, so files interrupted by a crash or
+// restart are queued again when their chat is next added. A resumed upload
+// then continues from its UploadState token. A nil *JobStore records
+// nothing.
+type JobStore struct {
+	dir string
+}
+
+// NewJobStore returns a store that keeps its records in dir, creating it on
+// first use.
+func NewJobStore(dir string) *JobStore {
+	return &JobStore{dir: dir}
+}
+
+// save records j, replacing any earlier record of the same document.
+// Failing to save only costs the ability to resume, so it is logged rather
+// than returned.
+func (s *JobStore) save(j pendingJob) {
+	if s == nil {
+		return
+	}
+	j.Saved = time.Now()
+	data, err := json.Marshal(j)
+	if err == nil {
+		err = os.MkdirAll(s.dir, 0o750)
+	}
+	if err == nil {
+		err = storage.WriteFileAtomic(s.path(j.DocumentID), 0o600, func(w io.Writer) error {
+			_, err := w.Write(data)
+			return err
+		})
+	}
+	if err != nil {
+		slog.Warn("Failed to save pending file state", "fileName", j.FileName, "error", err)
+	}
+}
+
+// setStage updates the stage of docID's record, if there is one.
+func (s *JobStore) setStage(docID int64, stage string) {
+	if s == nil {
+		return
+	}
+	j, err := s.load(s.path(docID))
+	if err != nil {
+		return
+	}
+	j.Stage = stage
+	s.save(j)
+}
+
+// remove deletes docID's record once the file is done with, whatever the
+// outcome.
+func (s *JobStore) remove(docID int64) {
+	if s == nil {
+		return
+	}
+	if err := os.Remove(s.path(docID)); err != nil && !os.IsNotExist(err) {
+		slog.Warn("Failed to remove pending file state", "error", err)
+	}
+}
+
+// pending reports whether docID has a record.
+func (s *JobStore) pending(docID int64) bool {
+	if s == nil {
+		return false
+	}
+	_, err := os.Stat(s.path(docID))
+	return err == nil
+}
+
+// forChat returns the records of the chat with handle, oldest first.
+func (s *JobStore) forChat(handle string) ([]pendingJob, error) {
+	if s == nil {
+		return nil, nil
+	}
+	entries, err := os.ReadDir(s.dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading pending file state: %w", err)
+	}
+	var jobs []pendingJob
+	for _, e := range entries {
+		if e.IsDir() || filepath.Ext(e.Name()) != ".json" {
+			continue
+		}
+		j, err := s.load(filepath.Join(s.dir, e.Name()))
+		if err != nil {
+			slog.Warn("Skipping unreadable pending file state", "file", e.Name(), "error", err)
+			continue
+		}
+		if config.HandleKey(j.Chat) == config.HandleKey(handle) {
+			jobs = append(jobs, j)
+		}
+	}
+	slices.SortStableFunc(jobs, func(a, b pendingJob) int { return a.Saved.Compare(b.Saved) })
+	return jobs, nil
+}
+
+func (s *JobStore) load(p string) (pendingJob, error) {
+	var j pendingJob
+	data, err := os.ReadFile(p)
+	if err != nil {
+		return j, err
+	}
+	err = json.Unmarshal(data, &j)
+	return j, err
+}
+
+func (s *JobStore) path(docID int64) string {
+	return filepath.Join(s.dir, strconv.FormatInt(docID, 10)+".json")
+}
+
+// resumeJobs queues again the files of chat that a crash or restart
+// interrupted, fetching their messages afresh. A file whose message is gone
+// is dropped; one that can't be fetched for another reason is kept for the
+// next start.
+func (m *Monitor) resumeJobs(ctx context.Context, chat config.ResolvedChat, target *monitoredChat) {
+	logger := m.logger.With(slog.String("chat", chat.Handle))
+	jobs, err := m.opts.Jobs.forChat(chat.Handle)
+	if err != nil {
+		logger.Warn("Could not read interrupted files", slog.Any("reason", err))
+		return
+	}
+	if len(jobs) == 0 {
+		return
+	}
+
+	peer, err := m.resolveInputPeer(ctx, chat.Handle)
+	if err != nil {
+		logger.Error("Could not resolve chat to resume interrupted files", slog.Any("reason", err))
+		return
+	}
+	for _, j := range jobs {
+		msg, err := m.fetchMessage(ctx, peer, j.MessageID)
+		if errors.Is(err, errMessageNotFound) {
+			logger.Warn("Message of an interrupted file is gone, dropping it", slog.String("fileName", j.FileName))
+			m.opts.Jobs.remove(j.DocumentID)
+			continue
+		}
+		if err != nil {
+			logger.Warn("Could not fetch an interrupted file, will try again on the next start",
+				slog.String("fileName", j.FileName), slog.Any("reason", err))
+			continue
+		}
+
+		logger.Info("Resuming file interrupted by a restart",
+			slog.String("fileName", j.FileName), slog.String("stage", j.Stage))
+		// processDocument records the file again if it still passes the
+		// chat's filters.
+		m.opts.Jobs.remove(j.DocumentID)
+		if err := m.processDocument(ctx, msg, target); err != nil {
+			logger.Warn("Could not resume interrupted file", slog.String("fileName", j.FileName), slog.Any("reason", err))
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package monitor
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestJobStore(t *testing.T) {
+	s := NewJobStore(filepath.Join(t.TempDir(), "jobs"))
+	s.save(pendingJob{Chat: "@Books", MessageID: 1, DocumentID: 10, FileName: "a.epub", Stage: "queued"})
+	s.save(pendingJob{Chat: "@other", MessageID: 2, DocumentID: 20, FileName: "b.epub", Stage: "queued"})
+	s.save(pendingJob{Chat: "@books", MessageID: 3, DocumentID: 30, FileName: "c.epub", Stage: "queued"})
+	s.setStage(30, "uploading")
+	s.setStage(99, "uploading") // no record; must not create one
+
+	jobs, err := s.forChat("@BOOKS")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(jobs) != 2 || jobs[0].DocumentID != 10 || jobs[1].DocumentID != 30 {
+		t.Fatalf("forChat = %+v, want documents 10 and 30 in the order saved", jobs)
+	}
+	if jobs[1].Stage != "uploading" || jobs[1].MessageID != 3 || jobs[1].FileName != "c.epub" {
+		t.Errorf("record = %+v, want message 3, c.epub, uploading", jobs[1])
+	}
+	if s.pending(99) {
+		t.Error("setStage created a record for an unknown document")
+	}
+
+	s.remove(10)
+	if s.pending(10) || !s.pending(30) {
+		t.Errorf("after remove(10): pending(10) = %v, pending(30) = %v", s.pending(10), s.pending(30))
+	}
+	s.remove(10) // already gone
+
+	// A nil store records nothing.
+	var none *JobStore
+	none.save(pendingJob{DocumentID: 1})
+	if none.pending(1) {
+		t.Error("nil store has a pending job")
+	}
+	if jobs, err := none.forChat("@books"); jobs != nil || err != nil {
+		t.Errorf("nil store forChat = %v, %v", jobs, err)
+	}
+}
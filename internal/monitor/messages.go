@@ -0,0 +1,60 @@
+package monitor
+
+import (
+	"bytes"
+	"fmt"
+	"text/template"
+
+	"github.com/spacesedan/kpub/internal/config"
+)
+
+// Default notification templates, matching kpub's built-in messages.
+const (
+	defaultProcessingMessage = `[kpub] Processing '{{.filename}}' from {{.chat}}...`
+	defaultSuccessMessage    = `[kpub] Done! '{{.filename}}' {{if .converted}}is ready on your Kobo.{{if .fallback}} (converted by {{.engine}} after another converter failed){{end}}{{else}}was uploaded without conversion.{{end}}`
+	defaultFailureMessage    = `[kpub] {{if eq .stage "post-process"}}Post-process hook failed for{{else}}Failed to {{.stage}}{{end}} '{{.filename}}': {{.error}}`
+)
+
+// messages holds the parsed per-file notification templates.
+type messages struct {
+	processing *template.Template
+	success    *template.Template
+	failure    *template.Template
+}
+
+// parseMessages parses cfg's templates, using the defaults for any left
+// empty.
+func parseMessages(cfg config.MessagesConfig) (messages, error) {
+	var msgs messages
+	for _, t := range []struct {
+		name, text, def string
+		dst             **template.Template
+	}{
+		{"processing", cfg.Processing, defaultProcessingMessage, &msgs.processing},
+		{"success", cfg.Success, defaultSuccessMessage, &msgs.success},
+		{"failure", cfg.Failure, defaultFailureMessage, &msgs.failure},
+	} {
+		text := t.text
+		if text == "" {
+			text = t.def
+		}
+		tmpl, err := template.New(t.name).Parse(text)
+		if err != nil {
+			return messages{}, fmt.Errorf("messages.%s: %w", t.name, err)
+		}
+		*t.dst = tmpl
+	}
+	return msgs, nil
+}
+
+// render executes a notification template. Templates are checked when the
+// config loads, but a bad field reference only shows up here, so fall back
+// to the plain file name rather than sending nothing.
+func (m *Monitor) render(tmpl *template.Template, data map[string]any) string {
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		m.logger.Warn("Could not render notification template", "template", tmpl.Name(), "reason", err)
+		return fmt.Sprintf("[kpub] %s: %v", tmpl.Name(), data["filename"])
+	}
+	return buf.String()
+}
@@ -1,30 +1,201 @@
 package monitor
 
 import (
+	"cmp"
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"log/slog"
 	"os"
+	"path"
 	"path/filepath"
+	"slices"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
+	"unicode"
 
 	"github.com/gotd/td/session"
 	"github.com/gotd/td/telegram"
 	"github.com/gotd/td/telegram/auth"
+	"github.com/gotd/td/telegram/dcs"
 	"github.com/gotd/td/telegram/downloader"
 	"github.com/gotd/td/tg"
 
+	"github.com/spacesedan/kpub/internal/config"
 	"github.com/spacesedan/kpub/internal/converter"
+	"github.com/spacesedan/kpub/internal/history"
 	"github.com/spacesedan/kpub/internal/storage"
+	"github.com/spacesedan/kpub/internal/throttle"
 )
 
 // monitoredChat holds config for a single monitored chat.
 type monitoredChat struct {
-	handle   string
-	formats  map[string]bool
-	uploader storage.Uploader
+	handle      string
+	formats     map[string]bool
+	acceptAll   bool
+	mimeTypes   map[string]bool
+	requireAll  bool
+	minSize     int64           // bytes; smaller documents are skipped
+	convert     bool            // false uploads the original file as-is
+	noConvert   map[string]bool // extensions uploaded as-is even when convert is on
+	outputs     []string        // extensions to convert to, one upload each
+	convertArgs []string        // extra ebook-convert arguments
+	kepubExt    string          // extension KEPUB output is named with
+	chatFolder  string          // upload subfolder named after the chat; "" means none
+	dateFolder  string          // time layout for an upload subfolder; "" means none
+	destination string          // upload folder or address, for notifications
+	ifExists    string          // config.IfExists policy for files already uploaded
+	prefer      []string        // format preference among duplicates; empty disables grouping
+	uploader    storage.Uploader
+	errorPeer   tg.InputPeerClass // failure notifications; nil means Saved Messages
+	notifyAll   bool              // deliveries go to errorPeer too
+	resolvedAt  time.Time         // when handle was last resolved; guarded by Monitor.mu
+}
+
+// notifyPeer returns where a notification of sev about c goes instead of
+// Saved Messages, or nil.
+func (c *monitoredChat) notifyPeer(sev severity) tg.InputPeerClass {
+	if c == nil || (sev != severityError && !c.notifyAll) {
+		return nil
+	}
+	return c.errorPeer
+}
+
+// subfolder returns the folder under the upload path that a file received
+// at received goes into, or "" for the upload path itself.
+func (c *monitoredChat) subfolder(received time.Time) string {
+	folder := c.chatFolder
+	if c.dateFolder != "" {
+		folder = path.Join(folder, received.Format(c.dateFolder))
+	}
+	return folder
+}
+
+// accepts reports whether a document passes the chat's extension and MIME
+// type filters.
+func (c *monitoredChat) accepts(ext, mimeType string) bool {
+	extOK := c.acceptAll || c.formats[ext]
+	if len(c.mimeTypes) == 0 {
+		return extOK
+	}
+	mimeOK := c.mimeTypes[config.NormalizeMimeType(mimeType)]
+	if c.requireAll {
+		return extOK && mimeOK
+	}
+	return extOK || mimeOK
+}
+
+// Options holds optional monitor behaviour. The zero value is valid.
+type Options struct {
+	// Debounce delays processing a document so rapid duplicate updates for
+	// the same document ID collapse into a single run.
+	Debounce time.Duration
+
+	// SessionPassphrase, if set, encrypts the session file with AES-GCM.
+	SessionPassphrase string
+
+	// FileTimeout caps the total time spent downloading, converting, and
+	// uploading a single file. Zero means no limit.
+	FileTimeout time.Duration
+
+	// Limiter throttles downloads. Nil means unlimited.
+	Limiter *throttle.Limiter
+
+	// PostProcess is a hook command run on each converted file before
+	// upload; see converter.PostProcess.
+	PostProcess []string
+
+	// Converter converts downloaded files. Nil means converter.Calibre.
+	Converter converter.Converter
+
+	// TestDC connects to Telegram's test datacenters; DC picks the initial
+	// datacenter. Both are for development only.
+	TestDC bool
+	DC     int
+
+	// Device overrides the device info sent to Telegram. Version is kpub's
+	// version, which the defaults are built from.
+	Device  config.DeviceConfig
+	Version string
+
+	// Workers caps how many files are processed at once. Zero means no
+	// limit: every file gets its own goroutine and nothing is queued.
+	Workers int
+	// MaxQueue caps how many files may wait for a worker; QueuePolicy
+	// (QueueBlock, QueueReject, QueueDropOldest) decides what happens when
+	// it's reached. Zero means unbounded.
+	MaxQueue    int
+	QueuePolicy string
+
+	// MaxDownloads and MaxConversions cap the download and conversion
+	// stages separately, so a small box can fetch several files while
+	// converting one at a time. Zero means no limit.
+	MaxDownloads   int
+	MaxConversions int
+
+	// ConversionLimits gives input extensions, like ".pdf", their own
+	// conversion slots instead of sharing MaxConversions, so a few heavy
+	// files can't hold up quick ones.
+	ConversionLimits map[string]int
+
+	// History records delivered, failed, and skipped files. Nil disables it.
+	History *history.Store
+
+	// UploadState keeps resume tokens for backends that can continue an
+	// interrupted upload. Nil always uploads from the start.
+	UploadState *storage.ResumeStore
+
+	// Jobs records files that are being processed, so those a crash or
+	// restart interrupts are queued again when their chat is added. Nil
+	// forgets them.
+	Jobs *JobStore
+
+	// KeepConverted leaves delivered files in the converted directory rather
+	// than deleting them after upload.
+	KeepConverted bool
+
+	// KeepOnFailure moves the download and any converted files of a file
+	// that failed into the converted directory's failed/ subdirectory,
+	// rather than deleting them; see keepFailed.
+	KeepOnFailure bool
+
+	// UniqueNames gives a file a different name when another file was
+	// already delivered under its name to the same destination; see
+	// nameGuard. It needs History.
+	UniqueNames bool
+
+	// PreferWindow is how long a chat with prefer_formats waits for other
+	// formats of the same title. Zero means 30 seconds.
+	PreferWindow time.Duration
+
+	// MaxMessageAge skips live updates for messages older than this; see
+	// processUpdate. Zero disables it.
+	MaxMessageAge time.Duration
+
+	// UpdateWatchdog resyncs with Telegram if no update of any kind arrives
+	// for this long; see watchdog. Zero disables it.
+	UpdateWatchdog time.Duration
+
+	// KeepAlive pings Telegram this often; see keepAlive. Zero disables it.
+	KeepAlive time.Duration
+
+	// UpdateBuffer is how many new messages may wait to be screened off the
+	// update dispatcher; see updateQueue. Zero handles them on the
+	// dispatcher.
+	UpdateBuffer int
+
+	// NotifyBotToken sends notifications from this bot instead of to Saved
+	// Messages, to NotifyChatID or, if that's zero, to the user's own
+	// account. Failure notifications for a chat with error_notify_to still
+	// come from the user.
+	NotifyBotToken string
+	NotifyChatID   int64
+
+	// Messages overrides the processing, success, and failure notifications.
+	Messages config.MessagesConfig
 }
 
 // Monitor manages a single Telegram user client that monitors multiple chats
@@ -35,6 +206,7 @@ type Monitor struct {
 	sessionPath  string
 	downloadDir  string
 	convertedDir string
+	opts         Options
 
 	mu    sync.RWMutex
 	peers map[string]*monitoredChat // "u123" or "c456" → chat config
@@ -44,20 +216,77 @@ type Monitor struct {
 	ready      chan struct{}
 	wg         sync.WaitGroup
 	logger     *slog.Logger
+
+	pendingMu sync.Mutex
+	pending   map[int64]*time.Timer // document ID → debounce timer
+
+	groupsMu sync.Mutex
+	groups   map[string]*formatGroup // chat + title → files waiting on prefer_formats
+
+	queue   *fileQueue   // nil when Options.Workers is zero
+	updates *updateQueue // nil when Options.UpdateBuffer is zero
+
+	downloadSlots   semaphore            // nil when Options.MaxDownloads is zero
+	conversionSlots semaphore            // nil when Options.MaxConversions is zero
+	typeSlots       map[string]semaphore // by lowercased extension, from Options.ConversionLimits
+	names           *nameGuard           // nil unless Options.UniqueNames
+
+	msgs messages     // notification templates
+	bot  *botNotifier // nil sends notifications to Saved Messages
+
+	// Admin commands (nil editor means disabled).
+	editor ChatEditor
+
+	selfID   int64
+	paused   atomic.Bool
+	inFlight atomic.Int64
+
+	lastUpdate atomic.Int64 // unix nanoseconds of the last update, for the watchdog
 }
 
 // New creates a Monitor from Telegram config and paths.
-func New(appID int, appHash, sessionPath, downloadDir, convertedDir string) *Monitor {
-	return &Monitor{
-		appID:        appID,
-		appHash:      appHash,
-		sessionPath:  sessionPath,
-		downloadDir:  downloadDir,
-		convertedDir: convertedDir,
-		peers:        make(map[string]*monitoredChat),
-		ready:        make(chan struct{}),
-		logger:       slog.Default().With("component", "monitor"),
+func New(appID int, appHash, sessionPath, downloadDir, convertedDir string, opts Options) *Monitor {
+	if opts.Converter == nil {
+		opts.Converter = converter.Calibre{}
+	}
+	m := &Monitor{
+		appID:           appID,
+		appHash:         appHash,
+		sessionPath:     sessionPath,
+		downloadDir:     downloadDir,
+		convertedDir:    convertedDir,
+		opts:            opts,
+		peers:           make(map[string]*monitoredChat),
+		pending:         make(map[int64]*time.Timer),
+		groups:          make(map[string]*formatGroup),
+		ready:           make(chan struct{}),
+		logger:          slog.Default().With("component", "monitor"),
+		downloadSlots:   newSemaphore(opts.MaxDownloads),
+		conversionSlots: newSemaphore(opts.MaxConversions),
+		typeSlots:       make(map[string]semaphore, len(opts.ConversionLimits)),
+	}
+	for ext, n := range opts.ConversionLimits {
+		m.typeSlots[config.NormalizeFormat(ext)] = newSemaphore(n)
+	}
+	msgs, err := parseMessages(opts.Messages)
+	if err != nil {
+		m.logger.Warn("Invalid notification template, using the defaults", "reason", err)
+		msgs, _ = parseMessages(config.MessagesConfig{})
+	}
+	m.msgs = msgs
+	if opts.NotifyBotToken != "" {
+		m.bot = newBotNotifier(opts.NotifyBotToken, opts.NotifyChatID)
 	}
+	if opts.Workers > 0 {
+		m.startWorkers(opts.Workers)
+	}
+	if opts.UpdateBuffer > 0 {
+		m.updates = newUpdateQueue(opts.UpdateBuffer)
+	}
+	if opts.UniqueNames && opts.History != nil {
+		m.names = newNameGuard(opts.History)
+	}
+	return m
 }
 
 // Ready returns a channel that is closed when the monitor is connected and
@@ -70,79 +299,308 @@ func (m *Monitor) Ready() <-chan struct{} {
 // for messages until ctx is cancelled.
 func (m *Monitor) Run(ctx context.Context) error {
 	dispatcher := tg.NewUpdateDispatcher()
-
-	client := telegram.NewClient(m.appID, m.appHash, telegram.Options{
-		UpdateHandler:  dispatcher,
-		SessionStorage: &session.FileStorage{Path: m.sessionPath},
-	})
+	client := m.newClient(m.trackUpdates(dispatcher))
 
 	return client.Run(ctx, func(ctx context.Context) error {
-		status, err := client.Auth().Status(ctx)
-		if err != nil {
-			return fmt.Errorf("getting auth status: %w", err)
-		}
-
-		if !status.Authorized {
-			m.logger.Info("Not authorized, starting user authentication...")
-			flow := auth.NewFlow(terminalAuth{}, auth.SendCodeOptions{})
-			if err := flow.Run(ctx, client.Auth()); err != nil {
-				return fmt.Errorf("user auth failed: %w", err)
-			}
-			m.logger.Info("Authentication successful")
+		if err := m.authorize(ctx, client); err != nil {
+			return err
 		}
 
 		m.api = tg.NewClient(client)
 		m.downloader = downloader.NewDownloader()
 
+		if m.editor != nil || (m.bot != nil && m.bot.chatID == 0) {
+			self, err := client.Self(ctx)
+			if err != nil {
+				return fmt.Errorf("getting current user: %w", err)
+			}
+			m.selfID = self.ID
+			if m.bot != nil && m.bot.chatID == 0 {
+				m.bot.chatID = self.ID
+			}
+		}
+		if m.editor != nil {
+			m.logger.Info("Admin commands enabled in Saved Messages")
+		}
+		if m.bot != nil {
+			m.logger.Info("Sending notifications through a bot", "chatID", m.bot.chatID)
+		}
+
 		m.logger.Info("Connected and ready to monitor chats")
 		close(m.ready)
 
+		// A watchdog failure stops the monitor the same way shutdown does.
+		ctx, stop := context.WithCancel(ctx)
+		defer stop()
+
+		if m.updates != nil {
+			go m.updates.run(ctx)
+		}
 		dispatcher.OnNewMessage(m.handleMessage)
 		dispatcher.OnNewChannelMessage(m.handleChannelMessage)
 
-		<-ctx.Done()
+		watchdogErr := make(chan error, 1)
+		if m.opts.UpdateWatchdog > 0 {
+			go func() { watchdogErr <- m.watchdog(ctx) }()
+		}
+		if m.opts.KeepAlive > 0 {
+			go m.keepAlive(ctx)
+		}
+		var err error
+		select {
+		case <-ctx.Done():
+		case err = <-watchdogErr:
+		}
+		stop()
+
+		// Files in flight hold their own uncancelled contexts, so they
+		// finish before the client is torn down.
 		m.logger.Info("Shutting down, waiting for in-flight files to complete...")
+		if m.updates != nil {
+			<-m.updates.done
+		}
 		m.wg.Wait()
+		if err := m.opts.History.Close(); err != nil {
+			m.logger.Error("Failed to flush history", slog.Any("reason", err))
+		}
 		m.logger.Info("All in-flight files completed, monitor stopped")
-		return nil
+		return err
 	})
 }
 
-// AddChat resolves a handle and adds it to the monitored set.
-func (m *Monitor) AddChat(ctx context.Context, handle string, formats map[string]bool, uploader storage.Uploader) error {
-	username := strings.TrimPrefix(handle, "@")
+// Login connects to Telegram, runs the interactive login if there is no
+// authorized session yet, saves the session, and returns without monitoring
+// anything.
+func (m *Monitor) Login(ctx context.Context) error {
+	client := m.newClient(telegram.UpdateHandlerFunc(func(context.Context, tg.UpdatesClass) error {
+		return nil
+	}))
 
-	resolved, err := m.api.ContactsResolveUsername(ctx, &tg.ContactsResolveUsernameRequest{
-		Username: username,
+	return client.Run(ctx, func(ctx context.Context) error {
+		if err := m.authorize(ctx, client); err != nil {
+			return err
+		}
+		self, err := client.Self(ctx)
+		if err != nil {
+			return fmt.Errorf("getting current user: %w", err)
+		}
+		m.logger.Info("Logged in", slog.String("user", self.Username), slog.String("session", m.sessionPath))
+		return nil
 	})
+}
+
+// newClient builds a Telegram client backed by the (optionally encrypted)
+// session file.
+func (m *Monitor) newClient(handler telegram.UpdateHandler) *telegram.Client {
+	var storage session.Storage = &session.FileStorage{Path: m.sessionPath}
+	if m.opts.SessionPassphrase != "" {
+		storage = newEncryptedStorage(m.sessionPath, m.opts.SessionPassphrase)
+	}
+
+	opts := telegram.Options{
+		UpdateHandler:  handler,
+		SessionStorage: storage,
+		DC:             m.opts.DC,
+		Device: telegram.DeviceConfig{
+			DeviceModel:   cmp.Or(m.opts.Device.Model, strings.TrimSpace("kpub "+m.opts.Version)),
+			SystemVersion: m.opts.Device.SystemVersion,
+			AppVersion:    cmp.Or(m.opts.Device.AppVersion, m.opts.Version),
+		},
+	}
+	if m.opts.TestDC {
+		m.logger.Warn("Using Telegram test datacenters; this is for development only")
+		opts.DCList = dcs.Test()
+	}
+	return telegram.NewClient(m.appID, m.appHash, opts)
+}
+
+// authorize runs the terminal login flow if the session isn't authorized.
+func (m *Monitor) authorize(ctx context.Context, client *telegram.Client) error {
+	status, err := client.Auth().Status(ctx)
 	if err != nil {
-		return fmt.Errorf("resolving handle %q: %w", handle, err)
+		return fmt.Errorf("getting auth status: %w", err)
+	}
+	if status.Authorized {
+		return nil
 	}
 
-	key := peerKey(resolved.Peer)
-	if key == "" {
-		return fmt.Errorf("unexpected peer type for %q: %T", handle, resolved.Peer)
+	if !hasTerminal() {
+		return fmt.Errorf("%w: no usable session at %s. Run `kpub login` (or `kpub run` without -d) "+
+			"once interactively to create it, then run detached", ErrLoginRequired, m.sessionPath)
+	}
+	m.logger.Info("Not authorized, starting user authentication...")
+	flow := auth.NewFlow(terminalAuth{}, auth.SendCodeOptions{})
+	if err := flow.Run(ctx, retryPassword{FlowClient: client.Auth(), prompt: terminalAuth{}}); err != nil {
+		return fmt.Errorf("user auth failed: %w", err)
 	}
+	m.logger.Info("Authentication successful")
+	return nil
+}
 
-	m.mu.Lock()
-	m.peers[key] = &monitoredChat{
-		handle:   handle,
-		formats:  formats,
-		uploader: uploader,
+// AddChat resolves chat.Handle (see config.ParseChatRef) and adds it to the
+// monitored set, filtering and converting documents as chat describes.
+func (m *Monitor) AddChat(ctx context.Context, chat config.ResolvedChat, uploader storage.Uploader) error {
+	key, err := m.resolveKey(ctx, chat.Handle)
+	if err != nil {
+		return err
 	}
+	monitored := newMonitoredChat(chat, uploader, m.resolveErrorPeer(ctx, chat))
+	monitored.resolvedAt = time.Now()
+
+	m.mu.Lock()
+	m.peers[key] = monitored
 	m.mu.Unlock()
 
-	m.logger.Info("Now monitoring chat", "handle", handle, "key", key)
+	m.logger.Info("Now monitoring chat", "handle", chat.Handle, "key", key)
+
+	go func() {
+		// Interrupted files go first, so backfill sees them as pending
+		// rather than starting them a second time.
+		m.resumeJobs(ctx, chat, monitored)
+		if chat.Backfill > 0 {
+			m.backfill(ctx, chat)
+		}
+	}()
 	return nil
 }
 
+// UpdateChat applies a changed config to a chat that is already monitored,
+// keeping the peer its handle resolved to, so monitoring carries on without
+// the gap of RemoveChat and AddChat. Files already being processed finish
+// with the settings they started with. Backfill isn't run again.
+func (m *Monitor) UpdateChat(ctx context.Context, chat config.ResolvedChat, uploader storage.Uploader) error {
+	updated := newMonitoredChat(chat, uploader, m.resolveErrorPeer(ctx, chat))
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for key, c := range m.peers {
+		if config.HandleKey(c.handle) == config.HandleKey(chat.Handle) {
+			updated.resolvedAt = c.resolvedAt
+			m.peers[key] = updated
+			m.logger.Info("Updated chat settings", "handle", chat.Handle, "key", key)
+			return nil
+		}
+	}
+	return fmt.Errorf("chat %q is not monitored", chat.Handle)
+}
+
+// resolveErrorPeer resolves chat's error_notify_to target, or returns nil
+// to send failures to Saved Messages.
+func (m *Monitor) resolveErrorPeer(ctx context.Context, chat config.ResolvedChat) tg.InputPeerClass {
+	if chat.ErrorNotifyTo == "" {
+		return nil
+	}
+	peer, err := m.resolveInputPeer(ctx, chat.ErrorNotifyTo)
+	if err != nil {
+		m.logger.Warn("Could not resolve error_notify_to, sending failures to Saved Messages",
+			"handle", chat.Handle, "errorNotifyTo", chat.ErrorNotifyTo, "reason", err)
+		return nil
+	}
+	return peer
+}
+
+// newMonitoredChat builds the monitor's view of a resolved chat config.
+func newMonitoredChat(chat config.ResolvedChat, uploader storage.Uploader, errorPeer tg.InputPeerClass) *monitoredChat {
+	var chatFolder, dateFolder, destination, ifExists string
+	switch chat.Storage.Type {
+	case "dropbox":
+		destination = chat.Storage.Dropbox.UploadPath
+		ifExists = chat.Storage.Dropbox.IfExists
+		if chat.Storage.Dropbox.FolderPerChat {
+			chatFolder = config.ChatFolder(chat.Handle)
+		}
+		if chat.Storage.Dropbox.DateFolders {
+			dateFolder = chat.Storage.Dropbox.DateFormat
+		}
+	case "email":
+		destination = chat.Storage.Email.To
+	case "b2":
+		destination = "b2://" + path.Join(chat.Storage.B2.Bucket, chat.Storage.B2.Prefix)
+	}
+
+	return &monitoredChat{
+		handle:      chat.Handle,
+		formats:     chat.AcceptedFormats,
+		acceptAll:   chat.AcceptAll,
+		mimeTypes:   chat.AcceptedMimeTypes,
+		requireAll:  chat.RequireAll,
+		minSize:     chat.MinFileSize,
+		convert:     chat.Convert,
+		noConvert:   chat.NoConvertFormats,
+		outputs:     chat.OutputFormats,
+		convertArgs: converter.CalibreArgs(chat.InputEncoding, chat.Language, chat.ConvertOptions),
+		kepubExt:    chat.ConvertedExtension,
+		chatFolder:  chatFolder,
+		dateFolder:  dateFolder,
+		destination: destination,
+		ifExists:    ifExists,
+		prefer:      chat.PreferFormats,
+		uploader:    uploader,
+		errorPeer:   errorPeer,
+		notifyAll:   chat.NotifyLevel == config.NotifyAll,
+	}
+}
+
+// refreshInterval is how long a resolved username is trusted before
+// RefreshChat looks it up again. Reloads can come in quick succession while
+// the config is being edited, and resolving every chat each time would soon
+// run into Telegram's FLOOD_WAIT limit.
+const refreshInterval = 10 * time.Minute
+
+// RefreshChat re-resolves an already monitored handle and, if it now points
+// at a different peer (e.g. a username was reassigned), moves the chat to the
+// new peer. It reports whether the peer changed. Only usernames can be
+// reassigned, so other handles aren't looked up, and neither is a username
+// resolved within refreshInterval.
+func (m *Monitor) RefreshChat(ctx context.Context, handle string) (bool, error) {
+	if ref, err := config.ParseChatRef(handle); err != nil || ref.Kind != config.RefUsername {
+		return false, err
+	}
+	m.mu.RLock()
+	fresh := false
+	for _, chat := range m.peers {
+		if config.HandleKey(chat.handle) == config.HandleKey(handle) {
+			fresh = time.Since(chat.resolvedAt) < refreshInterval
+		}
+	}
+	m.mu.RUnlock()
+	if fresh {
+		return false, nil
+	}
+
+	key, err := m.resolveKey(ctx, handle)
+	if err != nil {
+		return false, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for oldKey, chat := range m.peers {
+		if config.HandleKey(chat.handle) != config.HandleKey(handle) {
+			continue
+		}
+		chat.resolvedAt = time.Now()
+		if oldKey == key {
+			return false, nil
+		}
+		delete(m.peers, oldKey)
+		m.peers[key] = chat
+		m.logger.Warn("Handle now points at a different chat, following it",
+			"handle", handle, "oldKey", oldKey, "key", key)
+		return true, nil
+	}
+	return false, fmt.Errorf("chat %q is not monitored", handle)
+}
+
 // RemoveChat removes a handle from the monitored set.
 func (m *Monitor) RemoveChat(handle string) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
 	for key, chat := range m.peers {
-		if chat.handle == handle {
+		if config.HandleKey(chat.handle) == config.HandleKey(handle) {
 			delete(m.peers, key)
 			m.logger.Info("Stopped monitoring chat", "handle", handle, "key", key)
 			return
@@ -157,6 +615,11 @@ func (m *Monitor) handleMessage(ctx context.Context, e tg.Entities, update *tg.U
 		return nil
 	}
 
+	if m.isAdminCommand(msg) {
+		m.handleLater(ctx, func(ctx context.Context) { m.handleCommand(ctx, msg.Message) })
+		return nil
+	}
+
 	var key string
 	switch p := msg.PeerID.(type) {
 	case *tg.PeerUser:
@@ -175,7 +638,8 @@ func (m *Monitor) handleMessage(ctx context.Context, e tg.Entities, update *tg.U
 		return nil
 	}
 
-	return m.processDocument(ctx, msg, chat)
+	m.handleLater(ctx, func(ctx context.Context) { m.processUpdate(ctx, msg, chat) })
+	return nil
 }
 
 // handleChannelMessage handles messages from channels and supergroups.
@@ -200,61 +664,251 @@ func (m *Monitor) handleChannelMessage(ctx context.Context, e tg.Entities, updat
 		return nil
 	}
 
+	m.handleLater(ctx, func(ctx context.Context) { m.processUpdate(ctx, msg, chat) })
+	return nil
+}
+
+// processUpdate processes a message from a live update unless it is older
+// than Options.MaxMessageAge. After a long disconnect Telegram can deliver a
+// backlog of old updates, and those files were usually handled before the
+// outage. Backfill calls processDocument directly, so it isn't affected.
+func (m *Monitor) processUpdate(ctx context.Context, msg *tg.Message, chat *monitoredChat) error {
+	if m.opts.MaxMessageAge > 0 && msg.Date != 0 {
+		if age := time.Since(time.Unix(int64(msg.Date), 0)); age > m.opts.MaxMessageAge {
+			m.logger.Info("Skipping message",
+				slog.String("chat", chat.handle),
+				slog.Int("id", msg.ID),
+				slog.String("reason", "older than max_message_age"),
+				slog.Duration("age", age.Round(time.Second)))
+			return nil
+		}
+	}
 	return m.processDocument(ctx, msg, chat)
 }
 
-// processDocument extracts a document from a message and kicks off processing.
-func (m *Monitor) processDocument(ctx context.Context, msg *tg.Message, chat *monitoredChat) error {
+// screenResult is the outcome of applying a chat's filters to a message.
+type screenResult struct {
+	doc      *tg.Document
+	fileName string
+	skip     string     // why the message is skipped; "" means process it
+	level    slog.Level // log level for a skip
+	record   bool       // whether a skip goes into the history log
+}
+
+// screen decides whether msg should be processed for chat, without side
+// effects, so it can also back a dry run.
+func (m *Monitor) screen(msg *tg.Message, chat *monitoredChat) screenResult {
 	media, ok := msg.Media.(*tg.MessageMediaDocument)
 	if !ok {
-		return nil
+		if msg.Media == nil {
+			return screenResult{skip: "no media", level: slog.LevelDebug - 1}
+		}
+		return screenResult{skip: "media is not a document (" + msg.Media.TypeName() + ")", level: slog.LevelDebug}
 	}
 
-	doc, ok := media.Document.AsNotEmpty()
+	// An empty document usually means the file expired (e.g. a
+	// self-destructing message) or is restricted, so say which message it was.
+	docClass, ok := media.GetDocument()
 	if !ok {
-		return nil
+		return screenResult{skip: fmt.Sprintf("message %d has no document, it may have expired", msg.ID), level: slog.LevelDebug}
 	}
-
-	var fileName string
-	for _, attr := range doc.Attributes {
-		if f, ok := attr.(*tg.DocumentAttributeFilename); ok {
-			fileName = f.FileName
-			break
+	doc, ok := docClass.AsNotEmpty()
+	if !ok {
+		skip := fmt.Sprintf("document in message %d is empty, it may have expired or be restricted", msg.ID)
+		if media.TTLSeconds > 0 {
+			skip += fmt.Sprintf(" (self-destructs after %ds)", media.TTLSeconds)
 		}
+		return screenResult{skip: skip, level: slog.LevelDebug}
 	}
 
-	if fileName == "" {
-		m.logger.Warn("Received a document with no filename attribute", "chat", chat.handle)
-		return nil
+	raw := docFileName(doc)
+	r := screenResult{doc: doc, fileName: historyName(doc), level: slog.LevelInfo, record: true}
+	kind := mediaKind(doc)
+	switch {
+	case kind != "" && !chat.formats[strings.ToLower(filepath.Ext(r.fileName))]:
+		r.skip = "document is " + kind
+		r.level, r.record = slog.LevelDebug, false
+	case raw == "":
+		r.skip = "no filename (MIME type " + doc.MimeType + ")"
+		r.level = slog.LevelWarn
+	case r.fileName == "":
+		r.skip = fmt.Sprintf("unsafe filename %q", raw)
+		r.level = slog.LevelWarn
+	case m.paused.Load():
+		r.skip = "paused"
+	case doc.Size < chat.minSize:
+		r.skip = fmt.Sprintf("too small (%d bytes, minimum %d)", doc.Size, chat.minSize)
+	default:
+		ext := strings.ToLower(filepath.Ext(r.fileName))
+		if !chat.accepts(ext, doc.MimeType) {
+			r.skip = fmt.Sprintf("unsupported format (%s, %s)", ext, doc.MimeType)
+		}
 	}
+	return r
+}
 
-	ext := strings.ToLower(filepath.Ext(fileName))
-	if !chat.formats[ext] {
-		m.logger.Info("Rejected file with unsupported format",
+// processDocument extracts a document from a message and kicks off processing.
+func (m *Monitor) processDocument(ctx context.Context, msg *tg.Message, chat *monitoredChat) error {
+	r := m.screen(msg, chat)
+	if r.skip != "" {
+		m.logger.Log(ctx, r.level, "Skipping message",
 			slog.String("chat", chat.handle),
-			slog.String("fileName", fileName),
-			slog.String("extension", ext))
+			slog.String("fileName", r.fileName),
+			slog.String("reason", r.skip))
+		if r.record {
+			m.record(chat, r.fileName, history.Skipped, r.skip)
+		}
 		return nil
 	}
+	doc, fileName := r.doc, r.fileName
+	if raw := docFileName(doc); raw != fileName {
+		m.logger.Warn("Filename contains path components, using its base name",
+			slog.String("chat", chat.handle),
+			slog.String("original", raw),
+			slog.String("fileName", fileName))
+	}
+	received := time.Now()
+	if msg.Date != 0 {
+		received = time.Unix(int64(msg.Date), 0)
+	}
 
 	// Use a context that won't be cancelled on shutdown so in-flight
 	// file processing can complete while wg.Wait() blocks.
-	fileCtx := context.WithoutCancel(ctx)
+	j := fileJob{ctx: context.WithoutCancel(ctx), doc: doc, fileName: fileName, received: received, chat: chat}
 	m.wg.Add(1)
-	go func() {
-		defer m.wg.Done()
-		m.processFile(fileCtx, doc, fileName, chat)
-	}()
+	m.inFlight.Add(1)
+	m.opts.Jobs.save(pendingJob{Chat: chat.handle, MessageID: msg.ID, DocumentID: doc.ID, FileName: fileName, Stage: "queued"})
 
+	if len(chat.prefer) > 0 {
+		m.holdForPreferred(j)
+		return nil
+	}
+	m.schedule(j)
 	return nil
 }
 
-// processFile downloads, converts, and uploads an ebook file.
-func (m *Monitor) processFile(ctx context.Context, doc *tg.Document, fileName string, chat *monitoredChat) {
+// schedule enqueues j, after the debounce delay if one is configured.
+func (m *Monitor) schedule(j fileJob) {
+	if m.opts.Debounce <= 0 {
+		m.enqueue(j)
+		return
+	}
+
+	// Restart the timer if this document is already waiting, so the last
+	// update wins and only one run happens.
+	m.pendingMu.Lock()
+	defer m.pendingMu.Unlock()
+	if t, ok := m.pending[j.doc.ID]; ok && t.Stop() {
+		m.logger.Debug("Collapsing repeated update for document", slog.String("fileName", j.fileName))
+		m.inFlight.Add(-1)
+		m.wg.Done()
+	}
+	m.pending[j.doc.ID] = time.AfterFunc(m.opts.Debounce, func() {
+		m.pendingMu.Lock()
+		delete(m.pending, j.doc.ID)
+		m.pendingMu.Unlock()
+		m.enqueue(j)
+	})
+}
+
+// docFileName returns the document's filename attribute, or "". Attributes
+// can come in any order; if there is more than one filename, the first
+// non-empty one wins.
+func docFileName(doc *tg.Document) string {
+	for _, attr := range doc.Attributes {
+		if f, ok := attr.(*tg.DocumentAttributeFilename); ok && strings.TrimSpace(f.FileName) != "" {
+			return f.FileName
+		}
+	}
+	return ""
+}
+
+// mediaKind describes a document that Telegram marks as a sticker, GIF,
+// voice message, audio or video, such as "a sticker", or returns "" for a
+// plain file. These carry a filename too, but are never ebooks, so screen
+// skips them unless the chat lists their extension explicitly. Attributes
+// are checked in a fixed order, so the result doesn't depend on theirs.
+func mediaKind(doc *tg.Document) string {
+	var sticker, animated, voice, audio, round, video bool
+	for _, attr := range doc.Attributes {
+		switch a := attr.(type) {
+		case *tg.DocumentAttributeSticker, *tg.DocumentAttributeCustomEmoji:
+			sticker = true
+		case *tg.DocumentAttributeAnimated:
+			animated = true
+		case *tg.DocumentAttributeAudio:
+			audio, voice = true, voice || a.Voice
+		case *tg.DocumentAttributeVideo:
+			video, round = true, round || a.RoundMessage
+		}
+	}
+	switch {
+	case sticker:
+		return "a sticker"
+	case animated:
+		return "a GIF"
+	case voice:
+		return "a voice message"
+	case round:
+		return "a video message"
+	case video:
+		return "a video"
+	case audio:
+		return "audio"
+	}
+	return ""
+}
+
+// historyName returns the name doc is handled and recorded in the history
+// log under, so lookups in the log must use it too.
+func historyName(doc *tg.Document) string {
+	return safeFileName(docFileName(doc))
+}
+
+// safeFileName reduces a sender-supplied filename to a plain base name that
+// can't escape the download directory or the upload folder: directory
+// components (either separator) and control characters are dropped. It
+// returns "" when nothing usable is left, e.g. for "..".
+func safeFileName(name string) string {
+	if i := strings.LastIndexAny(name, `/\`); i >= 0 {
+		name = name[i+1:]
+	}
+	name = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, name)
+	name = strings.TrimSpace(name)
+	if name == "." || name == ".." {
+		return ""
+	}
+	return name
+}
+
+// runFile processes a file and releases its wg and in-flight slots.
+func (m *Monitor) runFile(ctx context.Context, doc *tg.Document, fileName string, received time.Time, chat *monitoredChat) {
+	defer m.wg.Done()
+	defer m.inFlight.Add(-1)
+	defer m.opts.Jobs.remove(doc.ID)
+	m.processFile(ctx, doc, fileName, received, chat)
+}
+
+// processFile downloads, converts, and uploads an ebook file. received is
+// the message date, used for date folders.
+func (m *Monitor) processFile(ctx context.Context, doc *tg.Document, fileName string, received time.Time, chat *monitoredChat) {
 	m.logger.Info("File received, starting process",
 		slog.String("chat", chat.handle),
 		slog.String("fileName", fileName))
 
+	// Notifications use the untimed context so a timeout can still be reported.
+	notifyCtx := ctx
+	if m.opts.FileTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, m.opts.FileTimeout)
+		defer cancel()
+	}
+
 	if err := os.MkdirAll(m.downloadDir, 0o750); err != nil {
 		m.logger.Error("Failed to create download directory", slog.Any("reason", err))
 		return
@@ -263,54 +917,389 @@ func (m *Monitor) processFile(ctx context.Context, doc *tg.Document, fileName st
 		m.logger.Error("Failed to create converted directory", slog.Any("reason", err))
 		return
 	}
-	downloadPath := filepath.Join(m.downloadDir, fileName)
+	// Filesystems cap names at 255 bytes. Leave room for a conversion to
+	// swap in a longer extension; history and notifications keep the
+	// original name.
+	localName := storage.ShortenName(fileName, storage.MaxNameBytes-len(converter.DefaultFormat))
+	if localName != fileName {
+		m.logger.Warn("Filename is too long, shortening it",
+			slog.String("fileName", fileName),
+			slog.String("localName", localName))
+	}
+	downloadPath := filepath.Join(m.downloadDir, localName)
 	defer os.Remove(downloadPath)
 
-	m.notify(ctx, fmt.Sprintf("[kpub] Processing '%s' from %s...", fileName, chat.handle))
+	destination := path.Join(chat.destination, chat.subfolder(received))
+	msg := map[string]any{
+		"filename": fileName, "chat": chat.handle, "destination": destination,
+		"converted": false, "engine": "", "fallback": false, "stage": "", "error": "",
+	}
+	var outputs []string
+	var notice *fileNotice
+	failed := func(stage, reason string) {
+		msg["stage"], msg["error"] = stage, reason
+		notice.finish(severityError, chat, m.render(m.msgs.failure, msg))
+		m.record(chat, fileName, history.Failed, stage+": "+reason)
+		if m.opts.KeepOnFailure {
+			m.keepFailed(append([]string{downloadPath}, outputs...))
+		}
+	}
+
+	notice = m.startNotice(notifyCtx, m.render(m.msgs.processing, msg))
+	stage := func(s string) {
+		notice.stage(s)
+		m.opts.Jobs.setStage(doc.ID, s)
+	}
 
 	// Download
-	m.logger.Info("Downloading", slog.String("fileName", fileName))
-	location := doc.AsInputDocumentFileLocation()
-	_, err := m.downloader.Download(m.api, location).ToPath(ctx, downloadPath)
+	stage("downloading")
+	release, err := m.downloadSlots.acquire(ctx)
+	if err == nil {
+		m.logger.Info("Downloading", slog.String("fileName", fileName))
+		err = m.download(ctx, doc.AsInputDocumentFileLocation(), downloadPath)
+		release()
+	}
 	if err != nil {
 		m.logger.Error("Failed to download file", slog.Any("reason", err))
-		m.notify(ctx, fmt.Sprintf("[kpub] Failed to download '%s': %s", fileName, shortError(err)))
+		failed("download", m.failureReason(ctx, err))
 		return
 	}
 
-	// Convert
-	m.logger.Info("Download complete, converting to KEPUB")
-	kepubPath, err := converter.Convert(ctx, downloadPath, m.convertedDir)
-	if err != nil {
-		m.logger.Error("Failed to convert to KEPUB",
-			slog.String("fileName", fileName),
-			slog.String("reason", err.Error()))
-		m.notify(ctx, fmt.Sprintf("[kpub] Failed to convert '%s': %s", fileName, shortError(err)))
-		return
+	// Hash what was received, so a file can keep its name at the
+	// destination while a different one with the same name can't take it.
+	var hash string
+	if m.names != nil {
+		if hash, err = fileHash(downloadPath); err != nil {
+			m.logger.Warn("Could not hash file, uploading without the name check",
+				slog.String("fileName", fileName), slog.Any("reason", err))
+		}
+	}
+
+	// Convert, once per output format
+	convert := chat.convert && !chat.noConvert[strings.ToLower(filepath.Ext(fileName))]
+	outputs = []string{downloadPath}
+	var engines []string
+	delivered := false
+	if convert {
+		outputs = nil
+		defer func() {
+			if !delivered || !m.opts.KeepConverted {
+				for _, p := range outputs {
+					os.Remove(p)
+				}
+			}
+		}()
+		for _, format := range chat.outputs {
+			m.logger.Info("Download complete, converting", slog.String("format", format))
+			stage("converting")
+			convertCtx := converter.WithProgress(ctx, notice.progress)
+			convertCtx = converter.WithTarget(convertCtx, format, chat.convertArgs)
+			convertCtx = converter.WithExtension(convertCtx, chat.kepubExt)
+			var res converter.Result
+			release, err = m.conversionSlotsFor(fileName).acquire(ctx)
+			if err == nil {
+				res, err = m.convert(convertCtx, downloadPath)
+				release()
+			}
+			if err != nil {
+				m.logger.Error("Failed to convert",
+					slog.String("fileName", fileName),
+					slog.String("format", format),
+					slog.String("reason", err.Error()))
+				failed("convert", m.failureReason(ctx, err))
+				return
+			}
+			outputs = append(outputs, res.Path)
+			if res.Engine != "" && !slices.Contains(engines, res.Engine) {
+				engines = append(engines, res.Engine)
+			}
+			if res.Fallback {
+				msg["fallback"] = true
+			}
+		}
+		msg["engine"] = strings.Join(engines, ", ")
+	} else {
+		m.logger.Info("Download complete, conversion disabled for this chat or format")
+	}
+
+	// Post-process
+	for _, out := range outputs {
+		if err := converter.PostProcess(ctx, m.opts.PostProcess, out); err != nil {
+			m.logger.Error("Post-process hook failed, skipping upload",
+				slog.String("fileName", fileName),
+				slog.String("reason", err.Error()))
+			failed("post-process", m.failureReason(ctx, err))
+			return
+		}
 	}
-	defer os.Remove(kepubPath)
 
 	// Upload
-	remoteName := filepath.Base(kepubPath)
-	m.logger.Info("Conversion complete, uploading to storage", slog.String("fileName", remoteName))
-	err = chat.uploader.Upload(ctx, kepubPath, remoteName)
-	if err != nil {
-		m.logger.Error("Failed to upload", slog.String("reason", err.Error()))
-		m.notify(ctx, fmt.Sprintf("[kpub] Failed to upload '%s': %s", fileName, shortError(err)))
-		return
+	uploadCtx := storage.WithRetry(ctx, notice.retry)
+	remoteNames := make([]string, 0, len(outputs))
+	var remotes []string
+	var claimed []string
+	defer func() {
+		// A name is only kept by a file that was delivered under it, so
+		// a failed upload doesn't push a later file onto another name.
+		for _, name := range claimed {
+			m.names.finish(destination, name, hash, delivered)
+		}
+	}()
+	for _, out := range outputs {
+		remoteName := filepath.Base(out)
+		if hash != "" {
+			unique, err := m.names.claim(destination, remoteName, hash)
+			if err != nil {
+				m.logger.Warn("Could not check for a name collision", slog.String("fileName", remoteName), slog.Any("reason", err))
+			} else {
+				claimed = append(claimed, unique)
+			}
+			if err == nil && unique != remoteName {
+				m.logger.Warn("A different file was already delivered under this name, renaming",
+					slog.String("chat", chat.handle),
+					slog.String("destination", destination),
+					slog.String("fileName", remoteName),
+					slog.String("uploadName", unique))
+				remoteName = unique
+			}
+			remotes = append(remotes, path.Join(destination, remoteName))
+		}
+		uploadName := path.Join(chat.subfolder(received), remoteName)
+		if storage.SkipExisting(ctx, chat.uploader, chat.ifExists, out, uploadName) {
+			m.logger.Info("File already exists at destination, skipping upload", slog.String("fileName", uploadName))
+			remoteNames = append(remoteNames, remoteName)
+			continue
+		}
+		m.logger.Info("Conversion complete, uploading to storage", slog.String("fileName", uploadName))
+		stage("uploading")
+		if err := m.opts.UploadState.Upload(uploadCtx, chat.uploader, out, uploadName); err != nil {
+			m.logger.Error("Failed to upload", slog.String("reason", err.Error()))
+			failed("upload", m.failureReason(ctx, err))
+			return
+		}
+		remoteNames = append(remoteNames, remoteName)
+	}
+
+	delivered = true
+	if m.opts.KeepConverted && !convert {
+		m.keepOriginal(downloadPath)
 	}
 
+	remoteName := strings.Join(remoteNames, ", ")
 	m.logger.Info("Success! Pipeline complete", slog.String("fileName", remoteName))
-	m.notify(ctx, fmt.Sprintf("[kpub] Done! '%s' is ready on your Kobo.", remoteName))
+	m.recordDelivered(chat, fileName, hash, remotes)
+	msg["filename"], msg["converted"] = remoteName, convert
+	notice.finish(severityInfo, chat, m.render(m.msgs.success, msg))
+}
+
+// convert runs the configured converter on path. A converter.Chain also
+// reports which engine did the work, for the success notification.
+func (m *Monitor) convert(ctx context.Context, path string) (converter.Result, error) {
+	if c, ok := m.opts.Converter.(*converter.Chain); ok {
+		return c.ConvertWith(ctx, path, m.convertedDir)
+	}
+	out, err := m.opts.Converter.Convert(ctx, path, m.convertedDir)
+	return converter.Result{Path: out}, err
+}
+
+// conversionSlotsFor returns the semaphore a conversion of fileName waits
+// on: its extension's own, if it has a conversion limit, or the shared one.
+func (m *Monitor) conversionSlotsFor(fileName string) semaphore {
+	if s, ok := m.typeSlots[strings.ToLower(filepath.Ext(fileName))]; ok {
+		return s
+	}
+	return m.conversionSlots
+}
+
+// keepOriginal moves a file that was uploaded without conversion into the
+// converted directory, so KeepConverted retains every delivered file.
+func (m *Monitor) keepOriginal(downloadPath string) {
+	kept := filepath.Join(m.convertedDir, filepath.Base(downloadPath))
+	if err := os.Rename(downloadPath, kept); err != nil {
+		m.logger.Warn("Could not keep delivered file", slog.String("path", kept), slog.Any("reason", err))
+	}
 }
 
+// failedDir is where KeepOnFailure keeps files, under the converted
+// directory.
+const failedDir = "failed"
+
+// keepFailed moves the files of a failed run into failedDir so they can be
+// inspected, instead of letting the deferred cleanup delete them. Paths that
+// don't exist, like a conversion that never finished, are skipped.
+func (m *Monitor) keepFailed(paths []string) {
+	dir := filepath.Join(m.convertedDir, failedDir)
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		m.logger.Warn("Could not keep files of failed run", slog.String("path", dir), slog.Any("reason", err))
+		return
+	}
+	var kept []string
+	for _, p := range paths {
+		if _, err := os.Stat(p); err != nil {
+			continue
+		}
+		dst := filepath.Join(dir, filepath.Base(p))
+		if err := os.Rename(p, dst); err != nil {
+			m.logger.Warn("Could not keep file of failed run", slog.String("path", p), slog.Any("reason", err))
+			continue
+		}
+		kept = append(kept, dst)
+	}
+	if len(kept) > 0 {
+		m.logger.Info("Kept files of failed run for inspection", slog.Any("paths", kept))
+	}
+}
+
+// download writes a file to path, throttled by the bandwidth limiter.
+func (m *Monitor) download(ctx context.Context, location tg.InputFileLocationClass, path string) error {
+	// A download cut short never shows up at path.
+	return storage.WriteFileAtomic(path, 0o644, func(w io.Writer) error {
+		_, err := m.downloader.Download(m.api, location).Stream(ctx, m.opts.Limiter.Writer(ctx, w))
+		return err
+	})
+}
+
+// record adds a history entry, logging rather than failing if it can't be
+// written.
+func (m *Monitor) record(chat *monitoredChat, fileName string, status history.Status, reason string) {
+	m.recordEntry(history.Entry{
+		Chat:     chat.handle,
+		FileName: fileName,
+		Status:   status,
+		Reason:   reason,
+	})
+}
+
+// recordDelivered records a delivered file, with its hash and where it was
+// uploaded when unique_names is on.
+func (m *Monitor) recordDelivered(chat *monitoredChat, fileName, hash string, remotes []string) {
+	m.recordEntry(history.Entry{
+		Chat:     chat.handle,
+		FileName: fileName,
+		Status:   history.Delivered,
+		Hash:     hash,
+		Remote:   remotes,
+	})
+}
+
+func (m *Monitor) recordEntry(e history.Entry) {
+	if err := m.opts.History.Record(e); err != nil {
+		m.logger.Warn("Failed to record history", slog.Any("reason", err))
+	}
+}
+
+// failureReason returns a short description of a stage error, calling out the
+// per-file timeout since the underlying error is just "context deadline exceeded".
+func (m *Monitor) failureReason(ctx context.Context, err error) string {
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		m.logger.Warn("File processing timed out", slog.Duration("timeout", m.opts.FileTimeout))
+		return fmt.Sprintf("timed out after %s", m.opts.FileTimeout)
+	}
+	return shortError(err)
+}
+
+// Notify sends a status message to the user's Saved Messages.
+func (m *Monitor) Notify(ctx context.Context, text string) {
+	m.notify(ctx, text)
+}
+
+// severity picks where a chat's notification goes.
+type severity int
+
+const (
+	severityInfo  severity = iota // Saved Messages, or the chat's notify target at level "all"
+	severityError                 // the chat's notify target, if it has one
+)
+
 // notify sends a status message to the user's Saved Messages.
 func (m *Monitor) notify(ctx context.Context, text string) {
+	m.notifyChat(ctx, severityInfo, nil, text)
+}
+
+// notifyChat sends a notification about chat. Errors, and with
+// notify_level "all" deliveries too, go to the chat's notify target when one
+// was resolved; everything else goes to Saved Messages.
+func (m *Monitor) notifyChat(ctx context.Context, sev severity, chat *monitoredChat, text string) {
+	if peer := chat.notifyPeer(sev); peer != nil {
+		m.sendAsUser(ctx, peer, text)
+		return
+	}
+	if m.sendViaBot(ctx, text) {
+		return
+	}
+	m.sendAsUser(ctx, &tg.InputPeerSelf{}, text)
+}
+
+// sendAsUser sends text to peer from the user account.
+func (m *Monitor) sendAsUser(ctx context.Context, peer tg.InputPeerClass, text string) {
 	_, _ = m.api.MessagesSendMessage(ctx, &tg.MessagesSendMessageRequest{
+		Peer:     peer,
+		Message:  text,
+		RandomID: time.Now().UnixNano(),
+	})
+}
+
+// sendViaBot sends text through the notification bot, if one is configured,
+// and reports whether it was delivered.
+func (m *Monitor) sendViaBot(ctx context.Context, text string) bool {
+	if m.bot == nil {
+		return false
+	}
+	if _, err := m.bot.send(ctx, text); err != nil {
+		m.logger.Warn("Could not notify through the bot, using Saved Messages", "reason", err)
+		return false
+	}
+	return true
+}
+
+// sendNotice sends a notification like notify and returns its message ID so
+// it can be edited later, or 0 if the ID isn't known.
+func (m *Monitor) sendNotice(ctx context.Context, text string) int {
+	if m.bot != nil {
+		id, err := m.bot.send(ctx, text)
+		if err == nil {
+			return id
+		}
+		// Fall back without an ID: it would be edited through the bot.
+		m.logger.Warn("Could not notify through the bot, using Saved Messages", "reason", err)
+		m.sendAsUser(ctx, &tg.InputPeerSelf{}, text)
+		return 0
+	}
+	updates, err := m.api.MessagesSendMessage(ctx, &tg.MessagesSendMessageRequest{
 		Peer:     &tg.InputPeerSelf{},
 		Message:  text,
 		RandomID: time.Now().UnixNano(),
 	})
+	if err != nil {
+		return 0
+	}
+	switch u := updates.(type) {
+	case *tg.UpdateShortSentMessage:
+		return u.ID
+	case *tg.Updates:
+		for _, upd := range u.Updates {
+			if id, ok := upd.(*tg.UpdateMessageID); ok {
+				return id.ID
+			}
+		}
+	}
+	return 0
+}
+
+// editNotice replaces the text of a notification sent by sendNotice.
+func (m *Monitor) editNotice(ctx context.Context, id int, text string) {
+	if id == 0 {
+		return
+	}
+	if m.bot != nil {
+		_ = m.bot.edit(ctx, id, text)
+		return
+	}
+	_, _ = m.api.MessagesEditMessage(ctx, &tg.MessagesEditMessageRequest{
+		Peer:    &tg.InputPeerSelf{},
+		ID:      id,
+		Message: text,
+	})
 }
 
 // shortError returns a short, user-friendly message from an error.
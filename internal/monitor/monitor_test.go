@@ -0,0 +1,115 @@
+package monitor
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/gotd/td/tg"
+
+	"github.com/spacesedan/kpub/internal/history"
+)
+
+func TestSafeFileName(t *testing.T) {
+	tests := []struct {
+		name, want string
+	}{
+		{"book.epub", "book.epub"},
+		{"  book.epub ", "book.epub"},
+		{"../../etc/foo.epub", "foo.epub"},
+		{"/etc/passwd", "passwd"},
+		{`..\x.epub`, "x.epub"},
+		{`C:\Users\me\book.epub`, "book.epub"},
+		{"dir/../..", ""},
+		{"..", ""},
+		{".", ""},
+		{"../", ""},
+		{"book\x00.epub", "book.epub"},
+		{"bo\nok\r\t.epub", "book.epub"},
+		{"\x1b[31mred.epub", "[31mred.epub"},
+		{"book\u0085.epub", "book.epub"},
+		{"   ", ""},
+		{"\t\n", ""},
+		{"", ""},
+		{"a/ ", ""},
+		{"Ünïcödé – title.epub", "Ünïcödé – title.epub"},
+	}
+	for _, tt := range tests {
+		if got := safeFileName(tt.name); got != tt.want {
+			t.Errorf("safeFileName(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestDocFileName(t *testing.T) {
+	name := func(n string) tg.DocumentAttributeClass { return &tg.DocumentAttributeFilename{FileName: n} }
+	tests := []struct {
+		name  string
+		attrs []tg.DocumentAttributeClass
+		want  string
+	}{
+		{"none", nil, ""},
+		{"no filename", []tg.DocumentAttributeClass{&tg.DocumentAttributeImageSize{W: 1, H: 1}}, ""},
+		{"only filename", []tg.DocumentAttributeClass{name("book.epub")}, "book.epub"},
+		{"filename last", []tg.DocumentAttributeClass{&tg.DocumentAttributeImageSize{W: 1, H: 1}, &tg.DocumentAttributeHasStickers{}, name("book.epub")}, "book.epub"},
+		{"first of several wins", []tg.DocumentAttributeClass{name("first.epub"), name("second.pdf")}, "first.epub"},
+		{"empty filename skipped", []tg.DocumentAttributeClass{name(""), name("book.epub")}, "book.epub"},
+		{"blank filename skipped", []tg.DocumentAttributeClass{name("  "), &tg.DocumentAttributeVideo{}, name("book.epub")}, "book.epub"},
+		{"only empty filenames", []tg.DocumentAttributeClass{name(""), name(" ")}, ""},
+	}
+	for _, tt := range tests {
+		if got := docFileName(&tg.Document{Attributes: tt.attrs}); got != tt.want {
+			t.Errorf("%s: docFileName = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+// TestHistoryNameMatchesDelivered checks that backfill finds a delivered
+// file in the history log even when the sender's name had to be cleaned up.
+func TestHistoryNameMatchesDelivered(t *testing.T) {
+	h := history.Open(filepath.Join(t.TempDir(), "history.jsonl"))
+	for _, raw := range []string{"book.epub", "shelf/book two.epub", "bell\x07.epub"} {
+		doc := &tg.Document{Attributes: []tg.DocumentAttributeClass{&tg.DocumentAttributeFilename{FileName: raw}}}
+		if err := h.Record(history.Entry{Chat: "@books", FileName: historyName(doc), Status: history.Delivered}); err != nil {
+			t.Fatal(err)
+		}
+		delivered, err := h.Delivered("@books")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !delivered[historyName(doc)] {
+			t.Errorf("%q: not found as delivered under %q", raw, historyName(doc))
+		}
+		if raw != historyName(doc) && delivered[raw] {
+			t.Errorf("%q: recorded under the unsanitized name", raw)
+		}
+	}
+}
+
+func TestMediaKind(t *testing.T) {
+	file := &tg.DocumentAttributeFilename{FileName: "book.epub"}
+	tests := []struct {
+		name  string
+		attrs []tg.DocumentAttributeClass
+		want  string
+	}{
+		{"plain file", []tg.DocumentAttributeClass{file}, ""},
+		{"plain file with thumbnail size", []tg.DocumentAttributeClass{&tg.DocumentAttributeImageSize{W: 90, H: 90}, file}, ""},
+		{"sticker after filename", []tg.DocumentAttributeClass{file, &tg.DocumentAttributeSticker{}}, "a sticker"},
+		{"custom emoji", []tg.DocumentAttributeClass{&tg.DocumentAttributeCustomEmoji{}, file}, "a sticker"},
+		{"GIF: animated with video, either order", []tg.DocumentAttributeClass{&tg.DocumentAttributeVideo{}, file, &tg.DocumentAttributeAnimated{}}, "a GIF"},
+		{"GIF, animated first", []tg.DocumentAttributeClass{&tg.DocumentAttributeAnimated{}, &tg.DocumentAttributeVideo{}}, "a GIF"},
+		{"voice message", []tg.DocumentAttributeClass{file, &tg.DocumentAttributeAudio{Voice: true}}, "a voice message"},
+		{"audio", []tg.DocumentAttributeClass{&tg.DocumentAttributeAudio{}, file}, "audio"},
+		{"round video message", []tg.DocumentAttributeClass{&tg.DocumentAttributeVideo{RoundMessage: true}}, "a video message"},
+		{"video", []tg.DocumentAttributeClass{file, &tg.DocumentAttributeVideo{}}, "a video"},
+		{"sticker beats video, in any order", []tg.DocumentAttributeClass{&tg.DocumentAttributeVideo{}, &tg.DocumentAttributeSticker{}}, "a sticker"},
+		{"video with audio is a video", []tg.DocumentAttributeClass{&tg.DocumentAttributeAudio{}, &tg.DocumentAttributeVideo{}}, "a video"},
+		{"voice in any of several audio attributes", []tg.DocumentAttributeClass{&tg.DocumentAttributeAudio{Voice: true}, &tg.DocumentAttributeAudio{}}, "a voice message"},
+		{"round in any of several video attributes", []tg.DocumentAttributeClass{&tg.DocumentAttributeVideo{RoundMessage: true}, &tg.DocumentAttributeVideo{}}, "a video message"},
+	}
+	for _, tt := range tests {
+		if got := mediaKind(&tg.Document{Attributes: tt.attrs}); got != tt.want {
+			t.Errorf("%s: mediaKind = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
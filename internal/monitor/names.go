@@ -0,0 +1,123 @@
+package monitor
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sync"
+
+	"github.com/spacesedan/kpub/internal/history"
+	"github.com/spacesedan/kpub/internal/storage"
+)
+
+// nameGuard keeps two different files from being uploaded to the same
+// destination path, where the second would overwrite the first, e.g. the
+// same title from two chats that share an upload folder. It remembers which
+// file, by hash of what was received, owns each path; a different file
+// wanting a taken path gets one with part of its hash added instead. The
+// same file always maps to the same path, so sending it again still
+// replaces it. Owners are loaded from the history log on first use and
+// recorded there on delivery.
+type nameGuard struct {
+	history *history.Store
+
+	mu       sync.Mutex
+	owners   map[string]string       // destination path → hash delivered there; nil until loaded
+	reserved map[string]*reservation // destination path → file being uploaded there
+}
+
+// reservation is a path claimed by files in flight with the same hash.
+type reservation struct {
+	hash string
+	n    int
+}
+
+func newNameGuard(h *history.Store) *nameGuard {
+	return &nameGuard{history: h}
+}
+
+// claim returns the name to upload a file with hash as, in folder dest:
+// name itself unless another file already has it, or is being uploaded
+// there, in which case part of the hash is added, and then a counter until
+// the name is free. The path is reserved straight away, so files in flight
+// at the same time don't collide either, until finish is called for it.
+func (g *nameGuard) claim(dest, name, hash string) (string, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.owners == nil {
+		owners, err := g.history.Names()
+		if err != nil {
+			return name, fmt.Errorf("loading delivered names: %w", err)
+		}
+		if owners == nil {
+			owners = make(map[string]string)
+		}
+		g.owners = owners
+		g.reserved = make(map[string]*reservation)
+	}
+
+	candidate := name
+	for i := 1; !g.free(path.Join(dest, candidate), hash); i++ {
+		tag := hash[:8]
+		if i > 1 {
+			tag = fmt.Sprintf("%s-%d", tag, i)
+		}
+		candidate = storage.Disambiguate(name, tag)
+	}
+
+	p := path.Join(dest, candidate)
+	r := g.reserved[p]
+	if r == nil {
+		r = &reservation{hash: hash}
+		g.reserved[p] = r
+	}
+	r.n++
+	return candidate, nil
+}
+
+// free reports whether the file with hash may use p: no other file was
+// delivered there or is being uploaded there.
+func (g *nameGuard) free(p, hash string) bool {
+	if owner, ok := g.owners[p]; ok && owner != hash {
+		return false
+	}
+	r := g.reserved[p]
+	return r == nil || r.hash == hash
+}
+
+// finish ends the reservation of dest/name made by claim for hash. A
+// delivered file keeps the path; otherwise it is free again for any file,
+// unless one was delivered there before.
+func (g *nameGuard) finish(dest, name, hash string, delivered bool) {
+	if g == nil {
+		return
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	p := path.Join(dest, name)
+	if delivered && g.owners != nil {
+		g.owners[p] = hash
+	}
+	if r := g.reserved[p]; r != nil && r.hash == hash {
+		if r.n--; r.n == 0 {
+			delete(g.reserved, p)
+		}
+	}
+}
+
+// fileHash returns the hex SHA-256 of the file at p.
+func fileHash(p string) (string, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
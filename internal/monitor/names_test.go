@@ -0,0 +1,79 @@
+package monitor
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/spacesedan/kpub/internal/history"
+)
+
+func TestNameGuard(t *testing.T) {
+	const (
+		hashA = "aaaaaaaa11111111"
+		hashB = "bbbbbbbb22222222"
+		hashC = "cccccccc33333333"
+		hashD = "bbbbbbbb44444444" // same short tag as hashB
+	)
+	newGuard := func(t *testing.T) *nameGuard {
+		return newNameGuard(history.Open(filepath.Join(t.TempDir(), "history.jsonl")))
+	}
+	claim := func(t *testing.T, g *nameGuard, name, hash string) string {
+		t.Helper()
+		got, err := g.claim("/Books", name, hash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return got
+	}
+
+	t.Run("failed upload frees the name", func(t *testing.T) {
+		g := newGuard(t)
+		name := claim(t, g, "book.epub", hashA)
+		g.finish("/Books", name, hashA, false)
+		if got := claim(t, g, "book.epub", hashB); got != "book.epub" {
+			t.Fatalf("claim after a failed upload = %q, want book.epub", got)
+		}
+	})
+
+	t.Run("delivered file keeps the name", func(t *testing.T) {
+		g := newGuard(t)
+		name := claim(t, g, "book.epub", hashA)
+		g.finish("/Books", name, hashA, true)
+		if got := claim(t, g, "book.epub", hashA); got != "book.epub" {
+			t.Fatalf("same file = %q, want book.epub", got)
+		}
+		if got := claim(t, g, "book.epub", hashB); got != "book (bbbbbbbb).epub" {
+			t.Fatalf("different file = %q, want book (bbbbbbbb).epub", got)
+		}
+	})
+
+	t.Run("files in flight get different names", func(t *testing.T) {
+		g := newGuard(t)
+		seen := map[string]bool{}
+		for _, hash := range []string{hashA, hashB, hashC} {
+			name := claim(t, g, "book.epub", hash)
+			if seen[name] {
+				t.Fatalf("%s claimed %q, already taken", hash, name)
+			}
+			seen[name] = true
+		}
+		if got := claim(t, g, "book.epub", hashA); got != "book.epub" {
+			t.Fatalf("same file in flight twice = %q, want book.epub", got)
+		}
+	})
+
+	t.Run("disambiguated name already taken", func(t *testing.T) {
+		g := newGuard(t)
+		for _, hash := range []string{hashA, hashB} {
+			name := claim(t, g, "book.epub", hash)
+			g.finish("/Books", name, hash, true)
+		}
+		got := claim(t, g, "book.epub", hashD)
+		if got == "book.epub" || got == "book (bbbbbbbb).epub" {
+			t.Fatalf("claim = %q, which another file owns", got)
+		}
+		if want := "book (bbbbbbbb-2).epub"; got != want {
+			t.Fatalf("claim = %q, want %q", got, want)
+		}
+	})
+}
@@ -0,0 +1,77 @@
+package monitor
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// fileNotice is the "Processing" notification for one file. Conversion
+// progress is always appended to it. With messages.edit_in_place it is also
+// edited at each stage and upload retry, and finally replaced by the
+// outcome, so a file leaves one message in the chat instead of two.
+type fileNotice struct {
+	m        *Monitor
+	ctx      context.Context
+	id       int    // 0 if the message can't be edited
+	text     string // the rendered processing message
+	inPlace  bool
+	lastEdit time.Time
+}
+
+// startNotice sends the processing message for a file.
+func (m *Monitor) startNotice(ctx context.Context, text string) *fileNotice {
+	return &fileNotice{
+		m:       m,
+		ctx:     ctx,
+		id:      m.sendNotice(ctx, text),
+		text:    text,
+		inPlace: m.opts.Messages.EditInPlace,
+	}
+}
+
+// stage shows the step the file has reached, e.g. "uploading".
+func (n *fileNotice) stage(s string) {
+	if n.inPlace {
+		n.edit(fmt.Sprintf("%s %s", n.text, s))
+	}
+}
+
+// retry shows that an upload is being tried again. It is a
+// storage.RetryFunc.
+func (n *fileNotice) retry(attempt, max int) {
+	if n.inPlace {
+		n.edit(fmt.Sprintf("%s uploading, retry %d/%d", n.text, attempt, max))
+	}
+}
+
+// progress logs conversion progress and appends it to the notice, editing
+// it at most every few seconds to stay clear of Telegram's rate limits. It
+// is a converter.ProgressFunc.
+func (n *fileNotice) progress(percent int, stage string) {
+	n.m.logger.Debug("Conversion progress", slog.Int("percent", percent), slog.String("stage", stage))
+	if n.id == 0 || time.Since(n.lastEdit) < 5*time.Second {
+		return
+	}
+	n.edit(fmt.Sprintf("%s converting (%d%%)", n.text, percent))
+}
+
+// finish reports the outcome. With edit_in_place it replaces the notice,
+// and is only sent separately when the notice can't be edited or the outcome
+// goes to the chat's notify target; otherwise it is a new message.
+func (n *fileNotice) finish(sev severity, chat *monitoredChat, text string) {
+	if !n.inPlace || n.id == 0 {
+		n.m.notifyChat(n.ctx, sev, chat, text)
+		return
+	}
+	n.edit(text)
+	if chat.notifyPeer(sev) != nil {
+		n.m.notifyChat(n.ctx, sev, chat, text)
+	}
+}
+
+func (n *fileNotice) edit(text string) {
+	n.lastEdit = time.Now()
+	n.m.editNotice(n.ctx, n.id, text)
+}
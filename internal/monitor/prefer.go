@@ -0,0 +1,95 @@
+package monitor
+
+import (
+	"log/slog"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/spacesedan/kpub/internal/history"
+)
+
+// defaultPreferWindow is how long to wait for other formats of a title when
+// Options.PreferWindow isn't set.
+const defaultPreferWindow = 30 * time.Second
+
+// formatGroup collects near-simultaneous posts of the same title in one chat
+// until its timer fires, keeping only the best-ranked file.
+type formatGroup struct {
+	best fileJob
+}
+
+// holdForPreferred parks j for the chat's prefer window. If another format of
+// the same title arrives meanwhile, only the one earliest in prefer_formats
+// is processed and the others are skipped. The caller must already have
+// taken j's wg and inFlight slots.
+func (m *Monitor) holdForPreferred(j fileJob) {
+	key := j.chat.handle + "\x00" + titleKey(j.fileName)
+
+	m.groupsMu.Lock()
+	defer m.groupsMu.Unlock()
+
+	g, ok := m.groups[key]
+	if !ok {
+		m.groups[key] = &formatGroup{best: j}
+		window := m.opts.PreferWindow
+		if window <= 0 {
+			window = defaultPreferWindow
+		}
+		time.AfterFunc(window, func() {
+			m.groupsMu.Lock()
+			best := m.groups[key].best
+			delete(m.groups, key)
+			m.groupsMu.Unlock()
+			m.schedule(best)
+		})
+		return
+	}
+
+	if formatRank(j.chat.prefer, j.fileName) < formatRank(j.chat.prefer, g.best.fileName) {
+		m.skipPreferred(g.best, j.fileName)
+		g.best = j
+		return
+	}
+	m.skipPreferred(j, g.best.fileName)
+}
+
+// skipPreferred releases a job that lost to a preferred format of the same
+// title.
+func (m *Monitor) skipPreferred(j fileJob, preferred string) {
+	m.logger.Info("Skipping message",
+		slog.String("chat", j.chat.handle),
+		slog.String("fileName", j.fileName),
+		slog.String("reason", "preferred format"),
+		slog.String("preferred", preferred))
+	m.record(j.chat, j.fileName, history.Skipped, "preferred format '"+preferred+"'")
+	m.opts.Jobs.remove(j.doc.ID)
+	m.inFlight.Add(-1)
+	m.wg.Done()
+}
+
+// formatRank is the position of fileName's extension in prefer, with
+// unlisted formats ranked after all listed ones.
+func formatRank(prefer []string, fileName string) int {
+	ext := strings.ToLower(filepath.Ext(fileName))
+	for i, p := range prefer {
+		if p == ext {
+			return i
+		}
+	}
+	return len(prefer)
+}
+
+// titleKey reduces a file name to a comparison key for its title, so
+// "The Book.epub" and "the_book.mobi" group together.
+func titleKey(fileName string) string {
+	name := strings.TrimSuffix(fileName, filepath.Ext(fileName))
+	var b strings.Builder
+	for _, r := range strings.ToLower(name) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,135 @@
+package monitor
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/gotd/td/tg"
+
+	"github.com/spacesedan/kpub/internal/history"
+)
+
+// Queue policies for when Options.MaxQueue files are already waiting.
+const (
+	QueueBlock      = "block"       // wait for room, stalling incoming updates
+	QueueReject     = "reject"      // skip the new file
+	QueueDropOldest = "drop_oldest" // skip the longest-waiting file instead
+)
+
+// fileJob is a file waiting for a worker.
+type fileJob struct {
+	ctx      context.Context
+	doc      *tg.Document
+	fileName string
+	received time.Time
+	chat     *monitoredChat
+}
+
+// fileQueue hands files to a fixed number of workers. Each job already holds
+// a wg and inFlight slot, which is released when it runs or is skipped.
+type fileQueue struct {
+	mu       sync.Mutex
+	notEmpty *sync.Cond
+	notFull  *sync.Cond
+	jobs     []fileJob
+}
+
+// startWorkers starts n workers that process queued files for the lifetime
+// of the process.
+func (m *Monitor) startWorkers(n int) {
+	q := &fileQueue{}
+	q.notEmpty = sync.NewCond(&q.mu)
+	q.notFull = sync.NewCond(&q.mu)
+	m.queue = q
+
+	for range n {
+		go func() {
+			for {
+				q.mu.Lock()
+				for len(q.jobs) == 0 {
+					q.notEmpty.Wait()
+				}
+				j := q.jobs[0]
+				q.jobs = q.jobs[1:]
+				q.notFull.Signal()
+				q.mu.Unlock()
+
+				m.runFile(j.ctx, j.doc, j.fileName, j.received, j.chat)
+			}
+		}()
+	}
+}
+
+// enqueue runs a file, through the worker pool if one is configured. The
+// caller must already have taken the file's wg and inFlight slots.
+func (m *Monitor) enqueue(j fileJob) {
+	q := m.queue
+	if q == nil {
+		go m.runFile(j.ctx, j.doc, j.fileName, j.received, j.chat)
+		return
+	}
+
+	q.mu.Lock()
+	for m.opts.MaxQueue > 0 && len(q.jobs) >= m.opts.MaxQueue {
+		switch m.opts.QueuePolicy {
+		case QueueBlock:
+			q.notFull.Wait()
+			continue
+		case QueueDropOldest:
+			dropped := q.jobs[0]
+			q.jobs = q.jobs[1:]
+			q.mu.Unlock()
+			m.skipQueued(dropped, "dropped the oldest waiting file")
+			q.mu.Lock()
+			continue
+		default:
+			q.mu.Unlock()
+			m.skipQueued(j, "skipped the new file")
+			return
+		}
+	}
+	q.jobs = append(q.jobs, j)
+	q.notEmpty.Signal()
+	q.mu.Unlock()
+}
+
+// skipQueued releases a job that won't run because the queue was full.
+func (m *Monitor) skipQueued(j fileJob, action string) {
+	m.logger.Warn("Queue full, "+action,
+		slog.String("chat", j.chat.handle),
+		slog.String("fileName", j.fileName),
+		slog.Int("maxQueue", m.opts.MaxQueue))
+	m.notifyChat(j.ctx, severityError, j.chat, fmt.Sprintf("[kpub] Too many files waiting, skipped '%s' from %s.", j.fileName, j.chat.handle))
+	m.record(j.chat, j.fileName, history.Skipped, "queue full")
+	m.opts.Jobs.remove(j.doc.ID)
+	m.inFlight.Add(-1)
+	m.wg.Done()
+}
+
+// semaphore limits how many files are in one pipeline stage at once. A nil
+// semaphore never blocks.
+type semaphore chan struct{}
+
+// newSemaphore returns a semaphore with n slots, or nil if n is zero.
+func newSemaphore(n int) semaphore {
+	if n <= 0 {
+		return nil
+	}
+	return make(semaphore, n)
+}
+
+// acquire waits for a free slot and returns the function that frees it.
+func (s semaphore) acquire(ctx context.Context) (release func(), err error) {
+	if s == nil {
+		return func() {}, nil
+	}
+	select {
+	case s <- struct{}{}:
+		return func() { <-s }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
@@ -0,0 +1,173 @@
+package monitor
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"github.com/gotd/td/tg"
+
+	"github.com/spacesedan/kpub/internal/config"
+)
+
+// resolveKey turns a configured chat handle into the peer key used to match
+// incoming updates.
+func (m *Monitor) resolveKey(ctx context.Context, handle string) (string, error) {
+	ref, err := config.ParseChatRef(handle)
+	if err != nil {
+		return "", err
+	}
+
+	switch ref.Kind {
+	case config.RefUsername:
+		resolved, err := m.api.ContactsResolveUsername(ctx, &tg.ContactsResolveUsernameRequest{
+			Username: ref.Username,
+		})
+		if err != nil {
+			return "", resolveError(handle, err)
+		}
+		key := peerKey(resolved.Peer)
+		if key == "" {
+			return "", fmt.Errorf("unexpected peer type for %q: %T", handle, resolved.Peer)
+		}
+		return key, nil
+
+	case config.RefChannelID:
+		// Updates only carry the channel ID, so the key doesn't depend on
+		// this lookup; it just catches typos early.
+		_, err := m.api.ChannelsGetChannels(ctx, []tg.InputChannelClass{&tg.InputChannel{ChannelID: ref.ID}})
+		if err != nil {
+			m.logger.Warn("Could not verify channel ID, monitoring it anyway",
+				slog.String("handle", handle), slog.Any("reason", err))
+		}
+		return fmt.Sprintf("c%d", ref.ID), nil
+
+	case config.RefChatID:
+		if _, err := m.api.MessagesGetChats(ctx, []int64{ref.ID}); err != nil {
+			m.logger.Warn("Could not verify chat ID, monitoring it anyway",
+				slog.String("handle", handle), slog.Any("reason", err))
+		}
+		return fmt.Sprintf("c%d", ref.ID), nil
+
+	case config.RefInvite:
+		return m.resolveInvite(ctx, handle, ref.InviteHash)
+	}
+
+	return "", fmt.Errorf("unsupported handle %q", handle)
+}
+
+// resolveInputPeer resolves a handle to a peer messages can be sent to. Only
+// usernames and numeric IDs are supported; invite links aren't, since joining
+// a chat just to notify it would be surprising.
+func (m *Monitor) resolveInputPeer(ctx context.Context, handle string) (tg.InputPeerClass, error) {
+	ref, err := config.ParseChatRef(handle)
+	if err != nil {
+		return nil, err
+	}
+
+	switch ref.Kind {
+	case config.RefUsername:
+		resolved, err := m.api.ContactsResolveUsername(ctx, &tg.ContactsResolveUsernameRequest{
+			Username: ref.Username,
+		})
+		if err != nil {
+			return nil, resolveError(handle, err)
+		}
+		switch p := resolved.Peer.(type) {
+		case *tg.PeerUser:
+			for _, u := range resolved.Users {
+				if user, ok := u.(*tg.User); ok && user.ID == p.UserID {
+					return user.AsInputPeer(), nil
+				}
+			}
+		case *tg.PeerChannel:
+			for _, c := range resolved.Chats {
+				if ch, ok := c.(*tg.Channel); ok && ch.ID == p.ChannelID {
+					return ch.AsInputPeer(), nil
+				}
+			}
+		case *tg.PeerChat:
+			return &tg.InputPeerChat{ChatID: p.ChatID}, nil
+		}
+		return nil, fmt.Errorf("no access to %q", handle)
+
+	case config.RefChannelID:
+		chats, err := m.api.ChannelsGetChannels(ctx, []tg.InputChannelClass{&tg.InputChannel{ChannelID: ref.ID}})
+		if err != nil {
+			return nil, fmt.Errorf("looking up channel %q: %w", handle, err)
+		}
+		for _, c := range chats.GetChats() {
+			if ch, ok := c.(*tg.Channel); ok && ch.ID == ref.ID {
+				return ch.AsInputPeer(), nil
+			}
+		}
+		return nil, fmt.Errorf("no access to channel %q", handle)
+
+	case config.RefChatID:
+		return &tg.InputPeerChat{ChatID: ref.ID}, nil
+	}
+
+	return nil, fmt.Errorf("%q can't be used as a notification target; use an @handle or chat ID", handle)
+}
+
+// resolveError turns the RPC errors users most often hit when adding a chat
+// into guidance, keeping the original error wrapped.
+func resolveError(handle string, err error) error {
+	switch {
+	case tg.IsUsernameNotOccupied(err):
+		return fmt.Errorf("no such username %q; check the spelling (the account may have been renamed or deleted): %w", handle, err)
+	case tg.IsUsernameInvalid(err):
+		return fmt.Errorf("%q isn't a valid username; usernames are 5-32 letters, digits, or underscores: %w", handle, err)
+	case tg.IsInviteHashExpired(err):
+		return fmt.Errorf("invite link %q has expired or was revoked; ask for a new one: %w", handle, err)
+	case tg.IsInviteHashInvalid(err):
+		return fmt.Errorf("invite link %q isn't valid; check it was copied completely: %w", handle, err)
+	default:
+		return fmt.Errorf("resolving handle %q: %w", handle, err)
+	}
+}
+
+// resolveInvite returns the key for an invite link's chat, joining it first
+// if the user isn't already a member.
+func (m *Monitor) resolveInvite(ctx context.Context, handle, hash string) (string, error) {
+	invite, err := m.api.MessagesCheckChatInvite(ctx, hash)
+	if err != nil {
+		return "", resolveError(handle, err)
+	}
+
+	var chat tg.ChatClass
+	switch inv := invite.(type) {
+	case *tg.ChatInviteAlready:
+		chat = inv.Chat
+	case *tg.ChatInvitePeek:
+		chat = inv.Chat
+	case *tg.ChatInvite:
+		m.logger.Info("Joining chat via invite link", slog.String("handle", handle), slog.String("title", inv.Title))
+		updates, err := m.api.MessagesImportChatInvite(ctx, hash)
+		if err != nil {
+			return "", fmt.Errorf("joining via invite link %q: %w", handle, err)
+		}
+		var chats []tg.ChatClass
+		switch u := updates.(type) {
+		case *tg.Updates:
+			chats = u.Chats
+		case *tg.UpdatesCombined:
+			chats = u.Chats
+		}
+		if len(chats) == 0 {
+			return "", fmt.Errorf("joined via invite link %q but got no chat back", handle)
+		}
+		chat = chats[0]
+	default:
+		return "", fmt.Errorf("unexpected invite type for %q: %T", handle, invite)
+	}
+
+	switch c := chat.(type) {
+	case *tg.Chat:
+		return fmt.Sprintf("c%d", c.ID), nil
+	case *tg.Channel:
+		return fmt.Sprintf("c%d", c.ID), nil
+	default:
+		return "", fmt.Errorf("no access to chat behind invite link %q (%T)", handle, chat)
+	}
+}
@@ -0,0 +1,34 @@
+package monitor
+
+import (
+	"context"
+	"log/slog"
+	"testing"
+	"time"
+)
+
+// TestRefreshChatSkipsLookups checks the cases where RefreshChat must not
+// call Telegram at all. The monitor has no API client, so a lookup panics.
+func TestRefreshChatSkipsLookups(t *testing.T) {
+	tests := []struct {
+		name       string
+		handle     string
+		resolvedAt time.Time
+	}{
+		{"username resolved just now", "@books", time.Now()},
+		{"username resolved recently", "@books", time.Now().Add(-refreshInterval / 2)},
+		{"channel ID", "-1001234567890", time.Time{}},
+		{"basic group ID", "-123456", time.Time{}},
+		{"private link", "https://t.me/c/1234567890", time.Time{}},
+	}
+	for _, tt := range tests {
+		m := &Monitor{
+			peers:  map[string]*monitoredChat{"key": {handle: tt.handle, resolvedAt: tt.resolvedAt}},
+			logger: slog.Default(),
+		}
+		moved, err := m.RefreshChat(context.Background(), tt.handle)
+		if moved || err != nil {
+			t.Errorf("%s: RefreshChat = %v, %v; want false, nil", tt.name, moved, err)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package monitor
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"fmt"
+
+	"github.com/gotd/td/session"
+	"golang.org/x/crypto/scrypt"
+)
+
+// encryptedMagic prefixes session files written by encryptedStorage so that
+// a plaintext session from before encryption was enabled can still be read.
+var encryptedMagic = []byte("KPUBENC1")
+
+const (
+	saltSize = 16
+	keySize  = 32
+)
+
+// encryptedStorage wraps a session.FileStorage and encrypts the session with
+// AES-GCM using a key derived from a passphrase. The on-disk layout is
+// magic | salt | nonce | ciphertext.
+type encryptedStorage struct {
+	file       *session.FileStorage
+	passphrase []byte
+}
+
+var _ session.Storage = (*encryptedStorage)(nil)
+
+// IsEncryptedSession reports whether session file contents were written
+// with a passphrase.
+func IsEncryptedSession(data []byte) bool {
+	return bytes.HasPrefix(data, encryptedMagic)
+}
+
+func newEncryptedStorage(path, passphrase string) *encryptedStorage {
+	return &encryptedStorage{
+		file:       &session.FileStorage{Path: path},
+		passphrase: []byte(passphrase),
+	}
+}
+
+// LoadSession reads and decrypts the session. A plaintext session is
+// returned as-is and will be encrypted on the next store.
+func (s *encryptedStorage) LoadSession(ctx context.Context) ([]byte, error) {
+	data, err := s.file.LoadSession(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.HasPrefix(data, encryptedMagic) {
+		return data, nil
+	}
+
+	data = data[len(encryptedMagic):]
+	if len(data) < saltSize {
+		return nil, fmt.Errorf("encrypted session file is truncated")
+	}
+	salt, data := data[:saltSize], data[saltSize:]
+
+	gcm, err := s.cipher(salt)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < gcm.NonceSize() {
+		return nil, fmt.Errorf("encrypted session file is truncated")
+	}
+	nonce, ciphertext := data[:gcm.NonceSize()], data[gcm.NonceSize():]
+
+	plain, err := gcm.Open(nil, nonce, ciphertext, encryptedMagic)
+	if err != nil {
+		return nil, fmt.Errorf("decrypting session file (wrong passphrase?): %w", err)
+	}
+	return plain, nil
+}
+
+// StoreSession encrypts data with a fresh salt and nonce and writes it.
+func (s *encryptedStorage) StoreSession(ctx context.Context, data []byte) error {
+	salt := make([]byte, saltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return fmt.Errorf("generating salt: %w", err)
+	}
+
+	gcm, err := s.cipher(salt)
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return fmt.Errorf("generating nonce: %w", err)
+	}
+
+	out := make([]byte, 0, len(encryptedMagic)+saltSize+len(nonce)+len(data)+gcm.Overhead())
+	out = append(out, encryptedMagic...)
+	out = append(out, salt...)
+	out = append(out, nonce...)
+	out = gcm.Seal(out, nonce, data, encryptedMagic)
+
+	return s.file.StoreSession(ctx, out)
+}
+
+func (s *encryptedStorage) cipher(salt []byte) (cipher.AEAD, error) {
+	key, err := scrypt.Key(s.passphrase, salt, 1<<15, 8, 1, keySize)
+	if err != nil {
+		return nil, fmt.Errorf("deriving session key: %w", err)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("creating session cipher: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
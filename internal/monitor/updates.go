@@ -0,0 +1,68 @@
+package monitor
+
+import (
+	"context"
+	"sync"
+)
+
+// updateQueue hands messages from Telegram's update dispatcher to a single
+// goroutine, so screening a message, or blocking on a full file queue, never
+// holds up the dispatcher. One goroutine keeps messages in arrival order.
+type updateQueue struct {
+	mu     sync.Mutex
+	closed bool
+	ch     chan func(context.Context)
+	done   chan struct{}
+}
+
+func newUpdateQueue(size int) *updateQueue {
+	return &updateQueue{ch: make(chan func(context.Context), size), done: make(chan struct{})}
+}
+
+// run handles queued work until ctx is cancelled, then handles whatever is
+// still queued so no message that already arrived is lost.
+func (q *updateQueue) run(ctx context.Context) {
+	defer close(q.done)
+	for {
+		select {
+		case fn := <-q.ch:
+			fn(ctx)
+		case <-ctx.Done():
+			q.mu.Lock()
+			q.closed = true
+			q.mu.Unlock()
+			for {
+				select {
+				case fn := <-q.ch:
+					fn(ctx)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// handleLater queues fn, or runs it on the calling goroutine when there is
+// no queue, it is full, or it has stopped.
+func (m *Monitor) handleLater(ctx context.Context, fn func(context.Context)) {
+	q := m.updates
+	if q == nil {
+		fn(ctx)
+		return
+	}
+	q.mu.Lock()
+	queued := false
+	if !q.closed {
+		select {
+		case q.ch <- fn:
+			queued = true
+		default:
+			m.logger.Warn("Update buffer is full, handling message on the dispatcher", "size", cap(q.ch))
+		}
+	}
+	q.mu.Unlock()
+	if !queued {
+		fn(ctx)
+	}
+}
@@ -0,0 +1,83 @@
+package monitor
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/gotd/td/telegram"
+	"github.com/gotd/td/tg"
+)
+
+// trackUpdates wraps handler so every update, of any kind, resets the
+// watchdog.
+func (m *Monitor) trackUpdates(handler telegram.UpdateHandler) telegram.UpdateHandler {
+	m.lastUpdate.Store(time.Now().UnixNano())
+	return telegram.UpdateHandlerFunc(func(ctx context.Context, u tg.UpdatesClass) error {
+		m.lastUpdate.Store(time.Now().UnixNano())
+		return handler.Handle(ctx, u)
+	})
+}
+
+// watchdog resyncs with Telegram when no update has arrived for
+// Options.UpdateWatchdog. The connection can stay up while the server stops
+// pushing updates; updates.getState tells it the session wants them again.
+// If even that fails the connection is unusable, and the error is returned
+// so the monitor exits and the container restarts.
+func (m *Monitor) watchdog(ctx context.Context) error {
+	interval := m.opts.UpdateWatchdog
+	timer := time.NewTimer(interval)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-timer.C:
+		}
+
+		idle := time.Since(time.Unix(0, m.lastUpdate.Load()))
+		if idle < interval {
+			timer.Reset(interval - idle)
+			continue
+		}
+
+		m.logger.Warn("No Telegram updates received, resyncing", "idle", idle.Round(time.Second))
+		if _, err := m.api.UpdatesGetState(ctx); err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("no updates for %s and resync failed: %w", idle.Round(time.Second), err)
+		}
+		m.lastUpdate.Store(time.Now().UnixNano())
+		timer.Reset(interval)
+	}
+}
+
+// keepAlivePingTimeout bounds a single keep-alive call.
+const keepAlivePingTimeout = 30 * time.Second
+
+// keepAlive calls help.getNearestDc every Options.KeepAlive until ctx is
+// done. gotd's own MTProto pings keep the session alive, but some NAT
+// gateways only count real traffic, and a connection they drop silently
+// looks fine until the watchdog notices. A failed ping is only logged;
+// deciding the connection is dead is left to the watchdog and gotd.
+func (m *Monitor) keepAlive(ctx context.Context) {
+	ticker := time.NewTicker(m.opts.KeepAlive)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		pingCtx, cancel := context.WithTimeout(ctx, keepAlivePingTimeout)
+		_, err := m.api.HelpGetNearestDC(pingCtx)
+		cancel()
+		if err != nil && ctx.Err() == nil {
+			m.logger.Warn("Keep-alive ping to Telegram failed", "reason", err)
+		}
+	}
+}
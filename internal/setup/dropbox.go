@@ -6,11 +6,19 @@ import (
 	"io"
 	"net/http"
 	"net/url"
+	"os"
 	"os/exec"
+	"path"
 	"runtime"
 	"strings"
+	"time"
 )
 
+// HTTPClient is used for every request to Dropbox during setup. Setup only
+// makes small API calls, so a plain timeout keeps a stalled connection from
+// hanging the wizard; tests may replace it.
+var HTTPClient = &http.Client{Timeout: 30 * time.Second}
+
 // DropboxTokens holds the OAuth tokens returned by Dropbox.
 type DropboxTokens struct {
 	AccessToken  string `json:"access_token"`
@@ -25,7 +33,9 @@ func DropboxAuthURL(appKey string) string {
 	)
 }
 
-// OpenBrowser tries to open the given URL in the user's default browser.
+// OpenBrowser tries to open the given URL in the user's default browser. It
+// returns an error when that clearly can't work, e.g. over SSH without a
+// display, so the caller can ask the user to open the URL themselves.
 func OpenBrowser(u string) error {
 	var cmd string
 	var args []string
@@ -35,6 +45,9 @@ func OpenBrowser(u string) error {
 		cmd = "open"
 		args = []string{u}
 	case "linux":
+		if os.Getenv("DISPLAY") == "" && os.Getenv("WAYLAND_DISPLAY") == "" {
+			return fmt.Errorf("no graphical session (DISPLAY is not set)")
+		}
 		cmd = "xdg-open"
 		args = []string{u}
 	case "windows":
@@ -44,7 +57,10 @@ func OpenBrowser(u string) error {
 		return fmt.Errorf("unsupported platform %q", runtime.GOOS)
 	}
 
-	return exec.Command(cmd, args...).Start()
+	if err := exec.Command(cmd, args...).Start(); err != nil {
+		return fmt.Errorf("running %s: %w", cmd, err)
+	}
+	return nil
 }
 
 // ExchangeDropboxCode exchanges an authorization code for access and refresh tokens.
@@ -63,7 +79,7 @@ func ExchangeDropboxCode(appKey, appSecret, code string) (*DropboxTokens, error)
 	req.SetBasicAuth(appKey, appSecret)
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := HTTPClient.Do(req)
 	if err != nil {
 		return nil, fmt.Errorf("executing token request: %w", err)
 	}
@@ -89,3 +105,50 @@ func ExchangeDropboxCode(appKey, appSecret, code string) (*DropboxTokens, error)
 
 	return &tokens, nil
 }
+
+// TestDropboxUpload uploads a zero-byte marker file to uploadPath and then
+// deletes it, confirming the access token can actually write there.
+func TestDropboxUpload(accessToken, uploadPath string) error {
+	markerPath := path.Join(uploadPath, ".kpub-test")
+
+	apiArg, _ := json.Marshal(map[string]string{"path": markerPath, "mode": "overwrite"})
+	req, err := http.NewRequest(http.MethodPost, "https://content.dropboxapi.com/2/files/upload", strings.NewReader(""))
+	if err != nil {
+		return fmt.Errorf("creating test upload request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("Dropbox-API-Arg", string(apiArg))
+
+	if err := doDropboxRequest(req); err != nil {
+		return fmt.Errorf("uploading test file to %q: %w", uploadPath, err)
+	}
+
+	body, _ := json.Marshal(map[string]string{"path": markerPath})
+	req, err = http.NewRequest(http.MethodPost, "https://api.dropboxapi.com/2/files/delete_v2", strings.NewReader(string(body)))
+	if err != nil {
+		return fmt.Errorf("creating test delete request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	req.Header.Set("Content-Type", "application/json")
+
+	if err := doDropboxRequest(req); err != nil {
+		return fmt.Errorf("deleting test file %q: %w", markerPath, err)
+	}
+	return nil
+}
+
+// doDropboxRequest executes req and returns an error for any non-200 response.
+func doDropboxRequest(req *http.Request) error {
+	resp, err := HTTPClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("executing request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("Dropbox returned %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
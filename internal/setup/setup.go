@@ -22,8 +22,9 @@ func BuildConfig(appID int, appHash, dropboxAppKey, dropboxAppSecret string, cha
 
 	return &config.Config{
 		Telegram: config.TelegramConfig{
-			AppID:   appID,
-			AppHash: appHash,
+			AppID:       appID,
+			AppHash:     appHash,
+			SessionFile: "/data/session.json",
 		},
 		Defaults: config.DefaultsConfig{
 			AcceptedFormats: []string{".epub", ".mobi", ".azw3"},
@@ -11,14 +11,16 @@ import (
 )
 
 // WriteConfig serializes cfg to config.yaml in the given directory.
-// It uses an atomic write (temp file + rename) so that file watchers
-// never see a half-written config.
 func WriteConfig(dir string, cfg *config.Config) error {
 	if err := os.MkdirAll(dir, 0o755); err != nil {
 		return fmt.Errorf("creating directory %q: %w", dir, err)
 	}
+	return WriteConfigFile(filepath.Join(dir, "config.yaml"), cfg)
+}
 
-	path := filepath.Join(dir, "config.yaml")
+// WriteConfigFile serializes cfg to path. It uses an atomic write (temp
+// file + rename) so that file watchers never see a half-written config.
+func WriteConfigFile(path string, cfg *config.Config) error {
 	tmp := path + ".tmp"
 
 	f, err := os.Create(tmp)
@@ -51,22 +53,48 @@ func WriteConfig(dir string, cfg *config.Config) error {
 }
 
 // WriteDropboxTokens serializes tokens to dropbox.json in the given directory.
+// Like WriteConfigFile it writes a temp file and renames it, so a failed
+// write leaves any existing tokens intact.
 func WriteDropboxTokens(dir string, tokens *DropboxTokens) error {
 	if err := os.MkdirAll(dir, 0o755); err != nil {
 		return fmt.Errorf("creating directory %q: %w", dir, err)
 	}
 
 	path := filepath.Join(dir, "dropbox.json")
-	f, err := os.Create(path)
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
 	if err != nil {
-		return fmt.Errorf("creating %q: %w", path, err)
+		return fmt.Errorf("creating temp file %q: %w", tmp, err)
 	}
-	defer f.Close()
 
 	enc := json.NewEncoder(f)
 	enc.SetIndent("", "  ")
 	if err := enc.Encode(tokens); err != nil {
+		f.Close()
+		os.Remove(tmp)
 		return fmt.Errorf("writing dropbox tokens: %w", err)
 	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("closing temp file: %w", err)
+	}
+
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("renaming temp file to %q: %w", path, err)
+	}
 	return nil
 }
+
+// ExistingFiles returns the paths of config.yaml and dropbox.json that
+// already exist in dir, so the wizard can avoid overwriting them silently.
+func ExistingFiles(dir string) []string {
+	var paths []string
+	for _, name := range []string{"config.yaml", "dropbox.json"} {
+		p := filepath.Join(dir, name)
+		if _, err := os.Stat(p); err == nil {
+			paths = append(paths, p)
+		}
+	}
+	return paths
+}
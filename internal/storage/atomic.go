@@ -0,0 +1,40 @@
+package storage
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// WriteFileAtomic creates or replaces the file at path with what write
+// writes. It writes to a temp file in the same directory and renames it into
+// place only once write and close succeed, removing it otherwise, so path
+// only ever holds a complete file: the old one or the new one. Concurrent
+// writers each get their own temp file, and the last rename wins.
+func WriteFileAtomic(path string, perm os.FileMode, write func(w io.Writer) error) error {
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("creating temp file for %q: %w", path, err)
+	}
+	tmp := f.Name()
+	if err := f.Chmod(perm); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return fmt.Errorf("setting permissions of %q: %w", tmp, err)
+	}
+	if err := write(f); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("closing %q: %w", tmp, err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("renaming %q into place: %w", tmp, err)
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+package storage
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// failingWriter writes part of a file, then fails, as a dropped download or
+// a full disk would.
+func failingWriter(w io.Writer) error {
+	if _, err := w.Write([]byte("partial")); err != nil {
+		return err
+	}
+	return errors.New("connection reset")
+}
+
+func TestWriteFileAtomic(t *testing.T) {
+	tests := []struct {
+		name     string
+		existing string // "" for no file at the destination
+		write    func(io.Writer) error
+		wantErr  bool
+		want     string // content at the destination afterwards; "" for none
+	}{
+		{
+			name:  "new file",
+			write: func(w io.Writer) error { _, err := io.WriteString(w, "complete"); return err },
+			want:  "complete",
+		},
+		{
+			name:     "replaces an existing file",
+			existing: "old",
+			write:    func(w io.Writer) error { _, err := io.WriteString(w, "complete"); return err },
+			want:     "complete",
+		},
+		{
+			name:    "failure mid-write leaves nothing",
+			write:   failingWriter,
+			wantErr: true,
+		},
+		{
+			name:     "failure mid-write keeps the old file",
+			existing: "old",
+			write:    failingWriter,
+			wantErr:  true,
+			want:     "old",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			path := filepath.Join(dir, "book.epub")
+			if tt.existing != "" {
+				if err := os.WriteFile(path, []byte(tt.existing), 0o600); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			err := WriteFileAtomic(path, 0o640, tt.write)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error: %v", err, tt.wantErr)
+			}
+
+			got, readErr := os.ReadFile(path)
+			switch {
+			case tt.want == "" && !os.IsNotExist(readErr):
+				t.Errorf("destination holds %q, want no file", got)
+			case tt.want != "" && string(got) != tt.want:
+				t.Errorf("destination holds %q (%v), want %q", got, readErr, tt.want)
+			}
+			if tt.want != "" && tt.existing == "" {
+				if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0o640 {
+					t.Errorf("mode = %v (%v), want 0640", info.Mode().Perm(), err)
+				}
+			}
+
+			entries, _ := os.ReadDir(dir)
+			for _, e := range entries {
+				if e.Name() != "book.epub" {
+					t.Errorf("temp file %s left behind", e.Name())
+				}
+			}
+		})
+	}
+}
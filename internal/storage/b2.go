@@ -0,0 +1,288 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spacesedan/kpub/internal/config"
+	"github.com/spacesedan/kpub/internal/throttle"
+)
+
+// b2MaxNameBytes is the longest file name, including any folders, B2 accepts.
+const b2MaxNameBytes = 1024
+
+// b2AuthURL is the B2 native API authorization endpoint. Every other URL
+// comes from its response.
+const b2AuthURL = "https://api.backblazeb2.com/b2api/v2/b2_authorize_account"
+
+// B2Uploader uploads files to a Backblaze B2 bucket with the native API.
+// Each upload is a single b2_upload_file call, which B2 allows up to 5 GB,
+// far more than any ebook.
+type B2Uploader struct {
+	cfg     config.B2Config
+	limiter *throttle.Limiter
+	client  *http.Client
+	authURL string // b2AuthURL unless a test overrides it
+
+	mu        sync.Mutex
+	apiURL    string // from b2_authorize_account
+	authToken string
+	bucketID  string
+	upload    *b2UploadURL // reused until it fails, as B2 recommends
+}
+
+// b2UploadURL is a b2_get_upload_url result. An upload URL takes one upload
+// at a time, so it is taken out of B2Uploader.upload while in use.
+type b2UploadURL struct {
+	URL   string `json:"uploadUrl"`
+	Token string `json:"authorizationToken"`
+}
+
+// b2Error is a non-OK response from the B2 API.
+type b2Error struct {
+	Status  int    `json:"status"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *b2Error) Error() string {
+	return fmt.Sprintf("b2 returned %d %s: %s", e.Status, e.Code, e.Message)
+}
+
+// NewB2Uploader authorizes with B2 and looks up the bucket, so bad
+// credentials or a missing bucket are reported when the chat is added
+// rather than on the first upload.
+func NewB2Uploader(cfg config.B2Config, limiter *throttle.Limiter) (*B2Uploader, error) {
+	if cfg.KeyID == "" || cfg.ApplicationKey == "" || cfg.Bucket == "" {
+		return nil, fmt.Errorf("b2 storage requires key_id, application_key, and bucket")
+	}
+	b := &B2Uploader{cfg: cfg, limiter: limiter, client: HTTPClient, authURL: b2AuthURL}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := b.authorize(ctx); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// Upload uploads localPath to the bucket as Prefix/remoteName. An expired
+// authorization is renewed and the upload retried once; so is a failed
+// upload URL, with a fresh one, which is how B2 asks clients to handle
+// a busy storage pod.
+func (b *B2Uploader) Upload(ctx context.Context, localPath string, remoteName string) error {
+	sum, err := sha1File(localPath)
+	if err != nil {
+		return fmt.Errorf("failed to hash file for upload: %w", err)
+	}
+	name := fitRemotePath(path.Join(b.cfg.Prefix, remoteName), 0, b2MaxNameBytes)
+
+	for attempt := 0; attempt < 2; attempt++ {
+		err = b.uploadOnce(ctx, localPath, name, sum)
+		if err == nil {
+			slog.Info("Successfully uploaded file to B2", "file", name, "bucket", b.cfg.Bucket)
+			return nil
+		}
+		if attempt == 1 || ctx.Err() != nil {
+			break
+		}
+
+		var apiErr *b2Error
+		switch {
+		case errors.As(err, &apiErr) && apiErr.Status == http.StatusUnauthorized:
+			slog.Warn("B2 authorization expired, renewing and retrying...")
+			if authErr := b.authorize(ctx); authErr != nil {
+				return fmt.Errorf("failed to renew B2 authorization, cannot retry upload: %w", authErr)
+			}
+		case errors.As(err, &apiErr) && apiErr.Status < http.StatusInternalServerError && apiErr.Status != http.StatusRequestTimeout:
+			return err
+		default:
+			slog.Warn("B2 upload failed, retrying with a new upload URL", "error", err)
+		}
+		reportRetry(ctx, attempt+2, 2)
+	}
+	return err
+}
+
+// uploadOnce uploads to an upload URL, returning it for reuse on success.
+func (b *B2Uploader) uploadOnce(ctx context.Context, localPath, name, sum string) error {
+	up, err := b.takeUploadURL(ctx)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.Open(localPath)
+	if err != nil {
+		return fmt.Errorf("failed to open file for upload: %w", err)
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat file for upload: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, up.URL, b.limiter.Reader(ctx, file))
+	if err != nil {
+		return fmt.Errorf("failed to create upload request: %w", err)
+	}
+	req.ContentLength = info.Size()
+	req.Header.Set("Authorization", up.Token)
+	req.Header.Set("X-Bz-File-Name", b2FileName(name))
+	req.Header.Set("Content-Type", "b2/x-auto")
+	req.Header.Set("X-Bz-Content-Sha1", sum)
+
+	if err := b.do(req, nil); err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	b.upload = up
+	b.mu.Unlock()
+	return nil
+}
+
+// takeUploadURL returns the cached upload URL, or a new one if there is none
+// or another upload is using it.
+func (b *B2Uploader) takeUploadURL(ctx context.Context) (*b2UploadURL, error) {
+	b.mu.Lock()
+	up := b.upload
+	b.upload = nil
+	apiURL, token, bucketID := b.apiURL, b.authToken, b.bucketID
+	b.mu.Unlock()
+	if up != nil {
+		return up, nil
+	}
+
+	up = &b2UploadURL{}
+	if err := b.call(ctx, apiURL, token, "b2_get_upload_url", map[string]string{"bucketId": bucketID}, up); err != nil {
+		return nil, fmt.Errorf("getting B2 upload URL: %w", err)
+	}
+	return up, nil
+}
+
+// authorize logs in with the application key and resolves the bucket ID.
+func (b *B2Uploader) authorize(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, b.authURL, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create B2 authorization request: %w", err)
+	}
+	req.SetBasicAuth(b.cfg.KeyID, b.cfg.ApplicationKey)
+
+	var auth struct {
+		AccountID string `json:"accountId"`
+		Token     string `json:"authorizationToken"`
+		APIURL    string `json:"apiUrl"`
+		Allowed   struct {
+			BucketID   string `json:"bucketId"`
+			BucketName string `json:"bucketName"`
+		} `json:"allowed"`
+	}
+	if err := b.do(req, &auth); err != nil {
+		return fmt.Errorf("authorizing with B2: %w", err)
+	}
+
+	// A key restricted to one bucket names it; otherwise look it up.
+	bucketID := ""
+	if auth.Allowed.BucketName == b.cfg.Bucket {
+		bucketID = auth.Allowed.BucketID
+	} else if auth.Allowed.BucketID != "" {
+		return fmt.Errorf("b2 key is restricted to bucket %q, not %q", auth.Allowed.BucketName, b.cfg.Bucket)
+	} else {
+		var list struct {
+			Buckets []struct {
+				BucketID string `json:"bucketId"`
+			} `json:"buckets"`
+		}
+		arg := map[string]string{"accountId": auth.AccountID, "bucketName": b.cfg.Bucket}
+		if err := b.call(ctx, auth.APIURL, auth.Token, "b2_list_buckets", arg, &list); err != nil {
+			return fmt.Errorf("looking up B2 bucket %q: %w", b.cfg.Bucket, err)
+		}
+		if len(list.Buckets) == 0 {
+			return fmt.Errorf("b2 bucket %q not found", b.cfg.Bucket)
+		}
+		bucketID = list.Buckets[0].BucketID
+	}
+
+	b.mu.Lock()
+	b.apiURL, b.authToken, b.bucketID = auth.APIURL, auth.Token, bucketID
+	b.upload = nil // upload URLs are tied to the old authorization
+	b.mu.Unlock()
+	return nil
+}
+
+// call POSTs arg as JSON to a B2 API operation and decodes the result into
+// out.
+func (b *B2Uploader) call(ctx context.Context, apiURL, token, op string, arg, out any) error {
+	body, _ := json.Marshal(arg)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, apiURL+"/b2api/v2/"+op, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create %s request: %w", op, err)
+	}
+	req.Header.Set("Authorization", token)
+	req.Header.Set("Content-Type", "application/json")
+	return b.do(req, out)
+}
+
+// do executes req and decodes a JSON result into out, if non-nil. Non-OK
+// responses become a *b2Error.
+func (b *B2Uploader) do(req *http.Request, out any) error {
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to execute B2 request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	respBody, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		apiErr := &b2Error{Status: resp.StatusCode}
+		if json.Unmarshal(respBody, apiErr) != nil || apiErr.Code == "" {
+			apiErr.Code, apiErr.Message = resp.Status, strings.TrimSpace(string(respBody))
+		}
+		return apiErr
+	}
+	if out != nil {
+		if err := json.Unmarshal(respBody, out); err != nil {
+			return fmt.Errorf("failed to decode B2 response: %w", err)
+		}
+	}
+	return nil
+}
+
+// b2FileName percent-encodes name for the X-Bz-File-Name header, keeping
+// the slashes that B2 shows as folders.
+func b2FileName(name string) string {
+	parts := strings.Split(strings.TrimPrefix(name, "/"), "/")
+	for i, p := range parts {
+		parts[i] = url.PathEscape(p)
+	}
+	return strings.Join(parts, "/")
+}
+
+// sha1File returns the hex SHA-1 of the file at p, which B2 checks the
+// upload against.
+func sha1File(p string) (string, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha1.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -3,6 +3,7 @@ package storage
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
@@ -15,6 +16,14 @@ import (
 	"time"
 
 	"github.com/spacesedan/kpub/internal/config"
+	"github.com/spacesedan/kpub/internal/throttle"
+)
+
+// Default Dropbox endpoints. Content (file data) and RPC calls live on
+// different hosts.
+const (
+	dropboxContentURL = "https://content.dropboxapi.com"
+	dropboxAPIURL     = "https://api.dropboxapi.com"
 )
 
 type dropboxTokens struct {
@@ -30,22 +39,38 @@ type DropboxUploader struct {
 	appKey     string
 	appSecret  string
 	uploadPath string
+	pathRoot   string // Dropbox-API-Path-Root header value, if any
+	chunkSize  int64  // bytes per upload session request
+	limiter    *throttle.Limiter
+
+	// contentURL, apiURL and client default to the real Dropbox endpoints
+	// and HTTPClient; tests point them at an httptest server.
+	contentURL string
+	apiURL     string
+	client     *http.Client
+
+	// onRefresh, if set, is told about every token refresh; refreshFailures
+	// counts consecutive failed ones. Both are guarded by mu.
+	onRefresh       RefreshHook
+	refreshFailures int
+
+	// pathChecked runs checkUploadPath before the first upload.
+	pathChecked sync.Once
 }
 
 // NewDropboxUploader loads tokens from disk and returns a ready uploader.
-func NewDropboxUploader(cfg config.DropboxConfig) (*DropboxUploader, error) {
-	data, err := os.ReadFile(cfg.TokenFile)
+func NewDropboxUploader(cfg config.DropboxConfig, limiter *throttle.Limiter) (*DropboxUploader, error) {
+	tokens, err := loadTokens(cfg.TokenFile)
 	if err != nil {
-		return nil, fmt.Errorf("reading dropbox token file %q: %w", cfg.TokenFile, err)
+		return nil, err
 	}
 
-	var tokens dropboxTokens
-	if err := json.Unmarshal(data, &tokens); err != nil {
-		return nil, fmt.Errorf("parsing dropbox token file %q: %w", cfg.TokenFile, err)
+	chunkSize, err := throttle.ParseSize(cfg.ChunkSize)
+	if err != nil {
+		return nil, fmt.Errorf("dropbox chunk_size: %w", err)
 	}
-
-	if tokens.AccessToken == "" || tokens.RefreshToken == "" {
-		return nil, fmt.Errorf("'access_token' or 'refresh_token' is missing from %q", cfg.TokenFile)
+	if chunkSize == 0 {
+		chunkSize = dropboxChunkSize
 	}
 
 	return &DropboxUploader{
@@ -54,13 +79,58 @@ func NewDropboxUploader(cfg config.DropboxConfig) (*DropboxUploader, error) {
 		appKey:     cfg.AppKey,
 		appSecret:  cfg.AppSecret,
 		uploadPath: cfg.UploadPath,
+		pathRoot:   cfg.PathRoot,
+		chunkSize:  chunkSize,
+		limiter:    limiter,
+		contentURL: dropboxContentURL,
+		apiURL:     dropboxAPIURL,
+		client:     HTTPClient,
 	}, nil
 }
 
+// loadTokens reads a token file, which must hold both tokens.
+func loadTokens(tokenFile string) (dropboxTokens, error) {
+	var tokens dropboxTokens
+	data, err := os.ReadFile(tokenFile)
+	if err != nil {
+		return tokens, fmt.Errorf("reading dropbox token file %q: %w", tokenFile, err)
+	}
+	if err := json.Unmarshal(data, &tokens); err != nil {
+		return tokens, fmt.Errorf("parsing dropbox token file %q: %w", tokenFile, err)
+	}
+	if tokens.AccessToken == "" || tokens.RefreshToken == "" {
+		return tokens, fmt.Errorf("'access_token' or 'refresh_token' is missing from %q", tokenFile)
+	}
+	return tokens, nil
+}
+
 // Upload uploads a local file to Dropbox, retrying once on 401 after refreshing the token.
 func (d *DropboxUploader) Upload(ctx context.Context, localPath string, remoteName string) error {
+	return d.UploadResumable(ctx, localPath, remoteName, nil, nil)
+}
+
+// UploadResumable implements ResumableUploader. Files large enough for an
+// upload session use the session ID and offset as the token, so an
+// interrupted upload continues from the last chunk Dropbox acknowledged.
+// Smaller files are always uploaded whole.
+func (d *DropboxUploader) UploadResumable(ctx context.Context, localPath, remoteName string, token []byte, save func([]byte) error) error {
+	remoteName = fitRemotePath(remoteName, MaxNameBytes, 0)
+	d.pathChecked.Do(func() { d.checkUploadPath(ctx) })
+
+	unlock := dropboxUploads.lock(d.lockKey(remoteName))
+	defer unlock()
+
+	// A retry after a 401 continues from wherever the first attempt got to.
+	saveLatest := func(t []byte) error {
+		token = t
+		if save == nil {
+			return nil
+		}
+		return save(t)
+	}
+
 	for attempt := 0; attempt < 2; attempt++ {
-		err := d.doUpload(ctx, localPath, remoteName)
+		err := d.doUpload(ctx, localPath, remoteName, token, saveLatest)
 		if err == nil {
 			return nil
 		}
@@ -71,6 +141,7 @@ func (d *DropboxUploader) Upload(ctx context.Context, localPath string, remoteNa
 				return fmt.Errorf("failed to refresh token, cannot retry upload: %w", refreshErr)
 			}
 			slog.Info("Retrying Dropbox upload with new token...")
+			reportRetry(ctx, attempt+2, 2)
 			continue
 		}
 
@@ -79,6 +150,27 @@ func (d *DropboxUploader) Upload(ctx context.Context, localPath string, remoteNa
 	return fmt.Errorf("dropbox upload failed after multiple retries")
 }
 
+// dropboxUploads serializes uploads to the same Dropbox path. Uploads use
+// "add" mode, so two racing writers would leave a "name (1)" duplicate.
+// Chats that differ in any Dropbox setting get uploaders of their own, so
+// the locks are shared by all of them rather than kept per uploader.
+var dropboxUploads pathLocks
+
+// lockKey identifies the remote path remoteName uploads to: the account,
+// known by its token file, the namespace, and the path, lowercased since
+// Dropbox paths are case-insensitive.
+func (d *DropboxUploader) lockKey(remoteName string) string {
+	return filepath.Clean(d.tokenFile) + "\x00" + d.pathRoot + "\x00" + strings.ToLower(filepath.Join(d.uploadPath, remoteName))
+}
+
+// setPathRoot adds the Dropbox-API-Path-Root header when a team namespace is
+// configured.
+func (d *DropboxUploader) setPathRoot(req *http.Request) {
+	if d.pathRoot != "" {
+		req.Header.Set("Dropbox-API-Path-Root", d.pathRoot)
+	}
+}
+
 type unauthorizedError struct {
 	msg string
 }
@@ -86,8 +178,8 @@ type unauthorizedError struct {
 func (e *unauthorizedError) Error() string { return e.msg }
 
 func isUnauthorized(err error) bool {
-	_, ok := err.(*unauthorizedError)
-	return ok
+	var unauthorized *unauthorizedError
+	return errors.As(err, &unauthorized)
 }
 
 type dropboxAPIArg struct {
@@ -95,19 +187,30 @@ type dropboxAPIArg struct {
 	Mode string `json:"mode"`
 }
 
-func (d *DropboxUploader) doUpload(ctx context.Context, localPath string, remoteName string) error {
-	uploadURL := "https://content.dropboxapi.com/2/files/upload"
-
+func (d *DropboxUploader) doUpload(ctx context.Context, localPath string, remoteName string, token []byte, save func([]byte) error) error {
 	file, err := os.Open(localPath)
 	if err != nil {
 		return fmt.Errorf("failed to open file for upload: %w", err)
 	}
 	defer file.Close()
 
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, uploadURL, file)
+	info, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat file for upload: %w", err)
+	}
+	if info.Size() > d.chunkSize {
+		if err := d.doChunkedUpload(ctx, localPath, filepath.Join(d.uploadPath, remoteName), info, token, save); err != nil {
+			return err
+		}
+		slog.Info("Successfully uploaded file to Dropbox", "file", remoteName)
+		return nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.contentURL+"/2/files/upload", d.limiter.Reader(ctx, file))
 	if err != nil {
 		return fmt.Errorf("failed to create upload request: %w", err)
 	}
+	req.ContentLength = info.Size()
 
 	d.mu.Lock()
 	accessToken := d.tokens.AccessToken
@@ -122,8 +225,9 @@ func (d *DropboxUploader) doUpload(ctx context.Context, localPath string, remote
 	}
 	apiArgJSON, _ := json.Marshal(apiArg)
 	req.Header.Set("Dropbox-API-Arg", string(apiArgJSON))
+	d.setPathRoot(req)
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := d.client.Do(req)
 	if err != nil {
 		return fmt.Errorf("failed to execute upload request: %w", err)
 	}
@@ -135,6 +239,9 @@ func (d *DropboxUploader) doUpload(ctx context.Context, localPath string, remote
 	}
 
 	bodyBytes, _ := io.ReadAll(resp.Body)
+	if err := permissionError(resp.StatusCode, string(bodyBytes)); err != nil {
+		return err
+	}
 	if resp.StatusCode == http.StatusUnauthorized {
 		return &unauthorizedError{
 			msg: fmt.Sprintf("dropbox returned 401: %s", string(bodyBytes)),
@@ -144,30 +251,68 @@ func (d *DropboxUploader) doUpload(ctx context.Context, localPath string, remote
 	return fmt.Errorf("dropbox API returned non-OK status: %s - Body: %s", resp.Status, string(bodyBytes))
 }
 
+// RefreshHook is called after each Dropbox token refresh. err is nil on
+// success, and failures is the number of refreshes in a row that have
+// failed, including this one (0 after a success).
+type RefreshHook func(err error, failures int)
+
+// SetRefreshHook makes the uploader call h after every token refresh, so the
+// user can be told before uploads start failing.
+func (d *DropboxUploader) SetRefreshHook(h RefreshHook) {
+	d.mu.Lock()
+	d.onRefresh = h
+	d.mu.Unlock()
+}
+
+// refreshToken refreshes the access token and reports the outcome to the
+// refresh hook.
 func (d *DropboxUploader) refreshToken() error {
-	slog.Info("Dropbox access token has expired, attempting to refresh...")
+	err := d.doRefreshToken()
 
-	tokenURL := "https://api.dropboxapi.com/oauth2/token"
+	d.mu.Lock()
+	if err != nil {
+		d.refreshFailures++
+	} else {
+		d.refreshFailures = 0
+	}
+	hook, failures := d.onRefresh, d.refreshFailures
+	d.mu.Unlock()
+
+	if hook != nil {
+		hook(err, failures)
+	}
+	return err
+}
+
+func (d *DropboxUploader) doRefreshToken() error {
+	slog.Info("Dropbox access token has expired, attempting to refresh...")
 
 	data := url.Values{}
 	data.Set("grant_type", "refresh_token")
 
 	d.mu.Lock()
+	// Chats with different Dropbox settings can share a token file, each
+	// with its own uploader, so another one may have rotated the refresh
+	// token since this one read it.
+	if saved, err := loadTokens(d.tokenFile); err == nil {
+		d.tokens.RefreshToken = saved.RefreshToken
+	}
 	data.Set("refresh_token", d.tokens.RefreshToken)
 	d.mu.Unlock()
 
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, tokenURL, strings.NewReader(data.Encode()))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.apiURL+"/oauth2/token", strings.NewReader(data.Encode()))
 	if err != nil {
 		return fmt.Errorf("failed to create refresh request: %w", err)
 	}
 
 	req.SetBasicAuth(d.appKey, d.appSecret)
 	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	d.setPathRoot(req)
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := d.client.Do(req)
 	if err != nil {
 		return fmt.Errorf("failed to execute refresh request: %w", err)
 	}
@@ -178,8 +323,11 @@ func (d *DropboxUploader) refreshToken() error {
 		return fmt.Errorf("token refresh failed with status %s: %s", resp.Status, string(bodyBytes))
 	}
 
+	// Dropbox may rotate the refresh token; keep the old one unless a new
+	// one is returned.
 	var result struct {
-		AccessToken string `json:"access_token"`
+		AccessToken  string `json:"access_token"`
+		RefreshToken string `json:"refresh_token"`
 	}
 	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
 		return fmt.Errorf("failed to decode refresh response: %w", err)
@@ -187,33 +335,35 @@ func (d *DropboxUploader) refreshToken() error {
 
 	slog.Info("Successfully refreshed Dropbox access token")
 
-	// Write to a temp file first, then rename for atomicity.
 	d.mu.Lock()
 	d.tokens.AccessToken = result.AccessToken
+	if result.RefreshToken != "" && result.RefreshToken != d.tokens.RefreshToken {
+		slog.Info("Dropbox issued a new refresh token, saving it")
+		d.tokens.RefreshToken = result.RefreshToken
+	}
 	tokensToSave := d.tokens
 	d.mu.Unlock()
 
-	tmp := d.tokenFile + ".tmp"
-	file, err := os.Create(tmp)
-	if err != nil {
-		return fmt.Errorf("failed to save refreshed token: %w", err)
+	// The new token is already in use, so a save failure doesn't fail the
+	// upload. It does mean the token is gone after a restart, and a rotated
+	// refresh token can't be recovered, so say so loudly.
+	if err := d.saveTokens(tokensToSave); err != nil {
+		slog.Error("Could not save the refreshed Dropbox token; it will be lost on restart. Check that the data directory is writable",
+			"tokenFile", d.tokenFile, "error", err)
 	}
+	return nil
+}
 
-	encoder := json.NewEncoder(file)
-	encoder.SetIndent("", "  ")
-	if err := encoder.Encode(tokensToSave); err != nil {
-		file.Close()
-		os.Remove(tmp)
-		return fmt.Errorf("failed to write refreshed token: %w", err)
-	}
-	if err := file.Close(); err != nil {
-		os.Remove(tmp)
-		return fmt.Errorf("failed to close token file: %w", err)
-	}
-	if err := os.Rename(tmp, d.tokenFile); err != nil {
-		os.Remove(tmp)
-		return fmt.Errorf("failed to rename token file: %w", err)
+// saveTokens writes tokens to the token file with WriteFileAtomic, so a
+// failed write never leaves a truncated file behind.
+func (d *DropboxUploader) saveTokens(tokens dropboxTokens) error {
+	err := WriteFileAtomic(d.tokenFile, 0o600, func(w io.Writer) error {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(tokens)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to save refreshed token: %w", err)
 	}
-
 	return nil
 }
@@ -0,0 +1,131 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// dropboxHashBlock is the block size of Dropbox's content hash.
+const dropboxHashBlock = 4 << 20
+
+var _ RemoteChecker = (*DropboxUploader)(nil)
+
+// RemoteHash implements RemoteChecker using files/get_metadata, retrying
+// once on 401 after refreshing the token.
+func (d *DropboxUploader) RemoteHash(ctx context.Context, remoteName string) (string, bool, error) {
+	remotePath := filepath.Join(d.uploadPath, fitRemotePath(remoteName, MaxNameBytes, 0))
+	for attempt := 0; ; attempt++ {
+		hash, exists, err := d.getMetadata(ctx, remotePath)
+		if attempt == 0 && isUnauthorized(err) {
+			slog.Warn("Dropbox metadata lookup failed with 401, refreshing token and retrying...")
+			if refreshErr := d.refreshToken(); refreshErr != nil {
+				return "", false, fmt.Errorf("failed to refresh token, cannot retry lookup: %w", refreshErr)
+			}
+			continue
+		}
+		return hash, exists, err
+	}
+}
+
+func (d *DropboxUploader) getMetadata(ctx context.Context, remotePath string) (string, bool, error) {
+	meta, exists, err := d.lookup(ctx, remotePath)
+	if err != nil || !exists {
+		return "", false, err
+	}
+	if meta.Tag != "file" {
+		return "", false, errors.New("destination exists but is not a file")
+	}
+	return meta.ContentHash, true, nil
+}
+
+// dropboxMetadata is the part of a files/get_metadata result kpub uses.
+type dropboxMetadata struct {
+	Tag         string `json:".tag"` // "file" or "folder"
+	ContentHash string `json:"content_hash"`
+}
+
+// lookup calls files/get_metadata, reporting false if nothing is at
+// remotePath.
+func (d *DropboxUploader) lookup(ctx context.Context, remotePath string) (dropboxMetadata, bool, error) {
+	var meta dropboxMetadata
+	body, _ := json.Marshal(map[string]string{"path": remotePath})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.apiURL+"/2/files/get_metadata", bytes.NewReader(body))
+	if err != nil {
+		return meta, false, fmt.Errorf("failed to create get_metadata request: %w", err)
+	}
+
+	d.mu.Lock()
+	accessToken := d.tokens.AccessToken
+	d.mu.Unlock()
+
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	req.Header.Set("Content-Type", "application/json")
+	d.setPathRoot(req)
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return meta, false, fmt.Errorf("failed to execute get_metadata request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	respBody, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		if err := permissionError(resp.StatusCode, string(respBody)); err != nil {
+			return meta, false, err
+		}
+	}
+	switch {
+	case resp.StatusCode == http.StatusOK:
+	case resp.StatusCode == http.StatusUnauthorized:
+		return meta, false, &unauthorizedError{
+			msg: fmt.Sprintf("dropbox returned 401: %s", string(respBody)),
+		}
+	case resp.StatusCode == http.StatusConflict && strings.Contains(string(respBody), "not_found"):
+		return meta, false, nil
+	default:
+		return meta, false, &dropboxAPIError{status: resp.Status, body: string(respBody)}
+	}
+
+	if err := json.Unmarshal(respBody, &meta); err != nil {
+		return meta, false, fmt.Errorf("failed to decode get_metadata response: %w", err)
+	}
+	return meta, true, nil
+}
+
+// LocalHash implements RemoteChecker with Dropbox's content hash: the SHA-256
+// of the concatenated SHA-256 digests of each 4 MiB block.
+func (d *DropboxUploader) LocalHash(localPath string) (string, error) {
+	f, err := os.Open(localPath)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	overall := sha256.New()
+	buf := make([]byte, dropboxHashBlock)
+	for {
+		n, err := io.ReadFull(f, buf)
+		if n > 0 {
+			block := sha256.Sum256(buf[:n])
+			overall.Write(block[:])
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+	return hex.EncodeToString(overall.Sum(nil)), nil
+}
@@ -0,0 +1,69 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"path"
+	"strings"
+)
+
+// permissionError turns a Dropbox error caused by how the Dropbox app was
+// set up into one that says how to fix it, or returns nil for any other
+// error. A missing scope comes back as a 401, which a token refresh can't
+// fix, so callers check this before treating a 401 as an expired token.
+func permissionError(status int, body string) error {
+	switch {
+	case strings.Contains(body, "missing_scope"):
+		var resp struct {
+			Error struct {
+				RequiredScope string `json:"required_scope"`
+			} `json:"error"`
+		}
+		scope := "a required"
+		if json.Unmarshal([]byte(body), &resp) == nil && resp.Error.RequiredScope != "" {
+			scope = "the " + resp.Error.RequiredScope
+		}
+		return fmt.Errorf("the Dropbox app doesn't have %s permission: "+
+			"tick it on the app's Permissions tab at https://www.dropbox.com/developers/apps, "+
+			"then run kpub setup again, since existing tokens keep their old permissions (dropbox returned %d: %s)", scope, status, body)
+	case strings.Contains(body, "no_write_permission"):
+		return fmt.Errorf("the Dropbox app isn't allowed to write to upload_path; "+
+			"an app with App folder access can only write inside its own folder, /Apps/<app name> (dropbox returned %d: %s)", status, body)
+	}
+	return nil
+}
+
+// checkUploadPath warns about the most common Dropbox setup mistake: an
+// app with App folder access sees its own folder as "/", so an upload_path
+// of "/Apps/Rakuten Kobo" lands in "/Apps/<app name>/Apps/Rakuten Kobo",
+// where the Kobo never looks. Dropbox reports no error, and the token
+// doesn't say which kind of app it is, so this checks whether the
+// "/Apps/<name>" folder already exists: Kobo creates it when Dropbox is
+// linked, and only a Full Dropbox app can see it. It runs once, before the
+// first upload, and a failed check is only logged.
+func (d *DropboxUploader) checkUploadPath(ctx context.Context) {
+	parts := strings.Split(strings.Trim(path.Clean("/"+d.uploadPath), "/"), "/")
+	if len(parts) < 2 || !strings.EqualFold(parts[0], "Apps") {
+		return
+	}
+	folder := "/" + parts[0] + "/" + parts[1]
+
+	_, exists, err := d.lookup(ctx, folder)
+	if isUnauthorized(err) && d.refreshToken() == nil {
+		_, exists, err = d.lookup(ctx, folder)
+	}
+	if err != nil {
+		slog.Debug("Could not check Dropbox upload path", "path", folder, "error", err)
+		return
+	}
+	if !exists {
+		slog.Warn("!!! Dropbox upload_path is under /Apps, but that folder doesn't exist. "+
+			"If your Dropbox app has App folder access, paths are relative to the app's own folder, "+
+			"so books land in /Apps/<your app>"+folder+" and the Kobo won't see them. "+
+			"Create an app with Full Dropbox access and run kpub setup again, "+
+			"or link Dropbox on the Kobo first so it creates the folder !!!",
+			"upload_path", d.uploadPath, "folder", folder)
+	}
+}
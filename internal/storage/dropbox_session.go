@@ -0,0 +1,232 @@
+package storage
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// dropboxChunkSize is the default size of each upload_session/append_v2
+// request. Files at or below the chunk size use a single files/upload call
+// instead.
+const dropboxChunkSize = 8 << 20
+
+// uploadSession is the resume token of an in-flight chunked upload, saved
+// after every chunk so an interrupted upload can resume where it left off.
+// Size, SHA256 and Remote tie it to one version of one file. A restart
+// downloads the file again, so its modification time can't be used.
+type uploadSession struct {
+	SessionID string `json:"session_id"`
+	Offset    int64  `json:"offset"`
+	Size      int64  `json:"size"`
+	SHA256    string `json:"sha256"`
+	Remote    string `json:"remote"`
+}
+
+// loadSession decodes a resume token, returning nil if it is empty or
+// doesn't match the file being uploaded.
+func loadSession(token []byte, size int64, sum, remotePath string) *uploadSession {
+	if len(token) == 0 {
+		return nil
+	}
+	var sess uploadSession
+	if err := json.Unmarshal(token, &sess); err != nil || sess.SessionID == "" {
+		return nil
+	}
+	if sess.Size != size || sess.SHA256 != sum || sess.Remote != remotePath {
+		return nil
+	}
+	return &sess
+}
+
+// fileSHA256 returns the hex SHA-256 of the file at path.
+func fileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// saveSession hands the session's progress to save. Failing to save only
+// costs the ability to resume, so it is logged rather than returned.
+func saveSession(sess *uploadSession, save func([]byte) error) {
+	data, err := json.Marshal(sess)
+	if err == nil {
+		err = save(data)
+	}
+	if err != nil {
+		slog.Warn("Failed to save upload session state", "error", err)
+	}
+}
+
+// dropboxAPIError is a non-OK, non-401 response from the Dropbox API.
+type dropboxAPIError struct {
+	status string
+	body   string
+}
+
+func (e *dropboxAPIError) Error() string {
+	return fmt.Sprintf("dropbox API returned non-OK status: %s - Body: %s", e.status, e.body)
+}
+
+// isSessionExpired reports whether err means the upload session no longer
+// exists on Dropbox's side (they expire after about a week).
+func isSessionExpired(err error) bool {
+	var apiErr *dropboxAPIError
+	return errors.As(err, &apiErr) && strings.Contains(apiErr.body, "not_found")
+}
+
+// correctOffset extracts the server's offset from an incorrect_offset error,
+// which happens when a chunk was accepted but the crash came before we
+// saved the new offset.
+func correctOffset(err error) (int64, bool) {
+	var apiErr *dropboxAPIError
+	if !errors.As(err, &apiErr) || !strings.Contains(apiErr.body, "incorrect_offset") {
+		return 0, false
+	}
+	var resp struct {
+		Error struct {
+			CorrectOffset *int64 `json:"correct_offset"`
+		} `json:"error"`
+	}
+	if json.Unmarshal([]byte(apiErr.body), &resp) != nil || resp.Error.CorrectOffset == nil {
+		return 0, false
+	}
+	return *resp.Error.CorrectOffset, true
+}
+
+type sessionCursor struct {
+	SessionID string `json:"session_id"`
+	Offset    int64  `json:"offset"`
+}
+
+// doChunkedUpload uploads a large file through an upload session, resuming
+// the session in token if it belongs to the same file.
+func (d *DropboxUploader) doChunkedUpload(ctx context.Context, localPath, remotePath string, info os.FileInfo, token []byte, save func([]byte) error) error {
+	size := info.Size()
+	sum, err := fileSHA256(localPath)
+	if err != nil {
+		return fmt.Errorf("hashing file for upload: %w", err)
+	}
+	sess := loadSession(token, size, sum, remotePath)
+	resumed := sess != nil
+	if resumed {
+		slog.Info("Resuming Dropbox upload session", "file", remotePath, "offset", sess.Offset, "size", size)
+	} else {
+		body, err := d.contentRequest(ctx, "upload_session/start", map[string]bool{"close": false}, nil)
+		if err != nil {
+			return fmt.Errorf("starting upload session: %w", err)
+		}
+		var start struct {
+			SessionID string `json:"session_id"`
+		}
+		if err := json.Unmarshal(body, &start); err != nil {
+			return fmt.Errorf("parsing upload session start response: %w", err)
+		}
+		sess = &uploadSession{SessionID: start.SessionID, Size: size, SHA256: sum, Remote: remotePath}
+		saveSession(sess, save)
+	}
+
+	file, err := os.Open(localPath)
+	if err != nil {
+		return fmt.Errorf("failed to open file for upload: %w", err)
+	}
+	defer file.Close()
+
+	for sess.Offset < size {
+		n := min(d.chunkSize, size-sess.Offset)
+		arg := map[string]any{
+			"cursor": sessionCursor{SessionID: sess.SessionID, Offset: sess.Offset},
+			"close":  false,
+		}
+		_, err := d.contentRequest(ctx, "upload_session/append_v2", arg, io.NewSectionReader(file, sess.Offset, n))
+		if err != nil {
+			if offset, ok := correctOffset(err); ok {
+				slog.Info("Dropbox reported a different session offset, continuing from it", "offset", offset)
+				sess.Offset = offset
+				continue
+			}
+			if resumed && isSessionExpired(err) {
+				slog.Warn("Saved Dropbox upload session has expired, starting a fresh upload", "file", remotePath)
+				return d.doChunkedUpload(ctx, localPath, remotePath, info, nil, save)
+			}
+			return fmt.Errorf("appending to upload session at offset %d: %w", sess.Offset, err)
+		}
+
+		sess.Offset += n
+		saveSession(sess, save)
+	}
+
+	arg := map[string]any{
+		"cursor": sessionCursor{SessionID: sess.SessionID, Offset: sess.Offset},
+		"commit": dropboxAPIArg{Path: remotePath, Mode: "add"},
+	}
+	if _, err := d.contentRequest(ctx, "upload_session/finish", arg, nil); err != nil {
+		if resumed && isSessionExpired(err) {
+			return d.doChunkedUpload(ctx, localPath, remotePath, info, nil, save)
+		}
+		return fmt.Errorf("finishing upload session: %w", err)
+	}
+	return nil
+}
+
+// contentRequest POSTs body to a Dropbox content endpoint with arg in
+// the Dropbox-API-Arg header and returns the response body.
+func (d *DropboxUploader) contentRequest(ctx context.Context, endpoint string, arg any, body io.Reader) ([]byte, error) {
+	if body == nil {
+		body = http.NoBody
+	} else {
+		body = d.limiter.Reader(ctx, body)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.contentURL+"/2/files/"+endpoint, body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create %s request: %w", endpoint, err)
+	}
+
+	d.mu.Lock()
+	accessToken := d.tokens.AccessToken
+	d.mu.Unlock()
+
+	apiArgJSON, _ := json.Marshal(arg)
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("Dropbox-API-Arg", string(apiArgJSON))
+	d.setPathRoot(req)
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute %s request: %w", endpoint, err)
+	}
+	defer resp.Body.Close()
+
+	respBody, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		if err := permissionError(resp.StatusCode, string(respBody)); err != nil {
+			return nil, err
+		}
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return respBody, nil
+	case http.StatusUnauthorized:
+		return nil, &unauthorizedError{
+			msg: fmt.Sprintf("dropbox returned 401: %s", string(respBody)),
+		}
+	default:
+		return nil, &dropboxAPIError{status: resp.Status, body: string(respBody)}
+	}
+}
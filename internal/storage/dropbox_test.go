@@ -0,0 +1,499 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/spacesedan/kpub/internal/config"
+)
+
+// fakeDropbox is an httptest server with just enough of the Dropbox API for
+// the uploader: plain and session uploads, and token refresh. Committed
+// files are kept in files, by path.
+type fakeDropbox struct {
+	t         *testing.T
+	srv       *httptest.Server
+	tokenFile string
+
+	mu          sync.Mutex
+	accessToken string                                          // the bearer token requests must carry
+	sessions    map[string][]byte                               // open upload sessions, by ID
+	files       map[string][]byte                               // committed files, by path
+	calls       []string                                        // endpoints, in the order they were called
+	fail        func(endpoint string) (status int, body string) // optional
+}
+
+func newFakeDropbox(t *testing.T) *fakeDropbox {
+	f := &fakeDropbox{t: t, accessToken: "access", sessions: map[string][]byte{}, files: map[string][]byte{}}
+	f.srv = httptest.NewServer(http.HandlerFunc(f.serve))
+	t.Cleanup(f.srv.Close)
+	f.tokenFile = filepath.Join(t.TempDir(), "dropbox.json")
+	if err := os.WriteFile(f.tokenFile, []byte(`{"access_token": "access", "refresh_token": "refresh"}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+// uploader returns an uploader for f's account with the given chunk size.
+func (f *fakeDropbox) uploader(chunkSize string) *DropboxUploader {
+	f.t.Helper()
+	d, err := NewDropboxUploader(config.DropboxConfig{
+		AppKey: "key", AppSecret: "secret", TokenFile: f.tokenFile, UploadPath: "/Books", ChunkSize: chunkSize,
+	}, nil)
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	d.contentURL, d.apiURL, d.client = f.srv.URL, f.srv.URL, f.srv.Client()
+	return d
+}
+
+func (f *fakeDropbox) called(endpoint string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	n := 0
+	for _, c := range f.calls {
+		if c == endpoint {
+			n++
+		}
+	}
+	return n
+}
+
+func (f *fakeDropbox) serve(w http.ResponseWriter, r *http.Request) {
+	endpoint := strings.TrimPrefix(r.URL.Path, "/2/files/")
+	body, _ := io.ReadAll(r.Body)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls = append(f.calls, endpoint)
+
+	if endpoint == "/oauth2/token" {
+		f.accessToken = "refreshed"
+		fmt.Fprint(w, `{"access_token": "refreshed"}`)
+		return
+	}
+	if r.Header.Get("Authorization") != "Bearer "+f.accessToken {
+		http.Error(w, `{"error_summary": "expired_access_token/"}`, http.StatusUnauthorized)
+		return
+	}
+	if f.fail != nil {
+		if status, msg := f.fail(endpoint); status != 0 {
+			http.Error(w, msg, status)
+			return
+		}
+	}
+
+	var arg struct {
+		Path   string `json:"path"`
+		Cursor struct {
+			SessionID string `json:"session_id"`
+			Offset    int    `json:"offset"`
+		} `json:"cursor"`
+		Commit struct {
+			Path string `json:"path"`
+		} `json:"commit"`
+	}
+	if err := json.Unmarshal([]byte(r.Header.Get("Dropbox-API-Arg")), &arg); err != nil {
+		http.Error(w, "bad Dropbox-API-Arg", http.StatusBadRequest)
+		return
+	}
+	switch endpoint {
+	case "upload":
+		f.files[arg.Path] = body
+		fmt.Fprint(w, `{}`)
+	case "upload_session/start":
+		id := fmt.Sprintf("session-%d", len(f.sessions)+1)
+		f.sessions[id] = body
+		fmt.Fprintf(w, `{"session_id": %q}`, id)
+	case "upload_session/append_v2", "upload_session/finish":
+		data, ok := f.sessions[arg.Cursor.SessionID]
+		switch {
+		case !ok:
+			http.Error(w, `{"error_summary": "not_found/"}`, http.StatusConflict)
+		case arg.Cursor.Offset != len(data):
+			w.WriteHeader(http.StatusConflict)
+			fmt.Fprintf(w, `{"error_summary": "incorrect_offset/", "error": {".tag": "incorrect_offset", "correct_offset": %d}}`, len(data))
+		case endpoint == "upload_session/finish":
+			f.files[arg.Commit.Path] = append(data, body...)
+			delete(f.sessions, arg.Cursor.SessionID)
+			fmt.Fprint(w, `{}`)
+		default:
+			f.sessions[arg.Cursor.SessionID] = append(data, body...)
+			fmt.Fprint(w, `{}`)
+		}
+	default:
+		http.Error(w, "unexpected endpoint "+endpoint, http.StatusNotFound)
+	}
+}
+
+// TestDropboxResumeAfterRestart interrupts a chunked upload, then writes the
+// same bytes out again, as a restart that downloads the file again would,
+// and checks that the upload continues from the saved session.
+func TestDropboxResumeAfterRestart(t *testing.T) {
+	f := newFakeDropbox(t)
+	d := f.uploader("4B")
+	content := []byte("0123456789abcdef")
+	local := filepath.Join(t.TempDir(), "book.epub")
+	if err := os.WriteFile(local, content, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	appends := 0
+	f.fail = func(endpoint string) (int, string) {
+		if endpoint == "upload_session/append_v2" {
+			if appends++; appends == 3 {
+				return http.StatusInternalServerError, "connection lost"
+			}
+		}
+		return 0, ""
+	}
+	var token []byte
+	save := func(t []byte) error { token = t; return nil }
+	if err := d.UploadResumable(context.Background(), local, "book.epub", nil, save); err == nil {
+		t.Fatal("interrupted upload succeeded")
+	}
+	if token == nil {
+		t.Fatal("no resume token was saved")
+	}
+	f.fail = nil
+
+	if err := os.WriteFile(local, content, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Hour)
+	if err := os.Chtimes(local, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.uploader("4B").UploadResumable(context.Background(), local, "book.epub", token, save); err != nil {
+		t.Fatalf("resumed upload: %v", err)
+	}
+
+	if n := f.called("upload_session/start"); n != 1 {
+		t.Errorf("upload sessions started = %d, want 1: the saved session wasn't resumed", n)
+	}
+	// Two chunks went up before the failed third; resuming sends the last
+	// two, without repeating the first two.
+	if n := f.called("upload_session/append_v2"); n != 5 {
+		t.Errorf("appends = %d, want 5", n)
+	}
+	if got := f.files["/Books/book.epub"]; !bytes.Equal(got, content) {
+		t.Errorf("uploaded %q, want %q", got, content)
+	}
+}
+
+// TestDropboxNoResumeAfterChange checks that a saved session isn't used
+// for a file whose content changed, even at the same size.
+func TestDropboxNoResumeAfterChange(t *testing.T) {
+	f := newFakeDropbox(t)
+	local := filepath.Join(t.TempDir(), "book.epub")
+	if err := os.WriteFile(local, []byte("0123456789abcdef"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	f.fail = func(endpoint string) (int, string) {
+		if endpoint == "upload_session/append_v2" {
+			return http.StatusInternalServerError, "connection lost"
+		}
+		return 0, ""
+	}
+	var token []byte
+	save := func(t []byte) error { token = t; return nil }
+	f.uploader("4B").UploadResumable(context.Background(), local, "book.epub", nil, save)
+	f.fail = nil
+
+	changed := []byte("fedcba9876543210")
+	if err := os.WriteFile(local, changed, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.uploader("4B").UploadResumable(context.Background(), local, "book.epub", token, save); err != nil {
+		t.Fatalf("upload: %v", err)
+	}
+	if n := f.called("upload_session/start"); n != 2 {
+		t.Errorf("upload sessions started = %d, want 2: a session for other content was resumed", n)
+	}
+	if got := f.files["/Books/book.epub"]; !bytes.Equal(got, changed) {
+		t.Errorf("uploaded %q, want %q", got, changed)
+	}
+}
+
+func TestDropboxRefreshRotatesToken(t *testing.T) {
+	tokenFile := filepath.Join(t.TempDir(), "dropbox.json")
+	initial := `{"access_token": "old-access", "refresh_token": "old-refresh"}`
+	if err := os.WriteFile(tokenFile, []byte(initial), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/oauth2/token" {
+			t.Errorf("request to %s, want /oauth2/token", r.URL.Path)
+		}
+		if err := r.ParseForm(); err != nil {
+			t.Fatal(err)
+		}
+		if got := r.PostForm.Get("grant_type"); got != "refresh_token" {
+			t.Errorf("grant_type = %q, want refresh_token", got)
+		}
+		if got := r.PostForm.Get("refresh_token"); got != "old-refresh" {
+			t.Errorf("refresh_token = %q, want old-refresh", got)
+		}
+		if key, secret, _ := r.BasicAuth(); key != "key" || secret != "secret" {
+			t.Errorf("basic auth = %q, %q, want key, secret", key, secret)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"access_token": "new-access", "refresh_token": "new-refresh", "token_type": "bearer", "expires_in": 14400}`))
+	}))
+	defer srv.Close()
+
+	d, err := NewDropboxUploader(config.DropboxConfig{AppKey: "key", AppSecret: "secret", TokenFile: tokenFile}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.apiURL, d.client = srv.URL, srv.Client()
+
+	var hookErr error
+	hookCalls := 0
+	d.SetRefreshHook(func(err error, failures int) { hookErr, hookCalls = err, hookCalls+1 })
+
+	if err := d.refreshToken(); err != nil {
+		t.Fatalf("refreshToken: %v", err)
+	}
+	if hookCalls != 1 || hookErr != nil {
+		t.Errorf("refresh hook called %d times with %v, want once with nil", hookCalls, hookErr)
+	}
+	if d.tokens.AccessToken != "new-access" || d.tokens.RefreshToken != "new-refresh" {
+		t.Errorf("tokens in use = %+v, want the refreshed ones", d.tokens)
+	}
+
+	data, err := os.ReadFile(tokenFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var saved dropboxTokens
+	if err := json.Unmarshal(data, &saved); err != nil {
+		t.Fatalf("token file isn't valid JSON: %v\n%s", err, data)
+	}
+	if saved.AccessToken != "new-access" || saved.RefreshToken != "new-refresh" {
+		t.Errorf("saved tokens = %+v, want the rotated refresh token persisted", saved)
+	}
+	if entries, _ := os.ReadDir(filepath.Dir(tokenFile)); len(entries) != 1 {
+		t.Errorf("files next to the token file = %v, want just the token file", entries)
+	}
+}
+
+func TestDropboxRefreshKeepsTokenWhenNotRotated(t *testing.T) {
+	tokenFile := filepath.Join(t.TempDir(), "dropbox.json")
+	if err := os.WriteFile(tokenFile, []byte(`{"access_token": "old-access", "refresh_token": "old-refresh"}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"access_token": "new-access", "token_type": "bearer"}`))
+	}))
+	defer srv.Close()
+
+	d, err := NewDropboxUploader(config.DropboxConfig{TokenFile: tokenFile}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.apiURL, d.client = srv.URL, srv.Client()
+	if err := d.refreshToken(); err != nil {
+		t.Fatalf("refreshToken: %v", err)
+	}
+
+	saved, err := loadTokens(tokenFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.AccessToken != "new-access" || saved.RefreshToken != "old-refresh" {
+		t.Errorf("saved tokens = %+v, want new access token and the old refresh token", saved)
+	}
+}
+
+// TestDropboxUploadsToSamePathSerialized uploads the same path through two
+// uploaders for one account, as chats that differ only in other Dropbox
+// settings have, and checks that the two upload sessions don't overlap.
+func TestDropboxUploadsToSamePathSerialized(t *testing.T) {
+	f := newFakeDropbox(t)
+	f.fail = func(endpoint string) (int, string) {
+		if endpoint == "upload_session/append_v2" {
+			time.Sleep(5 * time.Millisecond) // leave room for the other upload to cut in
+		}
+		return 0, ""
+	}
+	local := filepath.Join(t.TempDir(), "book.epub")
+	if err := os.WriteFile(local, []byte("0123456789abcdef"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for _, d := range []*DropboxUploader{f.uploader("4B"), f.uploader("8B")} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := d.Upload(context.Background(), local, "Book.epub"); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	open := 0
+	for _, c := range f.calls {
+		switch c {
+		case "upload_session/start":
+			if open++; open > 1 {
+				t.Fatalf("two upload sessions to the same path at once: %q", f.calls)
+			}
+		case "upload_session/finish":
+			open--
+		}
+	}
+}
+
+func writeBook(t *testing.T, content string) string {
+	t.Helper()
+	p := filepath.Join(t.TempDir(), "book.epub")
+	if err := os.WriteFile(p, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestDropboxUpload(t *testing.T) {
+	tests := []struct {
+		name      string
+		chunkSize string
+		endpoints map[string]int // calls expected per endpoint
+	}{
+		{
+			name:      "small file in one request",
+			chunkSize: "",
+			endpoints: map[string]int{"upload": 1, "upload_session/start": 0},
+		},
+		{
+			name:      "large file in an upload session",
+			chunkSize: "4B",
+			endpoints: map[string]int{"upload": 0, "upload_session/start": 1, "upload_session/append_v2": 3, "upload_session/finish": 1},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFakeDropbox(t)
+			content := "0123456789"
+			if err := f.uploader(tt.chunkSize).Upload(context.Background(), writeBook(t, content), "Sub/book.epub"); err != nil {
+				t.Fatalf("Upload: %v", err)
+			}
+			if got := string(f.files["/Books/Sub/book.epub"]); got != content {
+				t.Errorf("uploaded %q, want %q (files: %q)", got, content, f.files)
+			}
+			for endpoint, want := range tt.endpoints {
+				if got := f.called(endpoint); got != want {
+					t.Errorf("%s called %d times, want %d", endpoint, got, want)
+				}
+			}
+		})
+	}
+}
+
+// TestDropboxUploadRefreshesExpiredToken checks that a 401 refreshes the
+// access token, saves it, and retries the upload with it.
+func TestDropboxUploadRefreshesExpiredToken(t *testing.T) {
+	for _, chunkSize := range []string{"", "4B"} {
+		t.Run("chunk_size="+chunkSize, func(t *testing.T) {
+			f := newFakeDropbox(t)
+			d := f.uploader(chunkSize)
+			f.accessToken = "expired-elsewhere" // the uploader's token is no longer valid
+
+			var refreshes int
+			d.SetRefreshHook(func(err error, failures int) {
+				refreshes++
+				if err != nil {
+					t.Errorf("refresh failed: %v", err)
+				}
+			})
+			if err := d.Upload(context.Background(), writeBook(t, "0123456789"), "book.epub"); err != nil {
+				t.Fatalf("Upload: %v", err)
+			}
+			if refreshes != 1 || f.called("/oauth2/token") != 1 {
+				t.Errorf("refreshes = %d, token requests = %d, want 1 each", refreshes, f.called("/oauth2/token"))
+			}
+			if got := string(f.files["/Books/book.epub"]); got != "0123456789" {
+				t.Errorf("uploaded %q after the retry", got)
+			}
+			if saved, err := loadTokens(f.tokenFile); err != nil || saved.AccessToken != "refreshed" {
+				t.Errorf("saved tokens = %+v, %v, want the refreshed access token", saved, err)
+			}
+		})
+	}
+}
+
+// TestDropboxUploadErrorBody checks that an error from Dropbox comes back
+// as a Go error carrying the status and the body, without a retry.
+func TestDropboxUploadErrorBody(t *testing.T) {
+	tests := []struct {
+		name      string
+		chunkSize string
+		endpoint  string
+		status    int
+		body      string
+		want      []string
+	}{
+		{
+			name:     "upload conflict",
+			endpoint: "upload",
+			status:   http.StatusConflict,
+			body:     `{"error_summary": "path/insufficient_space/..."}`,
+			want:     []string{"409", "insufficient_space"},
+		},
+		{
+			name:      "session append failure",
+			chunkSize: "4B",
+			endpoint:  "upload_session/append_v2",
+			status:    http.StatusInternalServerError,
+			body:      "internal error",
+			want:      []string{"offset 0", "500", "internal error"},
+		},
+		{
+			name:     "missing scope explains how to fix it",
+			endpoint: "upload",
+			status:   http.StatusUnauthorized,
+			body:     `{"error_summary": "missing_scope/", "error": {".tag": "missing_scope", "required_scope": "files.content.write"}}`,
+			want:     []string{"files.content.write permission", "kpub setup"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFakeDropbox(t)
+			f.fail = func(endpoint string) (int, string) {
+				if endpoint == tt.endpoint {
+					return tt.status, tt.body
+				}
+				return 0, ""
+			}
+			err := f.uploader(tt.chunkSize).Upload(context.Background(), writeBook(t, "0123456789"), "book.epub")
+			if err == nil {
+				t.Fatal("Upload succeeded")
+			}
+			for _, w := range tt.want {
+				if !strings.Contains(err.Error(), w) {
+					t.Errorf("error %q doesn't mention %q", err, w)
+				}
+			}
+			if n := f.called(tt.endpoint); n != 1 {
+				t.Errorf("%s called %d times, want 1: errors other than an expired token aren't retried", tt.endpoint, n)
+			}
+			if len(f.files) != 0 {
+				t.Errorf("files committed after a failed upload: %q", f.files)
+			}
+		})
+	}
+}
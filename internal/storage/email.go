@@ -0,0 +1,202 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"mime"
+	"net"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spacesedan/kpub/internal/config"
+)
+
+// kindleMaxAttachment is Amazon's per-document limit for Send to Kindle
+// by email.
+const kindleMaxAttachment = 50 << 20
+
+// attachmentTypes are the types of formats Send to Kindle accepts that the
+// system's MIME table may not know.
+var attachmentTypes = map[string]string{
+	".epub": "application/epub+zip",
+	".mobi": "application/x-mobipocket-ebook",
+	".azw":  "application/vnd.amazon.ebook",
+	".azw3": "application/vnd.amazon.ebook",
+	".pdf":  "application/pdf",
+	".txt":  "text/plain; charset=utf-8",
+	".rtf":  "application/rtf",
+	".doc":  "application/msword",
+	".docx": "application/vnd.openxmlformats-officedocument.wordprocessingml.document",
+}
+
+// attachmentType returns the Content-Type to attach a file named name with.
+func attachmentType(name string) string {
+	ext := filepath.Ext(name)
+	if t := mime.TypeByExtension(ext); t != "" {
+		return t
+	}
+	if t, ok := attachmentTypes[strings.ToLower(ext)]; ok {
+		return t
+	}
+	return "application/octet-stream"
+}
+
+// EmailUploader delivers files as email attachments, typically to a
+// Send to Kindle address.
+type EmailUploader struct {
+	cfg config.EmailConfig
+}
+
+// NewEmailUploader returns an uploader that sends files via cfg's SMTP server.
+func NewEmailUploader(cfg config.EmailConfig) (*EmailUploader, error) {
+	if cfg.SMTPHost == "" || cfg.From == "" || cfg.To == "" {
+		return nil, fmt.Errorf("email storage requires smtp_host, from, and to")
+	}
+	if cfg.SMTPPort == 0 {
+		cfg.SMTPPort = 587
+	}
+	return &EmailUploader{cfg: cfg}, nil
+}
+
+// Upload emails localPath as an attachment named remoteName. The subject is
+// "convert" so Amazon converts the book for Kindle.
+func (e *EmailUploader) Upload(ctx context.Context, localPath string, remoteName string) error {
+	info, err := os.Stat(localPath)
+	if err != nil {
+		return fmt.Errorf("failed to stat file for upload: %w", err)
+	}
+	if info.Size() > kindleMaxAttachment {
+		return fmt.Errorf("%s is %d MB, over the %d MB Send to Kindle limit",
+			remoteName, info.Size()>>20, kindleMaxAttachment>>20)
+	}
+
+	data, err := os.ReadFile(localPath)
+	if err != nil {
+		return fmt.Errorf("failed to read file for upload: %w", err)
+	}
+
+	msg, err := e.buildMessage(remoteName, data)
+	if err != nil {
+		return err
+	}
+
+	slog.Info("Emailing file", "to", e.cfg.To, "file", remoteName)
+	if err := e.send(ctx, msg); err != nil {
+		return fmt.Errorf("sending email: %w", err)
+	}
+	slog.Info("Successfully emailed file", "to", e.cfg.To, "file", remoteName)
+	return nil
+}
+
+// buildMessage returns a MIME message with data attached as name.
+func (e *EmailUploader) buildMessage(name string, data []byte) ([]byte, error) {
+	var b [12]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return nil, fmt.Errorf("generating MIME boundary: %w", err)
+	}
+	boundary := "kpub-" + hex.EncodeToString(b[:])
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\r\n", e.cfg.From)
+	fmt.Fprintf(&buf, "To: %s\r\n", e.cfg.To)
+	fmt.Fprintf(&buf, "Subject: convert\r\n")
+	fmt.Fprintf(&buf, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&buf, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&buf, "Content-Type: multipart/mixed; boundary=%q\r\n\r\n", boundary)
+
+	fmt.Fprintf(&buf, "--%s\r\n", boundary)
+	fmt.Fprintf(&buf, "Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	fmt.Fprintf(&buf, "Sent by kpub.\r\n")
+
+	disposition := mime.FormatMediaType("attachment", map[string]string{"filename": name})
+	fmt.Fprintf(&buf, "--%s\r\n", boundary)
+	fmt.Fprintf(&buf, "Content-Type: %s\r\n", attachmentType(name))
+	fmt.Fprintf(&buf, "Content-Transfer-Encoding: base64\r\n")
+	fmt.Fprintf(&buf, "Content-Disposition: %s\r\n\r\n", disposition)
+
+	encoded := base64.StdEncoding.EncodeToString(data)
+	for len(encoded) > 76 {
+		buf.WriteString(encoded[:76] + "\r\n")
+		encoded = encoded[76:]
+	}
+	buf.WriteString(encoded + "\r\n")
+	fmt.Fprintf(&buf, "--%s--\r\n", boundary)
+
+	return buf.Bytes(), nil
+}
+
+// send delivers msg over SMTP. Port 465 uses implicit TLS; anything else
+// upgrades with STARTTLS when the server offers it. The exchange is bound to
+// ctx: its deadline applies to the connection, and cancelling it breaks off
+// a server that has stopped responding.
+func (e *EmailUploader) send(ctx context.Context, msg []byte) (err error) {
+	addr := net.JoinHostPort(e.cfg.SMTPHost, strconv.Itoa(e.cfg.SMTPPort))
+	tlsConfig := &tls.Config{ServerName: e.cfg.SMTPHost}
+
+	dialer := &net.Dialer{Timeout: 30 * time.Second}
+	var conn net.Conn
+	if e.cfg.SMTPPort == 465 {
+		conn, err = (&tls.Dialer{NetDialer: dialer, Config: tlsConfig}).DialContext(ctx, "tcp", addr)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", addr)
+	}
+	if err != nil {
+		return fmt.Errorf("connecting to %s: %w", addr, err)
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	stop := context.AfterFunc(ctx, func() { conn.SetDeadline(time.Now()) })
+	defer func() {
+		if !stop() && err != nil {
+			err = fmt.Errorf("%w (%w)", ctx.Err(), err)
+		}
+	}()
+
+	client, err := smtp.NewClient(conn, e.cfg.SMTPHost)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if ok, _ := client.Extension("STARTTLS"); ok && e.cfg.SMTPPort != 465 {
+		if err := client.StartTLS(tlsConfig); err != nil {
+			return fmt.Errorf("starting TLS: %w", err)
+		}
+	}
+	if e.cfg.Username != "" {
+		auth := smtp.PlainAuth("", e.cfg.Username, e.cfg.Password, e.cfg.SMTPHost)
+		if err := client.Auth(auth); err != nil {
+			return fmt.Errorf("authenticating: %w", err)
+		}
+	}
+
+	if err := client.Mail(e.cfg.From); err != nil {
+		return err
+	}
+	if err := client.Rcpt(e.cfg.To); err != nil {
+		return err
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
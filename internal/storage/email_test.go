@@ -0,0 +1,89 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"mime"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spacesedan/kpub/internal/config"
+)
+
+func TestAttachmentType(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"book.epub", "application/epub+zip"},
+		{"book.kepub.epub", "application/epub+zip"},
+		{"Book.EPUB", "application/epub+zip"},
+		{"book.pdf", "application/pdf"},
+		{"book", "application/octet-stream"},
+		{"book.unknownext", "application/octet-stream"},
+	}
+	for _, tt := range tests {
+		got := attachmentType(tt.name)
+		// The system MIME table may add parameters; only the media type
+		// has to match.
+		if mt, _, err := mime.ParseMediaType(got); err != nil || mt != strings.Split(tt.want, ";")[0] {
+			t.Errorf("attachmentType(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestEmailMessageAttachmentType(t *testing.T) {
+	e, err := NewEmailUploader(config.EmailConfig{SMTPHost: "localhost", From: "a@example.com", To: "b@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, err := e.buildMessage("book.pdf", []byte("%PDF"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(msg), "Content-Type: application/pdf\r\n") {
+		t.Errorf("PDF attachment not sent as application/pdf:\n%s", msg)
+	}
+}
+
+// TestEmailSendCancelled talks to a server that accepts the connection but
+// never answers. Cancelling the context must end the exchange.
+func TestEmailSendCancelled(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	host, port, _ := net.SplitHostPort(ln.Addr().String())
+	p, _ := strconv.Atoi(port)
+	e, err := NewEmailUploader(config.EmailConfig{SMTPHost: host, SMTPPort: p, From: "a@example.com", To: "b@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	done := make(chan error, 1)
+	go func() { done <- e.send(ctx, []byte("msg")) }()
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("send = %v, want context.Canceled", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("send didn't return after the context was cancelled")
+	}
+}
@@ -0,0 +1,49 @@
+package storage
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/spacesedan/kpub/internal/config"
+)
+
+// RemoteChecker is implemented by backends that can look up a file already
+// at the destination, so the pipeline can skip uploading it again.
+type RemoteChecker interface {
+	// RemoteHash returns the content hash of remoteName at the destination,
+	// and false if there is no file there.
+	RemoteHash(ctx context.Context, remoteName string) (hash string, exists bool, err error)
+
+	// LocalHash hashes localPath the same way RemoteHash's hash is computed.
+	LocalHash(localPath string) (string, error)
+}
+
+// SkipExisting reports whether uploading localPath as remoteName can be
+// skipped under policy (one of the config.IfExists values). Backends that
+// aren't RemoteCheckers never skip, and a failed lookup is logged and the
+// upload goes ahead. Lookups don't count towards a Limit.
+func SkipExisting(ctx context.Context, u Uploader, policy, localPath, remoteName string) bool {
+	c, ok := unwrap(u).(RemoteChecker)
+	if !ok || (policy != config.IfExistsSkip && policy != config.IfExistsSkipIdentical) {
+		return false
+	}
+
+	remote, exists, err := c.RemoteHash(ctx, remoteName)
+	if err != nil {
+		slog.Warn("Could not check for an existing remote file, uploading anyway", "file", remoteName, "error", err)
+		return false
+	}
+	if !exists {
+		return false
+	}
+	if policy == config.IfExistsSkip {
+		return true
+	}
+
+	local, err := c.LocalHash(localPath)
+	if err != nil {
+		slog.Warn("Could not hash file, uploading anyway", "file", localPath, "error", err)
+		return false
+	}
+	return local == remote
+}
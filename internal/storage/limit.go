@@ -0,0 +1,64 @@
+package storage
+
+import "context"
+
+// limitedUploader caps how many uploads run at once through an Uploader.
+type limitedUploader struct {
+	Uploader
+	slots chan struct{}
+}
+
+var _ ResumableUploader = (*limitedUploader)(nil)
+
+// Limit returns an Uploader that runs at most n uploads through u at once,
+// making the rest wait their turn. A zero or negative n returns u itself.
+// Each backend gets its own limit, so a strict one doesn't hold up others.
+func Limit(u Uploader, n int) Uploader {
+	if n <= 0 {
+		return u
+	}
+	return &limitedUploader{Uploader: u, slots: make(chan struct{}, n)}
+}
+
+func (l *limitedUploader) acquire(ctx context.Context) (release func(), err error) {
+	select {
+	case l.slots <- struct{}{}:
+		return func() { <-l.slots }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Upload waits for a free slot, then uploads.
+func (l *limitedUploader) Upload(ctx context.Context, localPath, remoteName string) error {
+	release, err := l.acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+	return l.Uploader.Upload(ctx, localPath, remoteName)
+}
+
+// UploadResumable keeps a resumable backend resumable behind the limit.
+// Other backends ignore the token and upload whole.
+func (l *limitedUploader) UploadResumable(ctx context.Context, localPath, remoteName string, token []byte, save func([]byte) error) error {
+	r, ok := l.Uploader.(ResumableUploader)
+	if !ok {
+		return l.Upload(ctx, localPath, remoteName)
+	}
+	release, err := l.acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+	return r.UploadResumable(ctx, localPath, remoteName, token, save)
+}
+
+// unwrap returns the Uploader behind any limit, for capabilities other than
+// uploading, such as RemoteChecker.
+func unwrap(u Uploader) Uploader {
+	if l, ok := u.(*limitedUploader); ok {
+		return l.Uploader
+	}
+	return u
+}
@@ -0,0 +1,89 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"log/slog"
+	"path"
+	"strings"
+	"unicode/utf8"
+)
+
+// MaxNameBytes is the longest single file or folder name most filesystems,
+// and Dropbox, accept.
+const MaxNameBytes = 255
+
+// maxExtBytes bounds what ShortenName treats as an extension; anything
+// longer after the last dot is just part of a long name.
+const maxExtBytes = 16
+
+// ShortenName returns name if it is at most max bytes. Otherwise it cuts the
+// part before the extension and adds a short hash of the full name, so two
+// long names that only differ at the end stay distinct:
+// "A Very Long Title… (Book 1).epub" becomes "A Very Long Ti-1a2b3c4d.epub".
+func ShortenName(name string, max int) string {
+	if len(name) <= max {
+		return name
+	}
+	ext := nameExt(name)
+	sum := sha256.Sum256([]byte(name))
+	suffix := "-" + hex.EncodeToString(sum[:4]) + ext
+
+	keep := max - len(suffix)
+	if keep <= 0 {
+		return truncateUTF8(name, max)
+	}
+	return truncateUTF8(strings.TrimSuffix(name, ext), keep) + suffix
+}
+
+// Disambiguate adds tag to name before its extension, e.g. "book.kepub.epub"
+// becomes "book (1a2b3c4d).kepub.epub", shortening it if needed to stay
+// within MaxNameBytes.
+func Disambiguate(name, tag string) string {
+	ext := nameExt(name)
+	return ShortenName(strings.TrimSuffix(name, ext)+" ("+tag+")"+ext, MaxNameBytes)
+}
+
+// nameExt returns name's extension, counting ".kepub.epub" as one.
+func nameExt(name string) string {
+	if strings.HasSuffix(strings.ToLower(name), ".kepub.epub") {
+		return name[len(name)-len(".kepub.epub"):]
+	}
+	ext := path.Ext(name)
+	if len(ext) > maxExtBytes {
+		return ""
+	}
+	return ext
+}
+
+// truncateUTF8 cuts s to at most n bytes without splitting a character.
+func truncateUTF8(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
+
+// fitRemotePath shortens the last element of the slash-separated path p so
+// it is at most maxName bytes and p as a whole at most maxPath; either limit
+// may be 0 for none. Backends call it on every remote name so uploads and
+// existence checks agree, and it logs when a name changes.
+func fitRemotePath(p string, maxName, maxPath int) string {
+	dir, name := path.Split(p)
+	limit := len(name)
+	if maxName > 0 && limit > maxName {
+		limit = maxName
+	}
+	if maxPath > 0 && len(dir)+limit > maxPath {
+		limit = maxPath - len(dir)
+	}
+	short := ShortenName(name, limit)
+	if short == name {
+		return p
+	}
+	slog.Warn("Remote file name is too long, shortening it", "original", name, "name", short)
+	return dir + short
+}
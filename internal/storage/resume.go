@@ -0,0 +1,108 @@
+package storage
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// ResumableUploader is implemented by backends that can continue an
+// interrupted upload instead of starting over. The pipeline prefers it over
+// Upload when a backend provides it.
+type ResumableUploader interface {
+	Uploader
+
+	// UploadResumable uploads like Upload. token is what an earlier attempt
+	// for the same file passed to save, or nil. The backend calls save
+	// whenever it has progress worth keeping, and must ignore a token it
+	// can't use (e.g. because the file changed) and upload from scratch.
+	UploadResumable(ctx context.Context, localPath, remoteName string, token []byte, save func(token []byte) error) error
+}
+
+// ResumeStore keeps resume tokens for ResumableUploaders on disk, one file
+// per upload, so an upload interrupted by a crash or restart picks up where
+// it left off.
+type ResumeStore struct {
+	dir string
+}
+
+// NewResumeStore returns a store that keeps tokens in dir, creating it on
+// first use.
+func NewResumeStore(dir string) *ResumeStore {
+	return &ResumeStore{dir: dir}
+}
+
+// Upload uploads localPath through u, resuming from a saved token when u is a
+// ResumableUploader. Other backends, or a nil store, use plain Upload. The
+// token is deleted once the upload succeeds.
+func (s *ResumeStore) Upload(ctx context.Context, u Uploader, localPath, remoteName string) error {
+	r, ok := u.(ResumableUploader)
+	if s == nil || !ok {
+		return u.Upload(ctx, localPath, remoteName)
+	}
+
+	path := s.tokenPath(localPath, remoteName)
+	token, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		slog.Warn("Could not read upload resume state, starting over", "path", path, "error", err)
+	}
+
+	save := func(token []byte) error {
+		if err := os.MkdirAll(s.dir, 0o750); err != nil {
+			return fmt.Errorf("creating upload state directory: %w", err)
+		}
+		err := WriteFileAtomic(path, 0o600, func(w io.Writer) error {
+			_, err := w.Write(token)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("writing upload state: %w", err)
+		}
+		return nil
+	}
+
+	if err := r.UploadResumable(ctx, localPath, remoteName, token, save); err != nil {
+		return err
+	}
+	os.Remove(path)
+	return nil
+}
+
+// SessionLifetime is how long Dropbox keeps an upload session open, and so
+// the longest a resume token can still be of use.
+const SessionLifetime = 7 * 24 * time.Hour
+
+// Stale returns the token files in the store last written more than age
+// ago. Their uploads either finished without cleaning up or can no longer
+// be resumed.
+func (s *ResumeStore) Stale(age time.Duration) ([]string, error) {
+	entries, err := os.ReadDir(s.dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading upload state directory: %w", err)
+	}
+	var stale []string
+	for _, e := range entries {
+		info, err := e.Info()
+		if err != nil || e.IsDir() || time.Since(info.ModTime()) < age {
+			continue
+		}
+		stale = append(stale, filepath.Join(s.dir, e.Name()))
+	}
+	return stale, nil
+}
+
+// tokenPath names the token file after a hash of the upload, since local and
+// remote paths can't be used as file names directly.
+func (s *ResumeStore) tokenPath(localPath, remoteName string) string {
+	sum := sha256.Sum256([]byte(localPath + "\x00" + remoteName))
+	return filepath.Join(s.dir, hex.EncodeToString(sum[:16])+".json")
+}
@@ -0,0 +1,22 @@
+package storage
+
+import "context"
+
+// RetryFunc is told when a backend tries an upload again: attempt is the
+// attempt about to start, counting from 1, out of max.
+type RetryFunc func(attempt, max int)
+
+type retryKey struct{}
+
+// WithRetry returns a context that reports upload retries to fn, so the
+// caller can tell the user an upload is being tried again.
+func WithRetry(ctx context.Context, fn RetryFunc) context.Context {
+	return context.WithValue(ctx, retryKey{}, fn)
+}
+
+// reportRetry calls the RetryFunc attached with WithRetry, if any.
+func reportRetry(ctx context.Context, attempt, max int) {
+	if fn, _ := ctx.Value(retryKey{}).(RetryFunc); fn != nil {
+		fn(attempt, max)
+	}
+}
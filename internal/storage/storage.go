@@ -3,8 +3,11 @@ package storage
 import (
 	"context"
 	"fmt"
+	"net/http"
+	"time"
 
 	"github.com/spacesedan/kpub/internal/config"
+	"github.com/spacesedan/kpub/internal/throttle"
 )
 
 // Uploader uploads a local file to remote storage.
@@ -12,11 +15,29 @@ type Uploader interface {
 	Upload(ctx context.Context, localPath string, remoteName string) error
 }
 
-// NewUploader creates an Uploader from the given storage config.
-func NewUploader(cfg config.StorageConfig) (Uploader, error) {
+// HTTPClient is used by uploaders for every outbound request; tests may
+// replace it before creating an uploader. Uploads are large and may be
+// throttled, so rather than capping the whole request it bounds the wait for
+// Dropbox to answer once the body is sent. Connecting and the TLS handshake
+// keep the default transport's limits.
+var HTTPClient = &http.Client{Transport: newTransport()}
+
+func newTransport() *http.Transport {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.ResponseHeaderTimeout = 2 * time.Minute
+	return t
+}
+
+// NewUploader creates an Uploader from the given storage config. Uploads are
+// throttled by limiter, which may be nil for unlimited.
+func NewUploader(cfg config.StorageConfig, limiter *throttle.Limiter) (Uploader, error) {
 	switch cfg.Type {
 	case "dropbox":
-		return NewDropboxUploader(cfg.Dropbox)
+		return NewDropboxUploader(cfg.Dropbox, limiter)
+	case "email":
+		return NewEmailUploader(cfg.Email)
+	case "b2":
+		return NewB2Uploader(cfg.B2, limiter)
 	default:
 		return nil, fmt.Errorf("unsupported storage type: %q", cfg.Type)
 	}
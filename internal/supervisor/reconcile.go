@@ -0,0 +1,96 @@
+package supervisor
+
+import (
+	"reflect"
+	"slices"
+
+	"github.com/spacesedan/kpub/internal/config"
+)
+
+// Reconcile compares two configs by chat handle (see config.HandleKey).
+// added, changed and updated hold the new resolved configs, removed the old
+// ones. changed chats must be re-added, while updated ones only changed
+// settings that monitor.UpdateChat can apply in place. Each list follows the
+// order of the chats in its config, so the result is deterministic. Disabled
+// chats count as absent, so disabling a chat removes it and enabling it adds
+// it. A handle whose case changed is updated, so the new case is shown. Of
+// handles that share a key within one config, which Load rejects, only the
+// first counts.
+func Reconcile(old, new *config.Config) (added, removed, changed, updated []config.ResolvedChat) {
+	oldChats := make(map[string]config.ResolvedChat, len(old.Chats))
+	for _, chatCfg := range old.Chats {
+		resolved := config.ResolvedChatConfig(old, chatCfg)
+		if _, dup := oldChats[config.HandleKey(resolved.Handle)]; resolved.Enabled && !dup {
+			oldChats[config.HandleKey(resolved.Handle)] = resolved
+		}
+	}
+
+	newHandles := make(map[string]bool, len(new.Chats))
+	for _, chatCfg := range new.Chats {
+		resolved := config.ResolvedChatConfig(new, chatCfg)
+		if !resolved.Enabled || newHandles[config.HandleKey(resolved.Handle)] {
+			continue
+		}
+		newHandles[config.HandleKey(resolved.Handle)] = true
+
+		oldResolved, exists := oldChats[config.HandleKey(resolved.Handle)]
+		switch {
+		case !exists:
+			added = append(added, resolved)
+		case chatConfigEqual(oldResolved, resolved):
+		case backfillEqual(oldResolved, resolved):
+			updated = append(updated, resolved)
+		default:
+			changed = append(changed, resolved)
+		}
+	}
+
+	for _, chatCfg := range old.Chats {
+		key := config.HandleKey(chatCfg.Handle)
+		if oldResolved, ok := oldChats[key]; ok && !newHandles[key] && oldResolved.Handle == chatCfg.Handle {
+			removed = append(removed, oldResolved)
+		}
+	}
+
+	return added, removed, changed, updated
+}
+
+// backfillEqual reports whether two resolved chat configs backfill the
+// same way. A backfill change re-adds the chat so the new backfill runs;
+// any other change can be applied in place.
+func backfillEqual(a, b config.ResolvedChat) bool {
+	return a.Backfill == b.Backfill && a.BackfillSince == b.BackfillSince && a.BackfillScanLimit == b.BackfillScanLimit
+}
+
+// chatConfigEqual compares two resolved chat configs to detect changes,
+// including to the case of the handle, which is what gets shown.
+func chatConfigEqual(a, b config.ResolvedChat) bool {
+	if a.Handle != b.Handle || a.Storage != b.Storage {
+		return false
+	}
+	if a.AcceptAll != b.AcceptAll || a.Convert != b.Convert || a.ErrorNotifyTo != b.ErrorNotifyTo || a.NotifyLevel != b.NotifyLevel {
+		return false
+	}
+	if !backfillEqual(a, b) {
+		return false
+	}
+	if !reflect.DeepEqual(a.AcceptedFormats, b.AcceptedFormats) || !reflect.DeepEqual(a.NoConvertFormats, b.NoConvertFormats) {
+		return false
+	}
+	if a.RequireAll != b.RequireAll || !reflect.DeepEqual(a.AcceptedMimeTypes, b.AcceptedMimeTypes) {
+		return false
+	}
+	if !slices.Equal(a.PreferFormats, b.PreferFormats) {
+		return false
+	}
+	if !slices.Equal(a.OutputFormats, b.OutputFormats) || !slices.Equal(a.ConvertOptions, b.ConvertOptions) {
+		return false
+	}
+	if a.InputEncoding != b.InputEncoding || a.Language != b.Language || a.ConvertedExtension != b.ConvertedExtension {
+		return false
+	}
+	if a.MinFileSize != b.MinFileSize {
+		return false
+	}
+	return true
+}
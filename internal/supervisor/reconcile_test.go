@@ -0,0 +1,157 @@
+package supervisor
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/spacesedan/kpub/internal/config"
+)
+
+func TestReconcile(t *testing.T) {
+	no := false
+	defaults := config.DefaultsConfig{
+		AcceptedFormats: []string{".epub"},
+		Storage: config.StorageConfig{
+			Type:    "dropbox",
+			Dropbox: config.DropboxConfig{TokenFile: "/data/dropbox.json", UploadPath: "/Books"},
+		},
+	}
+	cfg := func(chats ...config.ChatConfig) *config.Config {
+		return &config.Config{Defaults: defaults, Chats: chats}
+	}
+	chat := func(handle string) config.ChatConfig { return config.ChatConfig{Handle: handle} }
+
+	tests := []struct {
+		name                             string
+		old, new                         *config.Config
+		added, removed, changed, updated []string
+	}{
+		{
+			name: "unchanged",
+			old:  cfg(chat("@a"), chat("@b")),
+			new:  cfg(chat("@a"), chat("@b")),
+		},
+		{
+			name:  "added",
+			old:   cfg(chat("@a")),
+			new:   cfg(chat("@a"), chat("@b")),
+			added: []string{"@b"},
+		},
+		{
+			name:    "removed",
+			old:     cfg(chat("@a"), chat("@b")),
+			new:     cfg(chat("@b")),
+			removed: []string{"@a"},
+		},
+		{
+			name:    "format-only change is applied in place",
+			old:     cfg(chat("@a")),
+			new:     cfg(config.ChatConfig{Handle: "@a", AcceptedFormats: []string{".pdf"}}),
+			updated: []string{"@a"},
+		},
+		{
+			name: "storage change is applied in place",
+			old:  cfg(chat("@a")),
+			new: cfg(config.ChatConfig{Handle: "@a", Storage: &config.StorageConfig{
+				Dropbox: config.DropboxConfig{UploadPath: "/Other"},
+			}}),
+			updated: []string{"@a"},
+		},
+		{
+			name:    "backfill change re-adds the chat",
+			old:     cfg(chat("@a")),
+			new:     cfg(config.ChatConfig{Handle: "@a", Backfill: 10}),
+			changed: []string{"@a"},
+		},
+		{
+			name:    "disabling a chat removes it",
+			old:     cfg(chat("@a"), chat("@b")),
+			new:     cfg(chat("@a"), config.ChatConfig{Handle: "@b", Enabled: &no}),
+			removed: []string{"@b"},
+		},
+		{
+			name:  "enabling a chat adds it",
+			old:   cfg(config.ChatConfig{Handle: "@a", Enabled: &no}),
+			new:   cfg(chat("@a")),
+			added: []string{"@a"},
+		},
+		{
+			name: "disabled in both is ignored",
+			old:  cfg(config.ChatConfig{Handle: "@a", Enabled: &no}),
+			new:  cfg(config.ChatConfig{Handle: "@a", Enabled: &no, AcceptedFormats: []string{".pdf"}}),
+		},
+		{
+			name:    "case-only handle change is updated, so the new case is shown",
+			old:     cfg(chat("@Books")),
+			new:     cfg(chat("@books")),
+			updated: []string{"@books"},
+		},
+		{
+			name: "IDs and links keep their case as the key",
+			old:  cfg(chat("https://t.me/+AbC")),
+			new:  cfg(chat("https://t.me/+abc")),
+			// Invite hashes are case-sensitive: a different link is a
+			// different chat.
+			added:   []string{"https://t.me/+abc"},
+			removed: []string{"https://t.me/+AbC"},
+		},
+		{
+			name:  "case-variant duplicates added once",
+			old:   cfg(),
+			new:   cfg(chat("@Books"), chat("@books")),
+			added: []string{"@Books"},
+		},
+		{
+			name:    "case-variant duplicates removed once",
+			old:     cfg(chat("@Books"), chat("@BOOKS")),
+			new:     cfg(),
+			removed: []string{"@Books"},
+		},
+		{
+			name: "a duplicate alongside the same chat is ignored",
+			old:  cfg(chat("@Books")),
+			new:  cfg(chat("@Books"), config.ChatConfig{Handle: "@books", AcceptedFormats: []string{".pdf"}}),
+		},
+		{
+			name:    "case-variant handle with a change is updated, not re-added",
+			old:     cfg(chat("@Books")),
+			new:     cfg(config.ChatConfig{Handle: "@books", AcceptedFormats: []string{".pdf"}}),
+			updated: []string{"@books"},
+		},
+		{
+			name:    "every kind at once, in config order",
+			old:     cfg(chat("@keep"), chat("@gone"), chat("@fmt"), chat("@fill")),
+			new:     cfg(config.ChatConfig{Handle: "@fill", Backfill: 5}, chat("@new"), config.ChatConfig{Handle: "@fmt", MinFileSize: "1KB"}, chat("@keep")),
+			added:   []string{"@new"},
+			removed: []string{"@gone"},
+			changed: []string{"@fill"},
+			updated: []string{"@fmt"},
+		},
+	}
+
+	handles := func(chats []config.ResolvedChat) []string {
+		var hs []string
+		for _, c := range chats {
+			hs = append(hs, c.Handle)
+		}
+		return hs
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			added, removed, changed, updated := Reconcile(tt.old, tt.new)
+			for _, r := range []struct {
+				kind      string
+				got, want []string
+			}{
+				{"added", handles(added), tt.added},
+				{"removed", handles(removed), tt.removed},
+				{"changed", handles(changed), tt.changed},
+				{"updated", handles(updated), tt.updated},
+			} {
+				if !slices.Equal(r.got, r.want) {
+					t.Errorf("%s = %q, want %q", r.kind, r.got, r.want)
+				}
+			}
+		})
+	}
+}
@@ -2,17 +2,25 @@ package supervisor
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
-	"reflect"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/fsnotify/fsnotify"
 
 	"github.com/spacesedan/kpub/internal/config"
+	"github.com/spacesedan/kpub/internal/converter"
+	"github.com/spacesedan/kpub/internal/history"
 	"github.com/spacesedan/kpub/internal/monitor"
+	"github.com/spacesedan/kpub/internal/setup"
 	"github.com/spacesedan/kpub/internal/storage"
+	"github.com/spacesedan/kpub/internal/throttle"
 )
 
 // Supervisor manages the lifecycle of a single Monitor, watching the config
@@ -20,35 +28,92 @@ import (
 type Supervisor struct {
 	configPath string
 	cfg        *config.Config
+	version    string // kpub's version, for the Telegram device info
 	ctx        context.Context
 	monitor    *monitor.Monitor
 	uploaders  map[string]storage.Uploader
-	mu         sync.Mutex
+	limiter    *throttle.Limiter
+
+	// mu guards cfg and uploaders.
+	mu sync.Mutex
+	// reloadMu is held for the whole of a reload so overlapping debounced
+	// reloads read and apply the config one at a time. mu is only taken to
+	// apply it, so edits from chat commands aren't held up by a reload
+	// waiting to read the file again.
+	reloadMu sync.Mutex
 }
 
 // New creates a Supervisor.
-func New(configPath string, cfg *config.Config, ctx context.Context) *Supervisor {
+func New(configPath string, cfg *config.Config, version string, ctx context.Context) *Supervisor {
+	// The rate was validated by config.Load.
+	rate, _ := throttle.ParseRate(cfg.Processing.BandwidthLimit)
+
 	return &Supervisor{
 		configPath: configPath,
 		cfg:        cfg,
+		version:    version,
 		ctx:        ctx,
 		uploaders:  make(map[string]storage.Uploader),
+		limiter:    throttle.New(rate),
 	}
 }
 
 // Run creates and starts the monitor, adds initial chats, then watches the
 // config file for changes. Blocks until the parent context is cancelled.
 func (s *Supervisor) Run() error {
+	if err := checkWritable(s.cfg); err != nil {
+		return err
+	}
+
+	// The engine names were validated by config.Load.
+	conv, _ := converter.NewChain(s.cfg.Processing.Converters)
+
 	// Create the monitor.
 	m := monitor.New(
 		s.cfg.Telegram.AppID,
 		s.cfg.Telegram.AppHash,
-		"/data/session.json",
+		s.cfg.Telegram.SessionFile,
 		s.cfg.Paths.DownloadDir,
 		s.cfg.Paths.ConvertedDir,
+		monitor.Options{
+			Debounce:          s.cfg.Processing.Debounce,
+			SessionPassphrase: os.Getenv(config.SessionPassphraseVar),
+			FileTimeout:       s.cfg.Processing.FileTimeout,
+			Limiter:           s.limiter,
+			PostProcess:       s.cfg.Processing.PostProcess,
+			Converter:         conv,
+			TestDC:            s.cfg.Telegram.TestDC,
+			DC:                s.cfg.Telegram.DC,
+			Device:            s.cfg.Telegram.Device,
+			Version:           s.version,
+			UpdateWatchdog:    max(s.cfg.Telegram.UpdateWatchdog, 0),
+			KeepAlive:         max(s.cfg.Telegram.KeepAlive, 0),
+			NotifyBotToken:    s.cfg.Telegram.NotifyBotToken,
+			NotifyChatID:      s.cfg.Telegram.NotifyChatID,
+			Workers:           s.cfg.Processing.Workers,
+			MaxQueue:          s.cfg.Processing.MaxQueue,
+			QueuePolicy:       s.cfg.Processing.QueuePolicy,
+			MaxDownloads:      s.cfg.Processing.MaxDownloads,
+			MaxConversions:    s.cfg.Processing.MaxConversions,
+			ConversionLimits:  s.cfg.Processing.ConversionLimits,
+			PreferWindow:      s.cfg.Processing.PreferWindow,
+			MaxMessageAge:     max(s.cfg.Processing.MaxMessageAge, 0),
+			UpdateBuffer:      max(s.cfg.Processing.UpdateBuffer, 0),
+			History:           history.Open(s.cfg.Paths.HistoryFile),
+			UploadState:       storage.NewResumeStore(s.cfg.Paths.UploadStateDir),
+			Jobs:              monitor.NewJobStore(filepath.Join(s.cfg.Paths.UploadStateDir, "jobs")),
+			KeepConverted:     s.cfg.Processing.KeepConverted,
+			KeepOnFailure:     s.cfg.Processing.KeepOnFailure,
+			UniqueNames:       s.cfg.Processing.UniqueNames,
+			Messages:          s.cfg.Messages,
+		},
 	)
 	s.monitor = m
 
+	if s.cfg.AdminCommands {
+		m.EnableAdminCommands(s)
+	}
+
 	// Start monitor in background.
 	monitorCtx, monitorCancel := context.WithCancel(s.ctx)
 	defer monitorCancel()
@@ -71,25 +136,61 @@ func (s *Supervisor) Run() error {
 	}
 
 	// Add all initial chats.
+	s.mu.Lock()
+	var monitored []string
+	failed := 0
 	for _, chatCfg := range s.cfg.Chats {
-		resolved := config.ResolvedChatConfig(s.cfg.Defaults, chatCfg)
+		resolved := config.ResolvedChatConfig(s.cfg, chatCfg)
+		if !resolved.Enabled {
+			slog.Info("Chat is disabled, not monitoring it", "handle", resolved.Handle)
+			continue
+		}
 		if err := s.addChat(resolved); err != nil {
 			slog.Error("Failed to add initial chat", "handle", resolved.Handle, "error", err)
+			failed++
+			continue
 		}
+		monitored = append(monitored, resolved.Handle)
 	}
+	startupNotification := s.cfg.StartupNotification
+	s.mu.Unlock()
 
-	// Set up file watcher.
-	watcher, err := fsnotify.NewWatcher()
-	if err != nil {
-		return fmt.Errorf("creating file watcher: %w", err)
+	if len(monitored) == 0 && failed == 0 {
+		slog.Info("No chats to monitor yet, waiting for some to be added to the config")
 	}
-	defer watcher.Close()
 
-	if err := watcher.Add(s.configPath); err != nil {
-		return fmt.Errorf("watching config file: %w", err)
+	if startupNotification {
+		msg := fmt.Sprintf("[kpub] Online, monitoring %d chat(s):\n%s", len(monitored), strings.Join(monitored, "\n"))
+		if failed > 0 {
+			msg += fmt.Sprintf("\n%d chat(s) could not be added, see the logs.", failed)
+		}
+		m.Notify(s.ctx, msg)
 	}
 
-	slog.Info("Watching config file for changes", "path", s.configPath)
+	// Set up file watcher. Config read from stdin or an env var can't change
+	// at runtime, so the watch channels stay nil and never fire.
+	var (
+		watcher *fsnotify.Watcher
+		events  <-chan fsnotify.Event
+		errs    <-chan error
+	)
+	if config.IsFile(s.configPath) {
+		var err error
+		watcher, err = fsnotify.NewWatcher()
+		if err != nil {
+			return fmt.Errorf("creating file watcher: %w", err)
+		}
+		defer watcher.Close()
+
+		if err := watcher.Add(s.configPath); err != nil {
+			return fmt.Errorf("watching config: %w", err)
+		}
+
+		events, errs = watcher.Events, watcher.Errors
+		slog.Info("Watching config file for changes", "path", s.configPath)
+	} else {
+		slog.Info("Config not loaded from a file, hot reload disabled", "source", s.configPath)
+	}
 
 	var debounce *time.Timer
 
@@ -110,12 +211,17 @@ func (s *Supervisor) Run() error {
 			}
 			return fmt.Errorf("monitor exited unexpectedly: %w", err)
 
-		case event, ok := <-watcher.Events:
+		case event, ok := <-events:
 			if !ok {
 				return nil
 			}
 
-			if event.Has(fsnotify.Write) || event.Has(fsnotify.Create) || event.Has(fsnotify.Rename) {
+			changed := event.Has(fsnotify.Write) || event.Has(fsnotify.Create) || event.Has(fsnotify.Rename)
+			if config.IsDir(s.configPath) {
+				// Deleting a chat fragment is also a change.
+				changed = changed || event.Has(fsnotify.Remove)
+			}
+			if changed {
 				if debounce != nil {
 					debounce.Stop()
 				}
@@ -126,7 +232,7 @@ func (s *Supervisor) Run() error {
 				})
 			}
 
-		case err, ok := <-watcher.Errors:
+		case err, ok := <-errs:
 			if !ok {
 				return nil
 			}
@@ -136,89 +242,267 @@ func (s *Supervisor) Run() error {
 }
 
 // addChat creates an uploader and registers a chat with the monitor.
+// s.mu must be held.
 func (s *Supervisor) addChat(resolved config.ResolvedChat) error {
-	tokenFile := resolved.Storage.Dropbox.TokenFile
-	uploader, ok := s.uploaders[tokenFile]
-	if !ok {
-		var err error
-		uploader, err = storage.NewUploader(resolved.Storage)
-		if err != nil {
-			return fmt.Errorf("creating uploader: %w", err)
-		}
-		s.uploaders[tokenFile] = uploader
+	uploader, err := s.uploader(resolved.Storage)
+	if err != nil {
+		return err
 	}
 
-	if err := s.monitor.AddChat(s.ctx, resolved.Handle, resolved.AcceptedFormats, uploader); err != nil {
+	if err := s.monitor.AddChat(s.ctx, resolved, uploader); err != nil {
 		return err
 	}
 
 	return nil
 }
 
-// reload reads the config file and reconciles the monitored chats.
+// updateChat applies changed settings to a monitored chat without
+// re-resolving it, or adds it if it isn't monitored, e.g. because adding it
+// failed earlier. s.mu must be held.
+func (s *Supervisor) updateChat(resolved config.ResolvedChat) error {
+	uploader, err := s.uploader(resolved.Storage)
+	if err != nil {
+		return err
+	}
+	if err := s.monitor.UpdateChat(s.ctx, resolved, uploader); err != nil {
+		slog.Info("Chat isn't monitored yet, adding it", "handle", resolved.Handle)
+		return s.monitor.AddChat(s.ctx, resolved, uploader)
+	}
+	return nil
+}
+
+// uploader returns the uploader for cfg, creating it the first time cfg is
+// seen. s.mu must be held.
+func (s *Supervisor) uploader(cfg config.StorageConfig) (storage.Uploader, error) {
+	key := uploaderKey(cfg)
+	if uploader, ok := s.uploaders[key]; ok {
+		return uploader, nil
+	}
+	uploader, err := storage.NewUploader(cfg, s.limiter)
+	if err != nil {
+		return nil, fmt.Errorf("creating uploader: %w", err)
+	}
+	if d, ok := uploader.(*storage.DropboxUploader); ok {
+		d.SetRefreshHook(s.dropboxRefreshHook(cfg.Dropbox.NotifyRefresh))
+	}
+	uploader = storage.Limit(uploader, cfg.MaxConcurrentUploads)
+	s.uploaders[key] = uploader
+	return uploader, nil
+}
+
+// dropUnusedUploaders forgets cached uploaders that no chat in s.cfg uses
+// any more, e.g. after a password change, so they don't pile up. Uploads
+// already running keep their uploader. s.mu must be held.
+func (s *Supervisor) dropUnusedUploaders() {
+	used := make(map[string]bool, len(s.cfg.Chats))
+	for _, chatCfg := range s.cfg.Chats {
+		used[uploaderKey(config.ResolvedChatConfig(s.cfg, chatCfg).Storage)] = true
+	}
+	for key := range s.uploaders {
+		if !used[key] {
+			delete(s.uploaders, key)
+		}
+	}
+}
+
+// refreshFailuresBeforeNotice is how many Dropbox token refreshes in a row
+// must fail before the user is told to re-authorize. A single failure is
+// often a network blip, and the upload that triggered it is retried anyway.
+const refreshFailuresBeforeNotice = 2
+
+// dropboxRefreshHook tells the user about Dropbox token refreshes: every
+// success if notifyAll is set, and once when refreshes keep failing.
+func (s *Supervisor) dropboxRefreshHook(notifyAll bool) storage.RefreshHook {
+	return func(err error, failures int) {
+		switch {
+		case err == nil && notifyAll:
+			s.monitor.Notify(s.ctx, "[kpub] Dropbox access token expired; re-authenticated with Dropbox.")
+		case failures == refreshFailuresBeforeNotice:
+			slog.Error("Dropbox token refresh keeps failing; the connection needs re-authorization", "failures", failures, "error", err)
+			s.monitor.Notify(s.ctx, fmt.Sprintf("[kpub] Your Dropbox connection needs re-authorization: refreshing the access token failed %d times in a row, so uploads will fail.\n"+
+				"Run `kpub setup` to connect Dropbox again, then `kpub reload`.\n%v", failures, err))
+		}
+	}
+}
+
+// uploaderKey identifies chats that can share an uploader: those with the
+// same backend settings and upload limit. An uploader keeps the settings it
+// was built with, so any setting it uses must be part of the key for a
+// change to take effect.
+func uploaderKey(cfg config.StorageConfig) string {
+	switch cfg.Type {
+	case "email":
+		return fmt.Sprintf("email:%d:%#v", cfg.MaxConcurrentUploads, cfg.Email)
+	case "b2":
+		return fmt.Sprintf("b2:%d:%#v", cfg.MaxConcurrentUploads, cfg.B2)
+	default:
+		return fmt.Sprintf("%s:%d:%#v", cfg.Type, cfg.MaxConcurrentUploads, cfg.Dropbox)
+	}
+}
+
+// reload reads the config file and reconciles the monitored chats. It is safe
+// to call concurrently: reloadMu covers both the read and the reconcile, so a
+// reload that started earlier can never overwrite a newer config.
 func (s *Supervisor) reload() {
+	s.reloadMu.Lock()
+	defer s.reloadMu.Unlock()
+
 	slog.Info("Config file changed, reloading...")
+	newCfg, err := s.loadWithRetry()
 
-	newCfg, err := config.Load(s.configPath)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if errors.Is(err, config.ErrNoChats) {
+		// An empty chat list is almost always a mistaken hand edit; keep
+		// monitoring the previous chats rather than silently doing nothing.
+		slog.Warn("!!! Config has no chats, ignoring this change and keeping the previous chats !!!",
+			"chats", len(s.cfg.Chats))
+		s.monitor.Notify(s.ctx, fmt.Sprintf("[kpub] Config has no chats. Still monitoring the previous %d chat(s); add a chat to apply changes.", len(s.cfg.Chats)))
+		return
+	}
 	if err != nil {
 		slog.Error("Failed to reload config, keeping existing chats", "error", err)
+		s.monitor.Notify(s.ctx, fmt.Sprintf("[kpub] Your config change could not be loaded, so the previous config is still in use. Fix it and save again.\n%v", err))
 		return
 	}
 
-	s.mu.Lock()
-	defer s.mu.Unlock()
+	added, removed, changed, updated := Reconcile(s.cfg, newCfg)
 
-	// Build map of new chat configs by handle.
-	newChats := make(map[string]config.ResolvedChat, len(newCfg.Chats))
-	for _, chatCfg := range newCfg.Chats {
-		resolved := config.ResolvedChatConfig(newCfg.Defaults, chatCfg)
-		newChats[resolved.Handle] = resolved
+	for _, chat := range removed {
+		slog.Info("Removing chat", "handle", chat.Handle)
+		s.monitor.RemoveChat(chat.Handle)
 	}
 
-	// Build map of old chat configs by handle.
-	oldChats := make(map[string]config.ResolvedChat, len(s.cfg.Chats))
-	for _, chatCfg := range s.cfg.Chats {
-		resolved := config.ResolvedChatConfig(s.cfg.Defaults, chatCfg)
-		oldChats[resolved.Handle] = resolved
+	// Update shared config.
+	s.cfg = newCfg
+	s.dropUnusedUploaders()
+
+	for _, chat := range changed {
+		slog.Info("Chat config changed, re-adding", "handle", chat.Handle)
+		s.monitor.RemoveChat(chat.Handle)
+		if err := s.addChat(chat); err != nil {
+			slog.Error("Failed to re-add chat after config change", "handle", chat.Handle, "error", err)
+		}
+	}
+	for _, chat := range updated {
+		slog.Info("Chat config changed, updating it in place", "handle", chat.Handle)
+		if err := s.updateChat(chat); err != nil {
+			slog.Error("Failed to update chat after config change", "handle", chat.Handle, "error", err)
+		}
+	}
+	for _, chat := range added {
+		slog.Info("Adding new chat", "handle", chat.Handle)
+		if err := s.addChat(chat); err != nil {
+			slog.Error("Failed to add new chat", "handle", chat.Handle, "error", err)
+		}
 	}
 
-	// Removed chats: in old, not in new.
-	for handle := range oldChats {
-		if _, exists := newChats[handle]; !exists {
-			slog.Info("Removing chat", "handle", handle)
-			s.monitor.RemoveChat(handle)
+	// Unchanged handles may still resolve to a different peer than before,
+	// e.g. when a username has been reassigned to another account. RefreshChat
+	// only looks up usernames it hasn't resolved recently, so a burst of
+	// reloads doesn't run into FLOOD_WAIT.
+	readded := make(map[string]bool, len(added)+len(changed))
+	for _, chat := range slices.Concat(added, changed) {
+		readded[chat.Handle] = true
+	}
+	for _, chatCfg := range newCfg.Chats {
+		if readded[chatCfg.Handle] || (chatCfg.Enabled != nil && !*chatCfg.Enabled) {
+			continue
+		}
+		moved, err := s.monitor.RefreshChat(s.ctx, chatCfg.Handle)
+		if err != nil {
+			slog.Warn("Failed to re-resolve chat, keeping previous peer", "handle", chatCfg.Handle, "error", err)
+			continue
+		}
+		if moved {
+			s.monitor.Notify(s.ctx, fmt.Sprintf("[kpub] %s now points at a different chat; monitoring the new one.", chatCfg.Handle))
 		}
 	}
+}
 
-	// Update shared config.
-	s.cfg = newCfg
+// Reload retry settings. Some editors save in two steps (truncate, then
+// write, or write a temp file, then rename), so the first read after a
+// change can see a half-written file.
+const (
+	reloadAttempts   = 3
+	reloadRetryDelay = 2 * time.Second
+)
 
-	// Added or changed chats.
-	for handle, newResolved := range newChats {
-		if oldResolved, exists := oldChats[handle]; exists {
-			if !chatConfigEqual(oldResolved, newResolved) {
-				slog.Info("Chat config changed, re-adding", "handle", handle)
-				s.monitor.RemoveChat(handle)
-				if err := s.addChat(newResolved); err != nil {
-					slog.Error("Failed to re-add chat after config change", "handle", handle, "error", err)
-				}
-			}
-		} else {
-			slog.Info("Adding new chat", "handle", handle)
-			if err := s.addChat(newResolved); err != nil {
-				slog.Error("Failed to add new chat", "handle", handle, "error", err)
-			}
+// loadConfig loads the config for a reload; tests replace it to control
+// when a reload reads the file.
+var loadConfig = config.Load
+
+// loadWithRetry loads the config, reading it again after reloadRetryDelay if
+// that fails, up to reloadAttempts times. It returns the last error. A config
+// without chats parses fine, so it is a deliberate edit and isn't retried.
+func (s *Supervisor) loadWithRetry() (*config.Config, error) {
+	for attempt := 1; ; attempt++ {
+		cfg, err := loadConfig(s.configPath)
+		if err == nil || errors.Is(err, config.ErrNoChats) || attempt == reloadAttempts {
+			return cfg, err
+		}
+		slog.Warn("Config failed to load, reading it again shortly", "attempt", attempt, "error", err)
+		select {
+		case <-s.ctx.Done():
+			return nil, err
+		case <-time.After(reloadRetryDelay):
 		}
 	}
 }
 
-// chatConfigEqual compares two resolved chat configs to detect changes.
-func chatConfigEqual(a, b config.ResolvedChat) bool {
-	if a.Storage != b.Storage {
-		return false
+var _ monitor.ChatEditor = (*Supervisor)(nil)
+
+// AddHandle appends a chat to the config file. The file watcher then picks up
+// the change and starts monitoring it.
+func (s *Supervisor) AddHandle(handle string) error {
+	if _, err := config.ParseChatRef(handle); err != nil {
+		return err
 	}
-	if !reflect.DeepEqual(a.AcceptedFormats, b.AcceptedFormats) {
-		return false
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, chat := range s.cfg.Chats {
+		if config.HandleKey(chat.Handle) == config.HandleKey(handle) {
+			return fmt.Errorf("chat %q already exists", handle)
+		}
 	}
-	return true
+
+	cfg := *s.cfg
+	cfg.Chats = append(slices.Clone(s.cfg.Chats), config.ChatConfig{Handle: handle})
+	return s.writeConfig(&cfg)
+}
+
+// RemoveHandle removes a chat from the config file. The file watcher then
+// picks up the change and stops monitoring it.
+func (s *Supervisor) RemoveHandle(handle string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	idx := slices.IndexFunc(s.cfg.Chats, func(c config.ChatConfig) bool { return config.HandleKey(c.Handle) == config.HandleKey(handle) })
+	if idx == -1 {
+		return fmt.Errorf("chat %q not found", handle)
+	}
+	if len(s.cfg.Chats) == 1 && !s.cfg.AllowEmpty {
+		return fmt.Errorf("cannot remove the only chat (set allow_empty to allow it)")
+	}
+
+	cfg := *s.cfg
+	cfg.Chats = slices.Delete(slices.Clone(s.cfg.Chats), idx, idx+1)
+	return s.writeConfig(&cfg)
+}
+
+// writeConfig persists cfg to the watched config file. s.mu must be held.
+func (s *Supervisor) writeConfig(cfg *config.Config) error {
+	if !config.IsFile(s.configPath) {
+		return fmt.Errorf("config was not loaded from a file and cannot be edited")
+	}
+	if config.IsDir(s.configPath) {
+		return fmt.Errorf("config is split across a directory; edit the chat files directly")
+	}
+	if err := setup.WriteConfigFile(s.configPath, cfg); err != nil {
+		return fmt.Errorf("writing config: %w", err)
+	}
+	return nil
 }
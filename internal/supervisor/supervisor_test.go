@@ -0,0 +1,150 @@
+package supervisor
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/spacesedan/kpub/internal/config"
+)
+
+// writeConfig replaces the config at path in one step, as editors that save
+// through a temp file do, so a reload never reads it half-written.
+func writeConfig(t *testing.T, path string, workers int) {
+	t.Helper()
+	data := fmt.Sprintf(`telegram:
+  app_id: 1
+  app_hash: "hash"
+defaults:
+  accepted_formats: [".epub"]
+  storage:
+    type: dropbox
+    dropbox:
+      app_key: "key"
+      app_secret: "secret"
+      token_file: "/data/dropbox.json"
+      upload_path: "/Books"
+processing:
+  workers: %d
+allow_empty: true
+chats: []
+`, workers)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestReloadConcurrent runs reloads concurrently with edits to the config.
+// Whatever order they run in, the last one applied must have read the final
+// version of the file, since a reload that started before an edit must not
+// overwrite one that started after it. Run it with -race.
+func TestReloadConcurrent(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	writeConfig(t, path, 1)
+	cfg, err := config.Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := New(path, cfg, "test", context.Background())
+
+	const edits = 20
+	var wg sync.WaitGroup
+	for v := 2; v <= edits; v++ {
+		writeConfig(t, path, v)
+		wg.Add(2)
+		go func() { defer wg.Done(); s.reload() }()
+		go func() { defer wg.Done(); s.reload() }()
+	}
+	wg.Wait()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if got := s.cfg.Processing.Workers; got != edits {
+		t.Errorf("after concurrent reloads, workers = %d, want %d from the last edit", got, edits)
+	}
+}
+
+// TestReloadStaleRead holds one reload between reading the config and
+// applying it while a later edit is reloaded. The later edit must win.
+func TestReloadStaleRead(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	writeConfig(t, path, 1)
+	cfg, err := config.Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := New(path, cfg, "test", context.Background())
+
+	loaded, release := make(chan struct{}), make(chan struct{})
+	var calls atomic.Int32
+	loadConfig = func(path string) (*config.Config, error) {
+		cfg, err := config.Load(path)
+		if calls.Add(1) == 1 {
+			close(loaded)
+			<-release
+		}
+		return cfg, err
+	}
+	t.Cleanup(func() { loadConfig = config.Load })
+
+	writeConfig(t, path, 2)
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() { defer wg.Done(); s.reload() }()
+	<-loaded
+
+	writeConfig(t, path, 3)
+	go func() { defer wg.Done(); s.reload() }()
+	// Give the second reload time to finish first if nothing stops it.
+	time.Sleep(100 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if got := s.cfg.Processing.Workers; got != 3 {
+		t.Errorf("workers = %d, want 3: a reload applied a config read before a newer one", got)
+	}
+}
+
+// TestReloadRetryDoesNotBlockEdits holds a reload while it reads the config,
+// as it does between retries, and checks that the state it guards can still
+// be used meanwhile, e.g. by a chat command adding a handle.
+func TestReloadRetryDoesNotBlockEdits(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	writeConfig(t, path, 1)
+	cfg, err := config.Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := New(path, cfg, "test", context.Background())
+
+	loading, release := make(chan struct{}), make(chan struct{})
+	loadConfig = func(path string) (*config.Config, error) {
+		close(loading)
+		<-release
+		return config.Load(path)
+	}
+	t.Cleanup(func() { loadConfig = config.Load })
+
+	done := make(chan struct{})
+	go func() { defer close(done); s.reload() }()
+	<-loading
+
+	locked := s.mu.TryLock()
+	if locked {
+		s.mu.Unlock()
+	}
+	close(release)
+	<-done
+	if !locked {
+		t.Error("reload holds the config lock while reading the config")
+	}
+}
@@ -0,0 +1,56 @@
+package supervisor
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/spacesedan/kpub/internal/config"
+)
+
+// checkWritable fails fast if any directory kpub writes to at runtime can't
+// be written, which usually means /data was mounted read-only. Otherwise the
+// first sign would be a failed download or a refreshed Dropbox token that is
+// silently lost.
+func checkWritable(cfg *config.Config) error {
+	dirs := []string{
+		filepath.Dir(cfg.Telegram.SessionFile),
+		cfg.Paths.DownloadDir,
+		cfg.Paths.ConvertedDir,
+		filepath.Dir(cfg.Paths.HistoryFile),
+		cfg.Paths.UploadStateDir,
+	}
+	// Token files are replaced by rename, so it's their directory that
+	// needs to be writable.
+	for _, chat := range cfg.Chats {
+		resolved := config.ResolvedChatConfig(cfg, chat)
+		if resolved.Storage.Type == "dropbox" {
+			dirs = append(dirs, filepath.Dir(resolved.Storage.Dropbox.TokenFile))
+		}
+	}
+
+	seen := make(map[string]bool)
+	for _, dir := range dirs {
+		if seen[dir] {
+			continue
+		}
+		seen[dir] = true
+		if err := probeDir(dir); err != nil {
+			return fmt.Errorf("the data directory is not writable (is the volume mounted read-only?): %w", err)
+		}
+	}
+	return nil
+}
+
+// probeDir creates dir if needed and writes and removes a scratch file in it.
+func probeDir(dir string) error {
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(dir, ".kpub-write-test-*")
+	if err != nil {
+		return err
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}